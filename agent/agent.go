@@ -2,6 +2,9 @@ package agent
 
 import (
 	"context"
+	"crypto/ed25519"
+	crand "crypto/rand"
+	"crypto/tls"
 	"errors"
 	"io"
 	"log"
@@ -13,6 +16,8 @@ import (
 	"time"
 
 	"github.com/advanderveer/brahms"
+	"github.com/advanderveer/brahms/broadcast"
+	"github.com/advanderveer/brahms/snow"
 	httpt "github.com/advanderveer/brahms/transport/http"
 )
 
@@ -24,16 +29,51 @@ type Agent struct {
 	core      *brahms.Core
 	handler   *httpt.Handler
 	transport brahms.Transport
+	http      *httpt.Transport
 	listener  net.Listener
 	server    *http.Server
 	params    brahms.P
+	policy    brahms.ViewPolicy
+	metrics   *agentMetrics
+
+	done       chan struct{}
+	reseedRate float64
+	probeOrder brahms.ProbeOrder
+	indirect   int
+	subnetCap  int
+
+	pushes struct {
+		rate  float64
+		burst int
+	}
+
+	state     *State
+	tree      *broadcast.Plumtree
+	plumtree  bool
+	snow      *snow.Topics
+	snowp     *snow.Params
+	stateSync struct {
+		fanout   int
+		interval time.Duration
+		last     time.Time
+	}
 
-	done chan struct{}
+	store    brahms.Store
+	snapshot struct {
+		interval time.Duration
+		last     time.Time
+	}
+
+	gossip struct {
+		period time.Duration
+		jitter float64
+	}
 
 	timeouts struct {
 		validate     time.Duration
 		update       time.Duration
 		invalidation time.Duration
+		suspicion    time.Duration
 		receive      time.Duration
 	}
 }
@@ -43,21 +83,84 @@ func New(logw io.Writer, cfg *Config) (a *Agent, err error) {
 	a = &Agent{
 		logs:   log.New(logw, "agent/agent: ", 0),
 		params: cfg.Params,
+		policy: cfg.ViewPolicy,
 		done:   make(chan struct{}),
 		rnd:    rand.New(cryptoSource{}),
+
+		metrics: newAgentMetrics(),
+
+		reseedRate: cfg.ReseedRate,
+		probeOrder: cfg.ProbeOrder,
+		indirect:   cfg.IndirectProbes,
+		subnetCap:  cfg.SubnetCap,
+		plumtree:   cfg.Plumtree,
+		snowp:      cfg.Snow,
+	}
+
+	// the view policy defaults to always refreshing, like the core's
+	if a.policy == nil {
+		a.policy = brahms.AlwaysRefresh
+	}
+
+	if cfg.DataDir != "" {
+		a.store = brahms.NewFileStore(cfg.DataDir)
+		a.snapshot.interval = cfg.SnapshotInterval
 	}
 
+	a.gossip.period = cfg.GossipPeriod
+	a.gossip.jitter = cfg.GossipJitter
+	if a.gossip.period <= 0 {
+		a.gossip.period = cfg.UpdateTimeout
+	}
 	a.timeouts.validate = cfg.ValidateTimeout
 	a.timeouts.update = cfg.UpdateTimeout
 	a.timeouts.invalidation = cfg.InvalidationTimeout
+	a.timeouts.suspicion = cfg.SuspicionTimeout
+	a.pushes.rate = cfg.PushRate
+	a.pushes.burst = cfg.PushBurst
+	a.stateSync.fanout = cfg.StateFanout
+	a.stateSync.interval = cfg.AntiEntropyInterval
 	a.timeouts.receive = cfg.ReceiveTimeout
 
+	// without pacing the rounds would hammer every peer
+	if a.gossip.period <= 0 {
+		return nil, Err{errors.New("gossip period must be positive"), "gossip_period"}
+	}
+
+	if !(a.gossip.jitter >= 0 && a.gossip.jitter < 1) {
+		return nil, Err{brahms.ErrJitterRange, "gossip_jitter"}
+	}
+
+	if err = cfg.Meta.Validate(); err != nil {
+		return nil, Err{err, "meta"}
+	}
+
+	if cfg.Snow != nil {
+		if _, err = snow.NewParams(cfg.Snow.K, cfg.Snow.Alpha, cfg.Snow.Beta); err != nil {
+			return nil, Err{err, "snow"}
+		}
+	}
+
+	key := cfg.Key
+	if key == nil {
+		_, key, err = ed25519.GenerateKey(crand.Reader)
+		if err != nil {
+			return nil, Err{err, "key_generation"}
+		}
+	}
+
+	cert, err := httpt.Certificate(key)
+	if err != nil {
+		return nil, Err{err, "certificate"}
+	}
+
 	a.listener, err = net.Listen("tcp", cfg.ListenAddr.String()+":"+strconv.Itoa(int(cfg.ListenPort)))
 	if err != nil {
 		return nil, Err{err, "listen"}
 	}
 
-	a.self = brahms.N(cfg.AdvertiseAddr.String(), cfg.AdvertisePort)
+	a.self = brahms.NK(cfg.AdvertiseAddr.String(), cfg.AdvertisePort, key.Public().(ed25519.PublicKey))
+	a.self.Meta = cfg.Meta.Copy()
 	if a.self.IP == nil {
 		a.self.IP = net.ParseIP(a.listener.Addr().(*net.TCPAddr).IP.String())
 	}
@@ -66,15 +169,92 @@ func New(logw io.Writer, cfg *Config) (a *Agent, err error) {
 		a.self.Port = uint16(a.listener.Addr().(*net.TCPAddr).Port)
 	}
 
-	a.transport = httpt.New(logw)
+	a.state = NewState(*a.self, key)
+
+	// peers talk to each other over mutually authenticated tls
+	a.listener = tls.NewListener(a.listener, httpt.ServerTLS(cert))
+	a.http = httpt.NewTLS(logw, cert)
+	a.transport = &metricsTransport{a.http, a.metrics}
 	return
 }
 
 // Self returns info about this agent as a node in the network
 func (a *Agent) Self() brahms.Node {
+	if a.core != nil {
+		return a.core.Self()
+	}
+
 	return *a.self
 }
 
+// SetMeta replaces the metadata the agent advertises to its peers
+func (a *Agent) SetMeta(m brahms.Meta) (err error) {
+	if a.core == nil {
+		if err = m.Validate(); err != nil {
+			return Err{err, "meta"}
+		}
+
+		a.self.Meta, a.self.Ver = m.Copy(), a.self.Ver+1
+		return nil
+	}
+
+	if err = a.core.SetMeta(m); err != nil {
+		return Err{err, "meta"}
+	}
+
+	return nil
+}
+
+// Sample returns a copy of the agent's sample of the network, it is empty
+// until the agent joined.
+func (a *Agent) Sample() brahms.View {
+	if a.core == nil {
+		return brahms.View{}
+	}
+
+	return a.core.Sample()
+}
+
+// Snow returns the consensus on topics of the agent, it is nil unless the
+// agent joined with snow parameters configured. Undecided topics are decided
+// on in every round of the agent.
+func (a *Agent) Snow() *snow.Topics {
+	return a.snow
+}
+
+// Set returns consensus on the set with the topic, it can only be called after
+// the agent joined with snow parameters configured.
+func (a *Agent) Set(topic string) (s *snow.Set, err error) {
+	if a.snow == nil {
+		return nil, Err{errors.New("snow is not enabled"), "set"}
+	}
+
+	s, err = snow.NewSet(topic, a.snow)
+	if err != nil {
+		return nil, Err{err, "set"}
+	}
+
+	return s, nil
+}
+
+// Tree returns the epidemic broadcast tree of the agent, it is nil unless the
+// agent joined with plumtree enabled.
+func (a *Agent) Tree() *broadcast.Plumtree {
+	return a.tree
+}
+
+// EmitTo emits the message to a single peer
+func (a *Agent) EmitTo(ctx context.Context, msg []byte, to brahms.Node) (err error) {
+	a.metrics.emitsSent.Inc()
+	err = a.transport.Emit(ctx, msg, to)
+	if err != nil {
+		return err
+	}
+
+	a.metrics.emitsSucceeded.Inc()
+	return nil
+}
+
 // Emit dissemates the message to N peers and succeeds unless less than m
 // peers responded with success
 func (a *Agent) Emit(msg []byte, n, m int, to time.Duration) (ok bool) {
@@ -92,7 +272,16 @@ func (a *Agent) Emit(msg []byte, n, m int, to time.Duration) (ok bool) {
 			wg.Add(1)
 
 			// run transport request in separate routines
-			go func(id brahms.NID, p brahms.Node) { a.transport.Emit(ctx, emits, id, msg, p); wg.Done() }(id, p)
+			go func(id brahms.NID, p brahms.Node) {
+				defer wg.Done()
+				err := a.transport.Emit(ctx, msg, p)
+				if err != nil {
+					a.logs.Printf("failed to emit to %s: %v", p.String(), err)
+					return
+				}
+
+				emits <- id
+			}(id, p)
 		}
 
 		//wait for them to finish, context will cancel if it takes too long
@@ -113,6 +302,8 @@ DRAIN:
 		}
 	}
 
+	a.metrics.emitsSent.Add(uint64(len(peers)))
+	a.metrics.emitsSucceeded.Add(uint64(len(oks)))
 	if len(oks) < m {
 		return false
 	}
@@ -139,15 +330,61 @@ func (a *Agent) Receive() (msg []byte, err error) {
 	return
 }
 
+// Events returns a subscription on membership changes of the agent with a
+// buffer of n events. It can only be called after the agent joined.
+func (a *Agent) Events(n int) (sub *brahms.Subscription, err error) {
+	if a.core == nil {
+		return nil, Err{errors.New("uninitialized core"), "events"}
+	}
+
+	return a.core.Subscribe(n), nil
+}
+
 // Join the network and starts the protocol
 func (a *Agent) Join(v brahms.View) {
-	a.core = brahms.NewCore(a.rnd, a.self, v, a.params, a.transport, a.timeouts.invalidation)
+	a.core = brahms.NewCore(a.rnd, a.self, v, a.params, a.policy, a.transport, a.timeouts.invalidation)
+	a.core.SetProbeOrder(a.probeOrder)
+	a.core.SetIndirectProbes(a.indirect)
+	a.core.SetSuspicionTimeout(a.timeouts.suspicion)
+	a.core.SetSubnetCap(a.subnetCap)
+	a.core.SetGossipPeriod(a.gossip.period, a.gossip.jitter) //validated by New
+	if a.store != nil {
+		snap, err := a.store.Load()
+		if err == nil {
+			err = a.core.Restore(snap)
+		}
+
+		if err != nil && err != brahms.ErrNoSnapshot {
+			a.logs.Printf("failed to restore snapshot, starting fresh: %v", err)
+		}
+	}
+
+	a.metrics.observe(a.core)
 	a.handler = httpt.NewHandler(a.core, 1, a.timeouts.receive)
+	a.handler.SetPushRate(a.pushes.rate, a.pushes.burst)
+	if a.plumtree {
+		a.tree = broadcast.NewPlumtree(a.Self(), a, a.http)
+		a.handler.SetTree(a.tree)
+	}
+
+	if a.snowp != nil {
+		q := snow.NewSampleQuerier(rand.New(cryptoSource{}), a, a.http)
+		q.SetTimeout(a.timeouts.receive)
+		a.snow = snow.NewTopics(q, *a.snowp)
+		a.handler.SetSnow(a.snow)
+	}
+
+	// metrics are served next to the protocol's endpoints
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", a.metrics.reg)
+	mux.HandleFunc("/state", a.serveState)
+	mux.Handle("/", a.handler)
 	a.server = &http.Server{
-		Handler:      a.handler,
+		Handler:      mux,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  120 * time.Second,
+		ErrorLog:     a.logs,
 	}
 
 	// start serving http requests
@@ -163,29 +400,63 @@ func (a *Agent) Join(v brahms.View) {
 	// start the protocol loop
 	go func() {
 		for {
+			start := time.Now()
 			a.core.UpdateView(a.timeouts.update)
 			a.core.ValidateSample(a.timeouts.validate)
+			a.core.ReseedSample(a.reseedRate)
+
+			ctx, cancel := context.WithTimeout(context.Background(), a.timeouts.update)
+			a.gossipState(ctx)
+			cancel()
+
+			// decisions that peers brought in are driven by our rounds as well
+			if a.snow != nil {
+				ctx, cancel = context.WithTimeout(context.Background(), a.timeouts.update)
+				a.snow.Round(ctx)
+				cancel()
+			}
 
+			a.metrics.rounds.Inc()
+			if a.store != nil && time.Since(a.snapshot.last) >= a.snapshot.interval {
+				a.saveSnapshot()
+			}
+
+			// rounds end early, wait for the rest of the gossip period
 			select {
 			case <-a.done:
 				a.done <- struct{}{}
 				return
-			default:
+			case <-time.After(a.core.GossipPeriod() - time.Since(start)):
 			}
 		}
 	}()
 }
 
+// saveSnapshot persists the core's state to the store
+func (a *Agent) saveSnapshot() {
+	a.snapshot.last = time.Now()
+	err := a.store.Save(a.core.Snapshot())
+	if err != nil {
+		a.logs.Printf("failed to save snapshot: %v", err)
+	}
+}
+
 // Shutdown attempts to close the agent gracefully
 func (a *Agent) Shutdown(ctx context.Context) (err error) {
 	if a.core == nil {
 		return a.listener.Close()
 	}
 
-	a.core.Deactivate()
 	a.done <- struct{}{}
 	<-a.done
 
+	// save our state before deactivation clears it, such that we rejoin with it
+	if a.store != nil {
+		a.saveSnapshot()
+	}
+
+	a.core.Deactivate()
+
 	err = a.server.Shutdown(ctx)
 	if err != nil {
 		return Err{err, "shutdown"}
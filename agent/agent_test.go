@@ -1,8 +1,11 @@
 package agent_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
@@ -11,13 +14,29 @@ import (
 
 	"github.com/advanderveer/brahms"
 	"github.com/advanderveer/brahms/agent"
+	"github.com/advanderveer/brahms/snow"
+	httpt "github.com/advanderveer/brahms/transport/http"
 	"github.com/advanderveer/go-test"
 )
 
 func TestAgentInit(t *testing.T) {
 	cfg1 := agent.LocalTestConfig()
-	cfg1.ListenAddr = nil
+	cfg1.ViewPolicy = nil //should default to always refreshing
+	cfg1.GossipPeriod, cfg1.UpdateTimeout = 0, 0
 	_, err := agent.New(os.Stderr, cfg1)
+	test.Equals(t, "gossip_period", err.(agent.Err).Op)
+
+	cfg1.GossipPeriod, cfg1.GossipJitter = time.Second, 1
+	_, err = agent.New(os.Stderr, cfg1)
+	test.Equals(t, "gossip_jitter", err.(agent.Err).Op)
+	test.Equals(t, brahms.ErrJitterRange, err.(agent.Err).E)
+
+	cfg1.GossipJitter = 0.1
+
+	cfg1.GossipPeriod = 0 //should default to the update timeout
+	cfg1.UpdateTimeout = time.Millisecond * 200
+	cfg1.ListenAddr = nil
+	_, err = agent.New(os.Stderr, cfg1)
 	test.Equals(t, "listen", err.(agent.Err).Op)
 
 	cfg1.ListenAddr = net.IP{127, 0, 0, 1}
@@ -35,14 +54,41 @@ func TestAgentInit(t *testing.T) {
 	test.Ok(t, err)
 	self2 = a.Self()
 
+	// events can only be subscribed to after joining
+	_, err = a.Events(1)
+	test.Equals(t, "events", err.(agent.Err).Op)
+
 	// then start an enmpty group
 	a.Join(brahms.NewView())
+	sub, err := a.Events(1)
+	test.Ok(t, err)
 
+	// should only be reachable over tls, with a certificate that holds our key
 	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/probe", self2.Port))
 	test.Ok(t, err)
+	test.Equals(t, http.StatusBadRequest, resp.StatusCode)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err = client.Get(fmt.Sprintf("https://127.0.0.1:%d/probe", self2.Port))
+	test.Ok(t, err)
 	test.Equals(t, http.StatusOK, resp.StatusCode)
+	test.Ok(t, httpt.VerifyPeer(resp.TLS, self2))
+
+	// metrics are served next to the protocol
+	resp, err = client.Get(fmt.Sprintf("https://127.0.0.1:%d/metrics", self2.Port))
+	test.Ok(t, err)
+	test.Equals(t, http.StatusOK, resp.StatusCode)
+	body, _ := ioutil.ReadAll(resp.Body)
+	for _, name := range []string{
+		"brahms_rounds_total", "brahms_pushes_received_total", "brahms_probe_duration_seconds_count",
+		"brahms_view_frozen_rounds_total", "brahms_emit_success_ratio",
+	} {
+		test.Assert(t, bytes.Contains(body, []byte("\n"+name+" ")), "should expose %s", name)
+	}
 
 	test.Ok(t, a.Shutdown(context.Background()))
+	for range sub.C {
+	} //should be closed on shutdown
 }
 
 func TestSmallAgentNetwork(t *testing.T) {
@@ -86,3 +132,92 @@ func TestSmallAgentNetwork(t *testing.T) {
 	<-done
 	<-done
 }
+
+func TestAgentRestartFromSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brahms_agent_")
+	test.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	a1, err := agent.New(os.Stderr, agent.LocalTestConfig())
+	test.Ok(t, err)
+	a1.Join(brahms.NewView())
+	defer a1.Shutdown(context.Background())
+
+	cfg := agent.LocalTestConfig()
+	cfg.DataDir = dir
+	a2, err := agent.New(os.Stderr, cfg)
+	test.Ok(t, err)
+
+	self1 := a1.Self()
+	a2.Join(brahms.NewView(&self1))
+	time.Sleep(time.Millisecond * 400)
+	test.Ok(t, a2.Shutdown(context.Background()))
+
+	// without a bootstrap node, the restarted agent should still know its peer
+	a2, err = agent.New(os.Stderr, cfg)
+	test.Ok(t, err)
+	a2.Join(brahms.NewView())
+	defer a2.Shutdown(context.Background())
+
+	test.Equals(t, true, a2.Emit([]byte("foo"), 1, 1, time.Second))
+}
+
+func TestAgentSnow(t *testing.T) {
+	cfg := agent.LocalTestConfig()
+	cfg.Snow = &snow.Params{K: 2, Alpha: 1, Beta: 3}
+	_, err := agent.New(os.Stderr, cfg)
+	test.Equals(t, snow.ErrAlphaRange, err.(agent.Err).E)
+
+	n := 5
+	agents := make([]*agent.Agent, 0, n)
+	for i := 0; i < n; i++ {
+		cfg := agent.LocalTestConfig()
+		cfg.Snow = &snow.Params{K: 2, Alpha: 2, Beta: 3}
+
+		a, err := agent.New(os.Stderr, cfg)
+		test.Ok(t, err)
+		defer a.Shutdown(context.Background())
+		agents = append(agents, a)
+	}
+
+	for _, a := range agents {
+		first := agents[0].Self()
+		a.Join(brahms.NewView(&first))
+	}
+
+	// agents without a preference adopt the proposal when they're queried
+	_, err = agents[0].Snow().Propose("leader", snow.CID{0x01})
+	test.Ok(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	decided, errs := make(chan snow.CID, n), make(chan error, n)
+	for _, a := range agents {
+		go func(a *agent.Agent) {
+			c, err := a.Snow().Await(ctx, "leader", time.Millisecond*50)
+			decided <- c
+			errs <- err
+		}(a)
+	}
+
+	for i := 0; i < n; i++ {
+		test.Equals(t, snow.CID{0x01}, <-decided)
+		test.Ok(t, <-errs)
+	}
+
+	// sets are decided over the same topics, e.g. after a proposal is broadcast
+	elems := []snow.Elem{{ID: snow.CID{0x01}, Conflict: "a"}, {ID: snow.CID{0x02}, Conflict: "b"}}
+	for _, a := range agents {
+		s, err := a.Set("txs")
+		test.Ok(t, err)
+		test.Ok(t, s.Propose(elems...))
+	}
+
+	for _, a := range agents {
+		s, _ := a.Set("txs")
+		accepted, err := s.Await(ctx, time.Millisecond*50)
+		test.Ok(t, err)
+		test.Equals(t, map[string]snow.CID{"a": {0x01}, "b": {0x02}}, accepted)
+	}
+}
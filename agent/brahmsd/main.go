@@ -3,9 +3,9 @@ package main
 import (
 	"bufio"
 	"context"
-	"crypto/sha256"
 	"fmt"
 	"log"
+	"math/rand"
 	"net"
 	"os"
 	"os/signal"
@@ -14,10 +14,11 @@ import (
 
 	"github.com/advanderveer/brahms"
 	"github.com/advanderveer/brahms/agent"
+	"github.com/advanderveer/brahms/broadcast"
 )
 
 func main() {
-	sigs := make(chan os.Signal)
+	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, os.Interrupt)
 
 	cfg := agent.LocalTestConfig()
@@ -26,6 +27,12 @@ func main() {
 		cfg.ListenPort = uint16(port)
 	}
 
+	// with a data dir the agent rejoins with its previous sample after a restart
+	cfg.DataDir = os.Getenv("DATA_DIR")
+
+	// with plumtree enabled messages are broadcast over a tree instead of flooded
+	cfg.Plumtree = os.Getenv("PLUMTREE") != ""
+
 	cfg.UpdateTimeout = time.Second * 1
 	cfg.ValidateTimeout = time.Second * 1
 
@@ -51,40 +58,32 @@ func main() {
 
 	a.Join(v)
 
-	// start reading messages, dedublicate and prevent message storm
-	go func() {
-		received := map[[32]byte]struct{}{}
-
-		for {
-			msg, err := a.Receive()
-			if msg == nil || err != nil {
-				break
-			}
-
-			h := sha256.Sum256(msg)
-			if _, ok := received[h]; ok {
-				continue //already received
-			}
+	// broadcast messages to the whole network, the broadcaster deduplicates
+	// and limits how far and long messages are relayed
+	var b interface {
+		Broadcast(data []byte) (broadcast.MID, error)
+	}
 
-			fmt.Println("new message, relaying:", msg)
-			if len(msg) > 0 {
-				if a.Emit(msg, 2, 1, time.Second) {
-					received[h] = struct{}{}
-				}
-			}
-		}
-	}()
+	deliver := func(m broadcast.Msg) { fmt.Println("new message:", string(m.Data)) }
+	if t := a.Tree(); t != nil {
+		t.OnDeliver(deliver)
+		b = t
+	} else {
+		fb := broadcast.New(rand.New(rand.NewSource(time.Now().UnixNano())), a)
+		fb.OnDeliver(deliver)
+		go fb.Listen(a)
+		b = fb
+	}
 
-	// start emittin messages from the terminal
+	// start broadcasting messages from the terminal
 	stat, _ := os.Stdin.Stat()
 	if (stat.Mode() & os.ModeCharDevice) != 0 {
 		go func() {
 			scanner := bufio.NewScanner(os.Stdin)
-			for {
-				scanner.Scan()
+			for scanner.Scan() {
 				msg := scanner.Bytes()
 				if len(msg) > 0 {
-					fmt.Println(a.Emit(msg, 1, 1, time.Second))
+					fmt.Println(b.Broadcast(append([]byte{}, msg...)))
 				}
 			}
 		}()
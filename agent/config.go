@@ -1,10 +1,12 @@
 package agent
 
 import (
+	"crypto/ed25519"
 	"net"
 	"time"
 
 	"github.com/advanderveer/brahms"
+	"github.com/advanderveer/brahms/snow"
 )
 
 //Config configures the agent
@@ -20,7 +22,72 @@ type Config struct {
 	InvalidationTimeout time.Duration
 	ReceiveTimeout      time.Duration
 
+	// GossipPeriod is the time between the start of two rounds, independent of
+	// how long a round takes. It is randomly changed by up to a fraction of
+	// GossipJitter each round. It defaults to the UpdateTimeout.
+	GossipPeriod time.Duration
+	GossipJitter float64
+
+	// ReseedRate is the fraction of sample slots that get a new seed every
+	// round of the protocol. Such that adversarial ids that took a slot are
+	// eventually flushed out.
+	ReseedRate float64
+
+	// ProbeOrder determines how the samples are picked for validation
+	ProbeOrder brahms.ProbeOrder
+
+	// IndirectProbes is the nr of other samples that are asked to probe a
+	// sample that didn't respond, before it is invalidated.
+	IndirectProbes int
+
+	// SuspicionTimeout is how long a sample that stopped responding is
+	// suspected of being dead before it is invalidated
+	SuspicionTimeout time.Duration
+
+	// PushRate limits the pushes per second each source address can do, with
+	// bursts of PushBurst. No limit is applied if it is zero.
+	PushRate  float64
+	PushBurst int
+
+	// SubnetCap limits how many nodes from the same IPv4 /24 or IPv6 /48 can
+	// be in the view and sample, no cap is applied if it is zero.
+	SubnetCap int
+
+	// StateFanout is the nr of samples the agent exchanges recent state
+	// updates with each round, state is not gossiped if it is zero. Every
+	// AntiEntropyInterval it also syncs all state with one of them, to repair
+	// updates that were missed.
+	StateFanout         int
+	AntiEntropyInterval time.Duration
+
+	// Plumtree enables broadcasting over an epidemic broadcast tree that is
+	// build over the agent's sample, see Agent.Tree.
+	Plumtree bool
+
+	// Snow enables consensus on topics by querying the agent's sample with
+	// these parameters, see Agent.Snow. It is disabled if it is nil.
+	Snow *snow.Params
+
+	// Meta is advertised to peers with the agent's node info, e.g. its role
+	// or zone. It is bounded by brahms.MaxMetaEntries and brahms.MaxMetaSize.
+	Meta brahms.Meta
+
 	Params brahms.P
+
+	// ViewPolicy decides when the view is refreshed, policies that keep
+	// state can not be shared between agents. It defaults to always
+	// refreshing.
+	ViewPolicy brahms.ViewPolicy
+
+	// DataDir is the directory the agent stores a snapshot of its sample and
+	// view in, such that it can rejoin with it after a restart. Nothing is
+	// stored if it is empty.
+	DataDir          string
+	SnapshotInterval time.Duration
+
+	// Key identifies the agent to its peers, a new key is generated if none
+	// is configured.
+	Key ed25519.PrivateKey
 }
 
 // LocalTestConfig returns a sensible default config for local testing
@@ -32,8 +99,23 @@ func LocalTestConfig() (cfg *Config) {
 		UpdateTimeout:       time.Millisecond * 200,
 		InvalidationTimeout: time.Second * 5,
 		ReceiveTimeout:      time.Second,
+		GossipPeriod:        time.Millisecond * 200,
+		GossipJitter:        0.1,
+		ReseedRate:          0.05,
+		ProbeOrder:          brahms.RoundRobinProbeOrder,
+		IndirectProbes:      2,
+		SuspicionTimeout:    time.Second,
+		SnapshotInterval:    time.Second * 10,
+		ViewPolicy:          brahms.AlwaysRefresh,
+		StateFanout:         2,
+		AntiEntropyInterval: time.Second,
+
+		// NOTE: local agents all share 127.0.0.1, so pushes are not limited
+		// per source and subnets are not capped.
+		PushRate:  0,
+		SubnetCap: 0,
 	}
 
-	cfg.Params, _ = brahms.NewParams(0.45, 0.45, 0.1, 10, 10, 2)
+	cfg.Params, _ = brahms.NewParams(0.45, 0.45, 0.1, 10, 10, 2, 8)
 	return
 }
@@ -0,0 +1,168 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/advanderveer/brahms"
+	"github.com/advanderveer/brahms/metrics"
+)
+
+// agentMetrics are the counters and histograms of the protocol loop. Metrics
+// of the core are read from the core that is observed last, such that they are
+// only registered once while the agent can join many times.
+type agentMetrics struct {
+	reg     *metrics.Registry
+	core    atomic.Value
+	sub     atomic.Value
+	dropped uint64
+
+	rounds         *metrics.Counter
+	pushesSent     *metrics.Counter
+	pullsSucceeded *metrics.Counter
+	pullsFailed    *metrics.Counter
+	pullsRejected  *metrics.Counter
+	pullRejections *metrics.Counter
+	probeLatency   *metrics.Histogram
+	probesFailed   *metrics.Counter
+	invalidations  *metrics.Counter
+	frozenRounds   *metrics.Counter
+	emitsSent      *metrics.Counter
+	emitsSucceeded *metrics.Counter
+
+	stateExchanges       *metrics.Counter
+	stateExchangesFailed *metrics.Counter
+	stateApplied         *metrics.Counter
+	stateRejected        *metrics.Counter
+}
+
+func newAgentMetrics() (m *agentMetrics) {
+	m = &agentMetrics{reg: metrics.NewRegistry()}
+	m.rounds = m.reg.Counter("brahms_rounds_total", "Nr of rounds of the protocol loop.")
+	m.pushesSent = m.reg.Counter("brahms_pushes_sent_total", "Nr of pushes sent to peers.")
+	m.pullsSucceeded = m.reg.Counter("brahms_pulls_succeeded_total", "Nr of pulls that returned a view.")
+	m.pullsFailed = m.reg.Counter("brahms_pulls_failed_total", "Nr of pulls that failed or timed out.")
+	m.pullsRejected = m.reg.Counter("brahms_pulls_rejected_total", "Nr of pull responses that held rejected nodes.")
+	m.pullRejections = m.reg.Counter("brahms_pull_nodes_rejected_total", "Nr of nodes in pull responses that were rejected as oversized or malformed.")
+	m.probeLatency = m.reg.Histogram("brahms_probe_duration_seconds", "Latency of successful probes.", metrics.DefaultLatencyBuckets)
+	m.probesFailed = m.reg.Counter("brahms_probes_failed_total", "Nr of probes that failed or timed out.")
+	m.invalidations = m.reg.Counter("brahms_invalidations_total", "Nr of samples invalidated for not responding.")
+	m.frozenRounds = m.reg.Counter("brahms_view_frozen_rounds_total", "Nr of rounds the view was not updated due to a push flood.")
+	m.emitsSent = m.reg.Counter("brahms_emits_total", "Nr of messages emitted to peers.")
+	m.emitsSucceeded = m.reg.Counter("brahms_emits_succeeded_total", "Nr of emitted messages that were accepted by the peer.")
+	m.stateExchanges = m.reg.Counter("brahms_state_exchanges_total", "Nr of state exchanges with peers.")
+	m.stateExchangesFailed = m.reg.Counter("brahms_state_exchanges_failed_total", "Nr of state exchanges that failed or timed out.")
+	m.stateApplied = m.reg.Counter("brahms_state_updates_applied_total", "Nr of state entries received from peers that were newer.")
+	m.stateRejected = m.reg.Counter("brahms_state_updates_rejected_total", "Nr of state entries received from peers that exceeded the bounds.")
+	m.reg.GaugeFunc("brahms_emit_success_ratio", "Ratio of emitted messages that were accepted by the peer.", func() float64 {
+		if m.emitsSent.Value() == 0 {
+			return 0
+		}
+
+		return float64(m.emitsSucceeded.Value()) / float64(m.emitsSent.Value())
+	})
+
+	m.reg.CounterFunc("brahms_pushes_received_total", "Nr of pushes received with a valid stamp.", m.withCore(func(c *brahms.Core) float64 {
+		return float64(c.Stats().PushesReceived)
+	}))
+	m.reg.CounterFunc("brahms_pushes_rejected_total", "Nr of pushes received with an invalid stamp.", m.withCore(func(c *brahms.Core) float64 {
+		return float64(c.Stats().PushesRejected)
+	}))
+	m.reg.CounterFunc("brahms_pushes_dropped_total", "Nr of valid pushes dropped because the push buffer was full.", m.withCore(func(c *brahms.Core) float64 {
+		return float64(c.Stats().PushesDropped)
+	}))
+	m.reg.GaugeFunc("brahms_view_size", "Nr of nodes in the view.", m.withCore(func(c *brahms.Core) float64 {
+		return float64(len(c.ReadView()))
+	}))
+	m.reg.GaugeFunc("brahms_sample_size", "Nr of nodes in the sample.", m.withCore(func(c *brahms.Core) float64 {
+		return float64(len(c.Sample()))
+	}))
+	m.reg.GaugeFunc("brahms_network_size_estimate", "Estimated nr of nodes in the network.", m.withCore((*brahms.Core).EstimateSize))
+	m.reg.CounterFunc("brahms_metric_events_dropped_total", "Nr of events the metrics didn't keep up with.", func() float64 {
+		dropped := atomic.LoadUint64(&m.dropped)
+		if sub, ok := m.sub.Load().(*brahms.Subscription); ok {
+			dropped += sub.Dropped()
+		}
+
+		return float64(dropped)
+	})
+
+	return
+}
+
+// withCore returns a metric function that reads the observed core, it reads
+// zero until a core is observed.
+func (m *agentMetrics) withCore(f func(c *brahms.Core) float64) func() float64 {
+	return func() float64 {
+		c, ok := m.core.Load().(*brahms.Core)
+		if !ok {
+			return 0
+		}
+
+		return f(c)
+	}
+}
+
+// observe the core's state and events for the rest of the metrics, events are
+// counted until the core is deactivated.
+func (m *agentMetrics) observe(c *brahms.Core) {
+	sub := c.Subscribe(1024)
+	if old, ok := m.sub.Load().(*brahms.Subscription); ok {
+		atomic.AddUint64(&m.dropped, old.Dropped())
+	}
+
+	m.core.Store(c)
+	m.sub.Store(sub)
+	go func() {
+		for ev := range sub.C {
+			switch ev.Type {
+			case brahms.NodeInvalidated:
+				m.invalidations.Inc()
+			case brahms.PushFloodRejected:
+				m.frozenRounds.Inc()
+			case brahms.PullRejected:
+				m.pullsRejected.Inc()
+				m.pullRejections.Add(uint64(ev.Rejected))
+			}
+		}
+	}()
+}
+
+// metricsTransport decorates a transport to measure what is send over it
+type metricsTransport struct {
+	brahms.Transport
+	m *agentMetrics
+}
+
+// Push counts the pushes sent
+func (t *metricsTransport) Push(ctx context.Context, self brahms.Node, st brahms.Stamp, to brahms.Node) error {
+	t.m.pushesSent.Inc()
+	return t.Transport.Push(ctx, self, st, to)
+}
+
+// Pull counts the pulls that did or did not return a view
+func (t *metricsTransport) Pull(ctx context.Context, from brahms.Node) (v brahms.View, err error) {
+	v, err = t.Transport.Pull(ctx, from)
+	if err != nil && !errors.As(err, new(brahms.PullRejectedErr)) {
+		t.m.pullsFailed.Inc()
+		return nil, err
+	}
+
+	t.m.pullsSucceeded.Inc()
+	return
+}
+
+// Probe measures the latency of successful probes and counts failed ones
+func (t *metricsTransport) Probe(ctx context.Context, n brahms.Node) (err error) {
+	start := time.Now()
+	err = t.Transport.Probe(ctx, n)
+	if err != nil {
+		t.m.probesFailed.Inc()
+		return err
+	}
+
+	t.m.probeLatency.Observe(time.Since(start).Seconds())
+	return
+}
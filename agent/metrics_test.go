@@ -0,0 +1,34 @@
+package agent
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/advanderveer/brahms"
+	"github.com/advanderveer/brahms/transport"
+)
+
+func TestMetricsObserveTwice(t *testing.T) {
+	m := newAgentMetrics()
+	p, _ := brahms.NewParams(0.45, 0.45, 0.1, 10, 10, 2, 0)
+	tr := transport.NewMemNetTransport()
+	self := brahms.N("127.0.0.1", 1)
+
+	// observing the core of a next join should not register the metrics again
+	c1 := brahms.NewCore(rand.New(rand.NewSource(1)), self, brahms.NewView(brahms.N("127.0.0.1", 2)), p, nil, tr, time.Second)
+	m.observe(c1)
+	c2 := brahms.NewCore(rand.New(rand.NewSource(1)), self, brahms.NewView(brahms.N("127.0.0.1", 2), brahms.N("127.0.0.1", 3)), p, nil, tr, time.Second)
+	m.observe(c2)
+
+	buf := bytes.NewBuffer(nil)
+	if err := m.reg.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), "brahms_view_size 2\n") {
+		t.Fatalf("should read the last observed core, got: %s", buf.String())
+	}
+}
@@ -0,0 +1,542 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/advanderveer/brahms"
+	httpt "github.com/advanderveer/brahms/transport/http"
+)
+
+// ErrStateTooLarge is returned when a state entry is too large or a node
+// would own too many keys
+var ErrStateTooLarge = errors.New("state entry too large or too many keys")
+
+const (
+	// MaxStateKeys is the nr of keys a single node can own, including the
+	// keys it deleted.
+	MaxStateKeys = 64
+
+	// MaxStateEntrySize is the nr of bytes the key and value of a single
+	// state entry can add up to.
+	MaxStateEntrySize = 1024
+
+	// MaxStateNodes is the nr of nodes state is kept for, including our own.
+	MaxStateNodes = 1024
+
+	// maxStateUpdates is the nr of entries that are send in a single exchange,
+	// such that it fits the max body size of the http handler.
+	maxStateUpdates = 256
+)
+
+// Entry is the value of a single key of a node's state. It is versioned by
+// the Lamport clock of the node that owns it, and signed by it such that peers
+// can relay it but not forge it.
+type Entry struct {
+	Value   []byte `json:"value,omitempty"`
+	Version uint64 `json:"version"`
+	Deleted bool   `json:"deleted,omitempty"`
+	Owner   *Owner `json:"owner,omitempty"`
+	Sig     []byte `json:"sig,omitempty"`
+}
+
+// Owner identifies the node that owns an entry, peers check that it derives
+// the id the entry is kept under and verify the entry's signature with its key
+type Owner struct {
+	IP   net.IP            `json:"ip"`
+	Port uint16            `json:"port"`
+	Key  ed25519.PublicKey `json:"key"`
+}
+
+// id returns the id of the owner as a node
+func (o *Owner) id() brahms.NID {
+	n := brahms.Node{IP: o.IP, Port: o.Port, Key: o.Key}
+	return n.Hash()
+}
+
+// signed returns what the owner signs of the entry for the key of node id
+func (e Entry) signed(id brahms.NID, k string) []byte {
+	buf := bytes.NewBuffer(append([]byte{}, id[:]...))
+	buf.WriteString(k)
+	buf.WriteByte(0)
+	binary.Write(buf, binary.BigEndian, e.Version)
+	if e.Deleted {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+		buf.Write(e.Value)
+	}
+
+	return buf.Bytes()
+}
+
+// verify returns whether the entry for the key of node id is signed by the node
+func (e Entry) verify(id brahms.NID, k string) bool {
+	if e.Owner == nil || len(e.Owner.Key) != ed25519.PublicKeySize || e.Owner.id() != id {
+		return false
+	}
+
+	return ed25519.Verify(e.Owner.Key, e.signed(id, k), e.Sig)
+}
+
+// NodeState holds the entries of a single node by their key
+type NodeState map[string]Entry
+
+// ClusterState holds the state of each node by its id
+type ClusterState map[brahms.NID]NodeState
+
+// Digest summarizes the state of each node with a hash of its keys and their
+// versions, peers exchange it to find out what state the other is missing.
+type Digest map[brahms.NID]uint64
+
+// State is replicated key/value state of every node in the network. Each node
+// only sets its own keys, peers keep the newest version of every key they
+// heard of. Entries are signed by the node that owns them, such that peers
+// can't forge the state of others. The state of at most MaxStateNodes is kept,
+// when a new node doesn't fit the node that was least recently updated or
+// touched is forgotten. Such that departed nodes are eventually forgotten while
+// state for made-up nodes can't grow unbounded.
+type State struct {
+	self   brahms.NID
+	owner  *Owner
+	key    ed25519.PrivateKey
+	clock  uint64
+	tick   uint64
+	nodes  ClusterState
+	used   map[brahms.NID]uint64
+	recent map[brahms.NID]map[string]int
+	mu     sync.Mutex
+}
+
+// NewState initializes the state for the node, our entries are signed with
+// the private key of the node.
+func NewState(self brahms.Node, key ed25519.PrivateKey) *State {
+	return &State{
+		self:   self.Hash(),
+		owner:  &Owner{IP: self.IP, Port: self.Port, Key: key.Public().(ed25519.PublicKey)},
+		key:    key,
+		nodes:  ClusterState{},
+		used:   map[brahms.NID]uint64{},
+		recent: map[brahms.NID]map[string]int{},
+	}
+}
+
+// Touch marks the state of the nodes as used, e.g. because they are in our
+// view or sample, such that it is the last to be forgotten.
+func (s *State) Touch(ids ...brahms.NID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tick++
+	for _, id := range ids {
+		if _, ok := s.nodes[id]; ok {
+			s.used[id] = s.tick
+		}
+	}
+}
+
+// evict forgets the state of the node that was least recently used if there
+// is no room for the state of another node, the caller must hold the lock.
+func (s *State) evict() {
+	if len(s.nodes) < MaxStateNodes {
+		return
+	}
+
+	var lru brahms.NID
+	var min uint64 = math.MaxUint64
+	for id := range s.nodes {
+		if id != s.self && s.used[id] < min {
+			lru, min = id, s.used[id]
+		}
+	}
+
+	delete(s.nodes, lru)
+	delete(s.used, lru)
+	delete(s.recent, lru)
+}
+
+// Set the value of one of our own keys. It returns ErrStateTooLarge if the
+// entry exceeds MaxStateEntrySize or we would own more then MaxStateKeys.
+func (s *State) Set(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(key)+len(value) > MaxStateEntrySize {
+		return ErrStateTooLarge
+	}
+
+	if _, ok := s.nodes[s.self][key]; !ok && len(s.nodes[s.self]) >= MaxStateKeys {
+		return ErrStateTooLarge
+	}
+
+	s.clock++
+	s.put(s.self, key, s.sign(key, Entry{Value: append([]byte{}, value...), Version: s.clock}))
+	return nil
+}
+
+// Delete one of our own keys, it is kept as a tombstone such that the
+// deletion spreads like any other update.
+func (s *State) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.nodes[s.self][key]; !ok || e.Deleted {
+		return
+	}
+
+	s.clock++
+	s.put(s.self, key, s.sign(key, Entry{Version: s.clock, Deleted: true}))
+}
+
+// sign one of our own entries, the caller must hold the lock
+func (s *State) sign(k string, e Entry) Entry {
+	e.Owner, e.Sig = s.owner, ed25519.Sign(s.key, e.signed(s.self, k))
+	return e
+}
+
+// put an entry and mark it as recently updated, the caller must hold the lock
+func (s *State) put(id brahms.NID, key string, e Entry) {
+	if s.nodes[id] == nil {
+		s.evict()
+		s.nodes[id] = NodeState{}
+	}
+
+	s.used[id] = s.tick
+
+	if s.recent[id] == nil {
+		s.recent[id] = map[string]int{}
+	}
+
+	s.nodes[id][key] = e
+	s.recent[id][key] = 0
+}
+
+// Read returns a copy of the state of every node, without deleted keys
+func (s *State) Read() (cs ClusterState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cs = ClusterState{}
+	for id, ns := range s.nodes {
+		for k, e := range ns {
+			if e.Deleted {
+				continue
+			}
+
+			if cs[id] == nil {
+				cs[id] = NodeState{}
+			}
+
+			cs[id][k] = Entry{Value: append([]byte{}, e.Value...), Version: e.Version}
+		}
+	}
+
+	return
+}
+
+// Merge the state of other nodes, for every key the entry with the highest
+// version is kept. Entries that are not signed by the node that owns them are
+// rejected, as are versions that would overflow our clock. Entries for our own
+// keys move our clock past them. Keys we don't have are restored, e.g. after a
+// restart, and keys we have are set again with a newer version, such that our
+// value replaces the one peers kept. It returns the nr of entries that were
+// applied and rejected for exceeding the bounds or being forged.
+func (s *State) Merge(cs ClusterState) (applied, rejected int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tick++
+	for id, ns := range cs {
+		for k, e := range ns {
+			if len(k)+len(e.Value) > MaxStateEntrySize {
+				rejected++
+				continue
+			}
+
+			if e.Version == math.MaxUint64 || !e.verify(id, k) {
+				rejected++
+				continue //forged, or the next version would overflow
+			}
+
+			o, ok := s.nodes[id][k]
+			if id == s.self {
+				if e.Version > s.clock {
+					s.clock = e.Version //lamport clock, move past what we've seen
+				}
+
+				if ok {
+					if o.Version < e.Version {
+						s.clock++
+						o.Version = s.clock
+						s.put(id, k, s.sign(k, o)) //only we set our own keys
+					}
+
+					continue
+				}
+			}
+
+			if ok && o.Version >= e.Version {
+				continue //we already know this or a newer version
+			}
+
+			if !ok && len(s.nodes[id]) >= MaxStateKeys {
+				rejected++
+				continue
+			}
+
+			if e.Deleted {
+				e.Value = nil
+			}
+
+			s.put(id, k, e)
+			applied++
+		}
+	}
+
+	return
+}
+
+// Recent returns entries that were updated recently, each entry is returned
+// by up to n calls before it is no longer considered recent.
+func (s *State) Recent(n int) (cs ClusterState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var m int
+	cs = ClusterState{}
+	for id, keys := range s.recent {
+		for k, sent := range keys {
+			if m >= maxStateUpdates {
+				return //the rest will be send on a next call
+			}
+
+			if sent+1 >= n {
+				delete(keys, k)
+			} else {
+				keys[k] = sent + 1
+			}
+
+			if cs[id] == nil {
+				cs[id] = NodeState{}
+			}
+
+			cs[id][k] = s.nodes[id][k]
+			m++
+		}
+
+		if len(keys) < 1 {
+			delete(s.recent, id)
+		}
+	}
+
+	return
+}
+
+// Digest summarizes the state we have of every node
+func (s *State) Digest() (d Digest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d = Digest{}
+	for id, ns := range s.nodes {
+		d[id] = ns.hash()
+	}
+
+	return
+}
+
+// Delta returns the full state of every node that the digest summarizes
+// differently, or not at all. Such that a peer with the digest can repair the
+// state it is missing.
+func (s *State) Delta(d Digest) (cs ClusterState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var m int
+	cs = ClusterState{}
+	for id, ns := range s.nodes {
+		if h, ok := d[id]; ok && h == ns.hash() {
+			continue //peer has the same state
+		}
+
+		if m+len(ns) > maxStateUpdates {
+			continue //the rest will be repaired on a next exchange
+		}
+
+		cs[id] = NodeState{}
+		for k, e := range ns {
+			cs[id][k] = e
+		}
+
+		m += len(ns)
+	}
+
+	return
+}
+
+// hash the keys of the node state with their versions
+func (ns NodeState) hash() uint64 {
+	keys := make([]string, 0, len(ns))
+	for k := range ns {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	h := fnv.New64a()
+	var ver [8]byte
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		binary.BigEndian.PutUint64(ver[:], ns[k].Version)
+		h.Write(ver[:])
+	}
+
+	return h.Sum64()
+}
+
+// stateMsg is exchanged with peers to spread state. If it is an anti-entropy
+// sync the peer responds with the state we're missing according to our digest
+// and its own digest, otherwise it responds with its recent updates.
+type stateMsg struct {
+	Updates ClusterState `json:"updates,omitempty"`
+	Sync    bool         `json:"sync,omitempty"`
+	Digest  Digest       `json:"digest,omitempty"`
+}
+
+// SetState sets the value of one of the agent's own keys, it spreads to the
+// other agents with the next rounds of the protocol.
+func (a *Agent) SetState(key string, value []byte) (err error) {
+	if err = a.state.Set(key, value); err != nil {
+		return Err{err, "state"}
+	}
+
+	return nil
+}
+
+// DeleteState deletes one of the agent's own keys
+func (a *Agent) DeleteState(key string) {
+	a.state.Delete(key)
+}
+
+// ReadState returns the state of every agent in the network this agent heard
+// of, including its own.
+func (a *Agent) ReadState() ClusterState {
+	return a.state.Read()
+}
+
+// transmits returns the nr of times a recent update is send to peers, it
+// grows with the estimated size of the network
+func (a *Agent) transmits() int {
+	return int(math.Ceil(math.Log2(a.core.EstimateSize()+1))) + 1
+}
+
+// gossipState exchanges recent updates with a few random samples and syncs
+// with one of them if it is time for anti-entropy.
+func (a *Agent) gossipState(ctx context.Context) {
+	if a.stateSync.fanout < 1 {
+		return
+	}
+
+	// the state of nodes we know to be around is the last to be forgotten
+	members := a.core.ReadView().Concat(a.core.Sample())
+	ids := make([]brahms.NID, 0, len(members))
+	for id := range members {
+		ids = append(ids, id)
+	}
+
+	a.state.Touch(ids...)
+	peers := a.core.Sample().Pick(a.rnd, a.stateSync.fanout)
+	if len(peers) < 1 {
+		return
+	}
+
+	updates := a.state.Recent(a.transmits())
+	var wg sync.WaitGroup
+	for _, p := range peers {
+		wg.Add(1)
+		go func(p brahms.Node) {
+			defer wg.Done()
+			a.exchangeState(ctx, p, &stateMsg{Updates: updates})
+		}(p)
+	}
+
+	wg.Wait()
+	if a.stateSync.interval < 1 || time.Since(a.stateSync.last) < a.stateSync.interval {
+		return
+	}
+
+	a.stateSync.last = time.Now()
+	for _, p := range peers.Pick(a.rnd, 1) {
+		resp, ok := a.exchangeState(ctx, p, &stateMsg{Sync: true, Digest: a.state.Digest()})
+		if !ok {
+			continue
+		}
+
+		// the peer may have older state then us as well, repair it
+		if d := a.state.Delta(resp.Digest); len(d) > 0 {
+			a.exchangeState(ctx, p, &stateMsg{Updates: d})
+		}
+	}
+}
+
+// exchangeState sends the message to a peer and merges the updates it responds with
+func (a *Agent) exchangeState(ctx context.Context, p brahms.Node, msg *stateMsg) (resp *stateMsg, ok bool) {
+	data, _ := json.Marshal(msg)
+	resp = new(stateMsg)
+	if err := a.http.Request(ctx, http.MethodPost, p, "/state", bytes.NewReader(data), resp); err != nil {
+		a.metrics.stateExchangesFailed.Inc()
+		a.logs.Printf("failed to exchange state with %s: %v", p.String(), err)
+		return nil, false
+	}
+
+	a.metrics.stateExchanges.Inc()
+	a.mergeState(resp.Updates)
+	return resp, true
+}
+
+// mergeState merges updates from a peer and counts them
+func (a *Agent) mergeState(cs ClusterState) {
+	applied, rejected := a.state.Merge(cs)
+	a.metrics.stateApplied.Add(uint64(applied))
+	a.metrics.stateRejected.Add(uint64(rejected))
+}
+
+// serveState handles state exchanges of peers
+func (a *Agent) serveState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	defer r.Body.Close()
+	msg := new(stateMsg)
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, httpt.DefaultMaxBodySize)).Decode(msg)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	a.mergeState(msg.Updates)
+	resp := &stateMsg{}
+	if msg.Sync {
+		resp.Updates, resp.Digest = a.state.Delta(msg.Digest), a.state.Digest()
+	} else {
+		resp.Updates = a.state.Recent(a.transmits())
+	}
+
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w,
+			http.StatusText(http.StatusInternalServerError),
+			http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,214 @@
+package agent_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/advanderveer/brahms"
+	"github.com/advanderveer/brahms/agent"
+	"github.com/advanderveer/go-test"
+)
+
+// newState returns the state of a node with a key derived from i
+func newState(i int) (*agent.State, brahms.NID) {
+	seed := make([]byte, ed25519.SeedSize)
+	seed[0], seed[1] = byte(i), byte(i>>8)
+	key := ed25519.NewKeyFromSeed(seed)
+	n := brahms.NK("127.0.0.1", uint16(i), key.Public().(ed25519.PublicKey))
+	return agent.NewState(*n, key), n.Hash()
+}
+
+func TestStateMerge(t *testing.T) {
+	s1, id1 := newState(1)
+	s2, id2 := newState(2)
+
+	test.Ok(t, s1.Set("load", []byte("0.5")))
+	test.Ok(t, s1.Set("schema", []byte("3")))
+	test.Equals(t, agent.NodeState{
+		"load":   agent.Entry{Value: []byte("0.5"), Version: 1},
+		"schema": agent.Entry{Value: []byte("3"), Version: 2},
+	}, s1.Read()[id1])
+
+	// recent updates are returned up to n times
+	test.Equals(t, 2, len(s1.Recent(2)[id1]))
+	test.Equals(t, 2, len(s1.Recent(2)[id1]))
+	test.Equals(t, 0, len(s1.Recent(2)))
+
+	applied, rejected := s2.Merge(s1.Delta(nil))
+	test.Equals(t, 2, applied)
+	test.Equals(t, 0, rejected)
+	test.Equals(t, s1.Read(), s2.Read())
+
+	// merged updates are recent for the peer as well, older versions are not merged
+	test.Equals(t, 2, len(s2.Recent(1)[id1]))
+	old := s1.Delta(nil)
+	test.Ok(t, s1.Set("load", []byte("0.1")))
+	s2.Merge(s1.Delta(nil))
+	applied, _ = s2.Merge(old)
+	test.Equals(t, 0, applied)
+	test.Equals(t, []byte("0.1"), s2.Read()[id1]["load"].Value)
+
+	// deletions spread as tombstones
+	s1.Delete("load")
+	s2.Merge(s1.Recent(1))
+	_, ok := s2.Read()[id1]["load"]
+	test.Equals(t, false, ok)
+	test.Equals(t, []byte("3"), s2.Read()[id1]["schema"].Value)
+	test.Equals(t, 0, len(s2.Read()[id2]))
+}
+
+func TestStateForged(t *testing.T) {
+	s1, id1 := newState(1)
+	s2, id2 := newState(2)
+	test.Ok(t, s1.Set("load", []byte("0.5")))
+
+	// entries that are not signed by their owner are rejected, such that no
+	// peer can move our clock to its end and outrun our own writes
+	applied, rejected := s2.Merge(agent.ClusterState{id2: {"load": agent.Entry{Value: []byte("9"), Version: math.MaxUint64}}})
+	test.Equals(t, 0, applied)
+	test.Equals(t, 1, rejected)
+	test.Ok(t, s2.Set("load", []byte("0.9")))
+	test.Equals(t, agent.Entry{Value: []byte("0.9"), Version: 1}, s2.Read()[id2]["load"])
+
+	// neither can peers change the entries of others, or sign them as another node
+	forged := s1.Delta(nil)
+	e := forged[id1]["load"]
+	e.Value = []byte("9")
+	forged[id1]["load"] = e
+	_, rejected = s2.Merge(forged)
+	test.Equals(t, 1, rejected)
+
+	_, rejected = s2.Merge(agent.ClusterState{id2: s1.Delta(nil)[id1]})
+	test.Equals(t, 1, rejected)
+	test.Equals(t, 0, len(s2.Read()[id1]))
+	test.Equals(t, []byte("0.9"), s2.Read()[id2]["load"].Value)
+}
+
+func TestStateBounds(t *testing.T) {
+	s1, id1 := newState(1)
+	s2, _ := newState(2)
+	test.Equals(t, agent.ErrStateTooLarge, s1.Set("k", []byte(strings.Repeat("v", agent.MaxStateEntrySize))))
+
+	for i := 0; i < agent.MaxStateKeys; i++ {
+		test.Ok(t, s1.Set(strconv.Itoa(i), nil))
+	}
+
+	test.Equals(t, agent.ErrStateTooLarge, s1.Set("one-too-many", nil))
+	test.Ok(t, s1.Set("0", []byte("existing keys can still be set")))
+
+	// a peer that doesn't check the bounds can't make us exceed them
+	ns := s1.Delta(nil)[id1]
+	ns["too-large"] = agent.Entry{Value: []byte(strings.Repeat("v", agent.MaxStateEntrySize)), Version: 1}
+	applied, rejected := s2.Merge(agent.ClusterState{id1: ns})
+	test.Equals(t, agent.MaxStateKeys, applied)
+	test.Equals(t, 1, rejected)
+}
+
+func TestStateRestart(t *testing.T) {
+	s1, id1 := newState(1)
+	s2, _ := newState(2)
+	for i := 0; i < 10; i++ {
+		test.Ok(t, s1.Set("load", []byte(strconv.Itoa(i))))
+	}
+
+	test.Ok(t, s1.Set("schema", []byte("3")))
+	s2.Merge(s1.Delta(nil))
+
+	// after a restart our clock starts over, a write before it caught up is
+	// set again with a newer version when peers know an older one
+	s1, _ = newState(1)
+	test.Ok(t, s1.Set("load", []byte("0.5")))
+	applied, _ := s1.Merge(s2.Delta(nil))
+	test.Equals(t, 1, applied)
+	test.Equals(t, []byte("3"), s1.Read()[id1]["schema"].Value)
+	test.Equals(t, []byte("0.5"), s1.Read()[id1]["load"].Value)
+	test.Assert(t, s1.Read()[id1]["load"].Version > s2.Read()[id1]["load"].Version, "our write should replace the older one")
+
+	s2.Merge(s1.Recent(1))
+	test.Equals(t, []byte("0.5"), s2.Read()[id1]["load"].Value)
+}
+
+func TestStateNodeBound(t *testing.T) {
+	s, self := newState(1)
+	m, member := newState(2)
+	test.Ok(t, s.Set("k", nil))
+	test.Ok(t, m.Set("k", nil))
+	s.Merge(m.Delta(nil))
+
+	// many nodes don't grow the state past the bound, the state of nodes that
+	// are touched and our own is kept
+	for i := 0; i < agent.MaxStateNodes*2; i++ {
+		o, _ := newState(3 + i)
+		test.Ok(t, o.Set("k", nil))
+		s.Touch(member)
+		s.Merge(o.Delta(nil))
+	}
+
+	st := s.Read()
+	test.Equals(t, agent.MaxStateNodes, len(st))
+	test.Assert(t, st[self] != nil, "our own state should be kept")
+	test.Assert(t, st[member] != nil, "state of a touched node should be kept")
+}
+
+func TestStateAntiEntropy(t *testing.T) {
+	s1, id1 := newState(1)
+	s2, _ := newState(2)
+	s3, id3 := newState(3)
+
+	test.Ok(t, s1.Set("a", []byte("1")))
+	test.Ok(t, s1.Set("b", []byte("2")))
+	s2.Merge(s1.Delta(nil))
+	test.Ok(t, s3.Set("c", []byte("3")))
+
+	// s3 missed the update of "a" but knows of a newer "b"
+	test.Ok(t, s1.Set("b", []byte("4")))
+	s3.Merge(agent.ClusterState{id1: {"b": s1.Delta(nil)[id1]["b"]}})
+	test.Equals(t, agent.ClusterState{}, s2.Delta(s2.Digest()))
+
+	s3.Merge(s2.Delta(s3.Digest()))
+	s2.Merge(s3.Delta(s2.Digest()))
+	test.Equals(t, s2.Read(), s3.Read())
+	test.Equals(t, []byte("1"), s3.Read()[id1]["a"].Value)
+	test.Equals(t, []byte("4"), s2.Read()[id1]["b"].Value)
+	test.Equals(t, []byte("3"), s2.Read()[id3]["c"].Value)
+}
+
+func TestAgentState(t *testing.T) {
+	n := 5
+	agents := make([]*agent.Agent, 0, n)
+	for i := 0; i < n; i++ {
+		a, err := agent.New(os.Stderr, agent.LocalTestConfig())
+		test.Ok(t, err)
+		defer a.Shutdown(context.Background())
+		agents = append(agents, a)
+	}
+
+	test.Ok(t, agents[0].SetState("schema", []byte("3")))
+	for _, a := range agents {
+		first := agents[0].Self()
+		a.Join(brahms.NewView(&first))
+	}
+
+	test.Ok(t, agents[n-1].SetState("load", []byte("0.5")))
+	test.Equals(t, agent.ErrStateTooLarge, agents[0].SetState("k", make([]byte, agent.MaxStateEntrySize)).(agent.Err).E)
+
+	s0, s1 := agents[0].Self(), agents[n-1].Self()
+	deadline := time.Now().Add(time.Second * 10)
+	for _, a := range agents {
+		for {
+			st := a.ReadState()
+			if string(st[s0.Hash()]["schema"].Value) == "3" && string(st[s1.Hash()]["load"].Value) == "0.5" {
+				break
+			}
+
+			test.Assert(t, time.Now().Before(deadline), "state should spread to every agent")
+			time.Sleep(time.Millisecond * 50)
+		}
+	}
+}
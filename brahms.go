@@ -2,43 +2,107 @@ package brahms
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"math/rand"
+	"sync"
 	"time"
 )
 
-// Transport describes how a node communicates with its peers
+var (
+	// ErrTimeout is returned by transports when the peer didn't answer in time
+	ErrTimeout = errors.New("peer didn't answer in time")
+
+	// ErrRefused is returned by transports when the peer couldn't be reached
+	// or is not (or no longer) participating.
+	ErrRefused = errors.New("peer refused the request")
+
+	// ErrBadStatus is returned by transports when the peer answered with an
+	// unexpected status
+	ErrBadStatus = errors.New("peer answered with an unexpected status")
+
+	// ErrDecode is returned by transports when the peer's answer couldn't be
+	// decoded
+	ErrDecode = errors.New("failed to decode the peer's answer")
+)
+
+// PullRejectedErr is returned by a transport's pull together with the view of
+// the peer, when it rejected some of the nodes in the peer's response. The rest
+// of the view is still considered.
+type PullRejectedErr struct {
+	Rejected int
+}
+
+func (e PullRejectedErr) Error() string {
+	return fmt.Sprintf("rejected %d nodes of the pull response", e.Rejected)
+}
+
+// Transport describes how a node communicates with its peers. Failures are
+// returned as errors that can be matched with errors.Is against ErrTimeout,
+// ErrRefused, ErrBadStatus and ErrDecode.
 type Transport interface {
-	Emit(ctx context.Context, c chan<- NID, id NID, msg []byte, to Node)
-	Push(ctx context.Context, self Node, to Node)
-	Pull(ctx context.Context, c chan<- View, from Node)
+	Emit(ctx context.Context, msg []byte, to Node) error
+	Push(ctx context.Context, self Node, st Stamp, to Node) error
+	Pull(ctx context.Context, from Node) (View, error)
 	Prober
+	IndirectProber
 }
 
 // Brahms implements the gossip protocol and takes an old view 'v' and returns a
 // new view.
-func Brahms(self *Node, rnd *rand.Rand, p P, to time.Duration, s *Sampler, tr Transport, pushes <-chan Node, v View) View {
+func Brahms(self *Node, rnd *rand.Rand, p P, vp ViewPolicy, to time.Duration, s *Sampler, tr Transport, pushes <-chan Node, v View) View {
 
 	// reset push/pull views (line 21)
 	push, pull := View{}, View{}
 
 	// perform sends and write results to these channels
-	pulls := make(chan View, p.L1β())
+	type pulled struct {
+		from     Node
+		v        View
+		rejected int
+	}
+
+	pulls := make(chan pulled, p.L1β())
 	func() {
 		ctx, cancel := context.WithTimeout(context.Background(), to)
 		defer cancel()
 
-		// push our own id to peers picked from the current view (line 22)
-		for _, n := range v.Pick(rnd, p.L1α()) {
-			go tr.Push(ctx, *self, n)
+		// push our own id to peers picked from the current view (line 22). Each
+		// push carries a proof of work that is bound to the receiving peer
+		var wg sync.WaitGroup
+		epoch := Epoch(s.clock())
+		for id, n := range v.Pick(rnd, p.L1α()) {
+			wg.Add(1)
+			go func(id NID, n Node, nonce uint64) {
+				tr.Push(ctx, *self, MintStamp(self.Hash(), id, epoch, nonce, p.D()), n) //failed pushes are not retried
+				wg.Done()
+			}(id, n, rnd.Uint64())
 		}
 
 		// send pull requests to peers picked from the current view (line 25)
 		for _, n := range v.Pick(rnd, p.L1β()) {
-			go tr.Pull(ctx, pulls, n)
+			wg.Add(1)
+			go func(n Node) {
+				defer wg.Done()
+				var rerr PullRejectedErr
+				pv, err := tr.Pull(ctx, n)
+				if err != nil && !errors.As(err, &rerr) {
+					return //failed pulls are not considered
+				}
+
+				pulls <- pulled{n, pv, rerr.Rejected}
+			}(n)
 		}
 
 		// wait for time unit to be done, cancels any open pushes/pulls (line 27)
-		<-ctx.Done()
+		// NOTE: we divert from the paper here: the round ends early if all
+		// pushes and pulls returned, pacing is up to the caller.
+		done := make(chan struct{})
+		go func() { wg.Wait(); close(done) }()
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
 	}()
 
 	// drain and consider all nodes pushed to us this time period (line 28)
@@ -51,6 +115,10 @@ PUSH_DRAIN:
 				continue //ignore ourselves if someone adds ourself to a push
 			}
 
+			if o, ok := push[id]; ok && o.Newer(n) {
+				continue //a node pushed newer info earlier
+			}
+
 			push[id] = n
 		default:
 			break PUSH_DRAIN
@@ -58,13 +126,30 @@ PUSH_DRAIN:
 	}
 
 	// drain and consider all nodes we pulled in this time period (line 32)
+	sample := s.Sample()
 PULL_DRAIN:
 	for {
 		select {
 		case pv := <-pulls:
-			for id, n := range pv {
+
+			// NOTE: we divert from the paper here: a peer can only bias our
+			// pulls by the nodes it returns, a response larger then an honest
+			// peer's view is rejected as a whole.
+			if len(pv.v)+pv.rejected > MaxPullSize(p) {
+				s.events.publish(Event{Type: PullRejected, Time: s.clock(), ID: pv.from.Hash(), Node: pv.from, Rejected: len(pv.v) + pv.rejected})
+				continue
+			}
+
+			rejected := pv.rejected //by the transport
+			for id, n := range pv.v {
 				if id == self.Hash() {
-					continue //ignore ourselves if we appear in a pull
+					rejected++
+					continue //we don't sample ourselves
+				}
+
+				if !n.IsValid() {
+					rejected++
+					continue //malformed, we could never reach it
 				}
 
 				// NOTE: We divert here from the paper by keeping track of recently
@@ -75,23 +160,50 @@ PULL_DRAIN:
 					continue //recently invalidated, don't consider interesting
 				}
 
+				// NOTE: the incarnation and metadata of a node are only taken from
+				// its own push, peers could otherwise forge a higher incarnation to
+				// clear our suspicion of the node or to outrun its refutations, or
+				// a higher version to replace its metadata. What we already know of
+				// the node is kept.
+				known, ok := v[id]
+				if !ok {
+					known, ok = sample[id]
+				}
+
+				n.Inc, n.Meta, n.Ver = 0, nil, 0
+				if ok {
+					n.Inc, n.Meta, n.Ver = known.Inc, known.Meta, known.Ver
+				}
+
 				pull[id] = n
 			}
+
+			if rejected > 0 {
+				s.events.publish(Event{Type: PullRejected, Time: s.clock(), ID: pv.from.Hash(), Node: pv.from, Rejected: rejected})
+			}
 		default:
 			break PULL_DRAIN
 		}
 	}
 
 	// only update our view if the nr of pushed ids was not too high (line 35)
-	// NOTE: we divert from the paper here: we (re)set the view always event if
-	// pushes and pulls are empty. Else non-responding peers in the view would
-	// never reset in small networks
-	if len(push) <= p.L1α() {
+	// NOTE: we divert from the paper here: the policy decides if the view is
+	// refreshed when pushes or pulls are empty. By always refreshing, non-
+	// responding peers in the view are reset in small networks
+	if len(push) > p.L1α() {
+		s.events.publish(Event{Type: PushFloodRejected, Time: s.clock(), Pushes: len(push)})
+	} else if vp.Refresh(p, push, pull) {
 
 		// construct our new view from what we've seen this round (line 36)
-		v = push.Pick(rnd, p.L1α()).
-			Concat(pull.Pick(rnd, p.L1β())).
-			Concat(s.Sample().Pick(rnd, p.L1γ()))
+		// NOTE: we divert from the paper here: the nr of nodes from a single
+		// subnet can be capped, such that a party with many cheap addresses
+		// can't fill our view.
+		max := s.SubnetCap()
+		pushed := push.PickDiverse(rnd, p.L1α(), max)
+		pulled := pull.PickDiverse(rnd, p.L1β(), max, pushed)
+		v = pushed.
+			Concat(pulled).
+			Concat(s.Sample().PickDiverse(rnd, p.L1γ(), max, pushed, pulled))
 	}
 
 	// update the sampler with resuling push/pull (line 37)
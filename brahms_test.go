@@ -13,9 +13,9 @@ import (
 
 func TestBrahmsNoReply(t *testing.T) {
 	n1 := brahms.N("127.0.0.1", 1)
-	pr := proberFunc(func(ctx context.Context, c chan<- brahms.NID, i brahms.NID, n brahms.Node) {})
+	pr := proberFunc(func(ctx context.Context, n brahms.Node) error { return brahms.ErrTimeout })
 
-	p, _ := brahms.NewParams(0.1, 0.7, 0.2, 10, 2, 2)
+	p, _ := brahms.NewParams(0.1, 0.7, 0.2, 10, 2, 2, 0)
 	r := rand.New(rand.NewSource(0))
 	s := brahms.NewSampler(r, p.L2(), pr, time.Second)
 	self := n1
@@ -24,7 +24,7 @@ func TestBrahmsNoReply(t *testing.T) {
 	v0 := brahms.NewView(n1)
 	tr0 := transport.NewMockTransport()
 
-	v1 := brahms.Brahms(self, r, p, time.Millisecond*10, s, tr0, p0, v0)
+	v1 := brahms.Brahms(self, r, p, brahms.AlwaysRefresh, time.Millisecond*10, s, tr0, p0, v0)
 
 	//view should be reset if transport returned nothing and sampler is empty
 	test.Equals(t, brahms.NewView(), v1)
@@ -43,9 +43,9 @@ func TestBrahmsWithJustPushes(t *testing.T) {
 	n4 := brahms.N("127.0.0.1", 4)
 	n5 := brahms.N("127.0.0.1", 5)
 
-	p, _ := brahms.NewParams(0.1, 0.7, 0.2, 10, 2, 2)
+	p, _ := brahms.NewParams(0.1, 0.7, 0.2, 10, 2, 2, 0)
 	r := rand.New(rand.NewSource(1))
-	pr := proberFunc(func(ctx context.Context, c chan<- brahms.NID, id brahms.NID, n brahms.Node) {})
+	pr := proberFunc(func(ctx context.Context, n brahms.Node) error { return brahms.ErrTimeout })
 	s := brahms.NewSampler(r, p.L2(), pr, time.Second)
 	self := n1
 
@@ -56,7 +56,7 @@ func TestBrahmsWithJustPushes(t *testing.T) {
 	tr0 := transport.NewMockTransport()
 
 	// with just a pull response we do not update the view with just that info
-	v1 := brahms.Brahms(self, r, p, time.Millisecond*10, s, tr0, p0, v0)
+	v1 := brahms.Brahms(self, r, p, brahms.AlwaysRefresh, time.Millisecond*10, s, tr0, p0, v0)
 	test.Equals(t, 0, len(p0))
 	test.Equals(t, brahms.NewView(n2), v1)
 
@@ -68,7 +68,7 @@ func TestBrahmsWithJustPushes(t *testing.T) {
 		p1 <- *n3
 		p1 <- *n4 //with the given params this is too much push
 
-		v2 := brahms.Brahms(n5, r, p, time.Millisecond*10, s, tr0, p1, v0)
+		v2 := brahms.Brahms(n5, r, p, brahms.AlwaysRefresh, time.Millisecond*10, s, tr0, p1, v0)
 
 		//with too many pushes the view shouldn't have changed
 		test.Equals(t, v2, v0)
@@ -82,9 +82,9 @@ func TestBrahmsWithPullsAndPushes(t *testing.T) {
 	n4 := brahms.N("127.0.0.1", 4)
 	n5 := brahms.N("127.0.0.1", 5)
 
-	p, _ := brahms.NewParams(0.1, 0.7, 0.2, 10, 4, 2)
+	p, _ := brahms.NewParams(0.1, 0.7, 0.2, 10, 4, 2, 0)
 	r := rand.New(rand.NewSource(1))
-	pr := proberFunc(func(ctx context.Context, c chan<- brahms.NID, i brahms.NID, n brahms.Node) {})
+	pr := proberFunc(func(ctx context.Context, n brahms.Node) error { return brahms.ErrTimeout })
 	s := brahms.NewSampler(r, p.L2(), pr, time.Second)
 
 	// sample n5, then probe it to recently invalidate it
@@ -101,7 +101,40 @@ func TestBrahmsWithPullsAndPushes(t *testing.T) {
 
 	// with both pushes and pulls the view should get updated.
 	// should ignore self and n5, the latter because it was recently invalidated
-	v1 := brahms.Brahms(self, r, p, time.Millisecond*10, s, tr0, p0, v0)
+	v1 := brahms.Brahms(self, r, p, brahms.AlwaysRefresh, time.Millisecond*10, s, tr0, p0, v0)
 	test.Equals(t, brahms.NewView(n3, n4), v1)
 	test.Equals(t, brahms.NewView(n3, n4), s.Sample())
 }
+
+// slowPulls never answers pulls before the round times out
+type slowPulls struct{ *transport.MockTransport }
+
+func (t slowPulls) Pull(ctx context.Context, from brahms.Node) (brahms.View, error) {
+	<-ctx.Done()
+	return nil, brahms.ErrTimeout
+}
+
+func TestBrahmsEarlyCompletion(t *testing.T) {
+	n1 := brahms.N("127.0.0.1", 1)
+	n2 := brahms.N("127.0.0.1", 2)
+	n3 := brahms.N("127.0.0.1", 3)
+
+	p, _ := brahms.NewParams(0.45, 0.45, 0.1, 10, 10, 2, 0)
+	r := rand.New(rand.NewSource(1))
+	pr := proberFunc(func(ctx context.Context, n brahms.Node) error { return brahms.ErrTimeout })
+	s := brahms.NewSampler(r, p.L2(), pr, time.Second)
+
+	tr0 := transport.NewMockTransport()
+	tr0.SetPull(n2.Hash(), brahms.NewView(n3))
+
+	// all pulls answered, the round ends without waiting for the timeout
+	t0 := time.Now()
+	v1 := brahms.Brahms(n1, r, p, brahms.AlwaysRefresh, time.Second, s, tr0, make(chan brahms.Node), brahms.NewView(n2))
+	test.Assert(t, time.Since(t0) < time.Millisecond*500, "should have ended early, took: %s", time.Since(t0))
+	test.Equals(t, true, v1[n3.Hash()].Port == 3)
+
+	// if a pull doesn't return the round ends at the timeout
+	t0 = time.Now()
+	brahms.Brahms(n1, r, p, brahms.AlwaysRefresh, time.Millisecond*50, s, slowPulls{tr0}, make(chan brahms.Node), brahms.NewView(n2))
+	test.Assert(t, time.Since(t0) >= time.Millisecond*50, "should have waited for the timeout")
+}
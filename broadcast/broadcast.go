@@ -0,0 +1,334 @@
+// Package broadcast disseminates messages to every node of the network by
+// relaying them to random peers of the node's sample, as an epidemic.
+package broadcast
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/advanderveer/brahms"
+)
+
+var (
+	// ErrMalformed is returned when a received message couldn't be decoded
+	ErrMalformed = errors.New("malformed broadcast message")
+
+	// ErrInvalidMID is returned when an encoded message id couldn't be decoded
+	ErrInvalidMID = errors.New("invalid message id")
+)
+
+const (
+	// DefaultFanout is the nr of peers a message is relayed to by each node
+	DefaultFanout = 4
+
+	// DefaultHops is the nr of times a message is relayed at most
+	DefaultHops = 8
+
+	// DefaultTTL is the time after which a message is no longer relayed
+	DefaultTTL = time.Minute
+
+	// DefaultCacheSize is the nr of message ids that are remembered at most
+	DefaultCacheSize = 10000
+
+	// DefaultRelayTimeout is how long relaying to a peer may take
+	DefaultRelayTimeout = time.Second
+)
+
+// MID identifies a broadcast message
+type MID [16]byte
+
+func (id MID) String() string { return hex.EncodeToString(id[:4]) }
+
+// MarshalText encodes the full id as hex
+func (id MID) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(id[:])), nil
+}
+
+// UnmarshalText decodes an id from its full hex encoding
+func (id *MID) UnmarshalText(text []byte) error {
+	if hex.DecodedLen(len(text)) != len(id) {
+		return ErrInvalidMID
+	}
+
+	_, err := hex.Decode(id[:], text)
+	return err
+}
+
+// Msg is a message that is broadcast to every node in the network. It is
+// relayed while it has hops left and its deadline didn't pass.
+type Msg struct {
+	ID       MID       `json:"id"`
+	Hops     int       `json:"hops"`
+	Deadline time.Time `json:"deadline"`
+	Data     []byte    `json:"data"`
+}
+
+// Peers provides the peers messages are relayed to, the agent implements it
+type Peers interface {
+	Sample() brahms.View
+}
+
+// Network provides the peers messages are relayed to and emits messages to
+// them, the agent implements it.
+type Network interface {
+	Peers
+	EmitTo(ctx context.Context, msg []byte, to brahms.Node) error
+}
+
+// Receiver provides the messages that were emitted to us, the agent
+// implements it.
+type Receiver interface {
+	Receive() (msg []byte, err error)
+}
+
+// Stats counts what happened to the messages a broadcaster received and relayed
+type Stats struct {
+	Delivered   uint64 // messages that were delivered for the first time
+	Duplicates  uint64 // messages that were delivered before
+	Expired     uint64 // messages that arrived after their deadline
+	Malformed   uint64 // messages that couldn't be decoded
+	Relayed     uint64 // relays that a peer accepted
+	RelayFailed uint64 // relays that failed or timed out
+}
+
+// Broadcaster broadcasts messages and relays the messages of others. Messages
+// are delivered at most once as long as their id is remembered, ids are
+// remembered for the ttl of messages. Such that they are only forgotten early
+// if more then the cache size of messages arrive within a ttl.
+type Broadcaster struct {
+	rnd     *rand.Rand
+	net     Network
+	fanout  int
+	hops    int
+	ttl     time.Duration
+	to      time.Duration
+	now     func() time.Time
+	seen    *cache
+	deliver []func(m Msg)
+	stats   Stats
+	relays  int
+	idle    *sync.Cond
+	mu      sync.Mutex
+}
+
+// New initializes a broadcaster that relays messages over the network to
+// peers that are randomly picked with rnd
+func New(rnd *rand.Rand, net Network) (b *Broadcaster) {
+	b = &Broadcaster{
+		rnd:    rnd,
+		net:    net,
+		fanout: DefaultFanout,
+		hops:   DefaultHops,
+		ttl:    DefaultTTL,
+		to:     DefaultRelayTimeout,
+		now:    time.Now,
+		seen:   newCache(DefaultTTL, DefaultCacheSize),
+	}
+
+	b.idle = sync.NewCond(&b.mu)
+	return b
+}
+
+// SetFanout configures the nr of peers each message is relayed to
+func (b *Broadcaster) SetFanout(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fanout = n
+}
+
+// SetTTL configures how many times and for how long the messages we broadcast
+// are relayed. Message ids are remembered for the ttl, such that it resets
+// what ids are remembered.
+func (b *Broadcaster) SetTTL(hops int, ttl time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.hops, b.ttl = hops, ttl
+	b.seen = newCache(ttl, len(b.seen.ring))
+}
+
+// SetCacheSize configures the nr of message ids that are remembered at most,
+// it resets what ids are remembered.
+func (b *Broadcaster) SetCacheSize(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seen = newCache(b.ttl, n)
+}
+
+// SetRelayTimeout configures how long relaying a message to a peer may take
+func (b *Broadcaster) SetRelayTimeout(to time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.to = to
+}
+
+// SetClock configures the clock deadlines are checked with, by default this
+// is the wall clock.
+func (b *Broadcaster) SetClock(now func() time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.now = now
+}
+
+// OnDeliver registers a callback that is called with every message the first
+// time it is received. Callbacks are called synchronously while handling the
+// message, they should not block.
+func (b *Broadcaster) OnDeliver(f func(m Msg)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.deliver = append(b.deliver, f)
+}
+
+// Broadcast a message with a new id to the network. It is not delivered to
+// ourselves, relaying happens in the background.
+func (b *Broadcaster) Broadcast(data []byte) (id MID, err error) {
+	_, err = crand.Read(id[:])
+	if err != nil {
+		return id, err
+	}
+
+	b.mu.Lock()
+	now := b.now()
+	m := Msg{ID: id, Hops: b.hops, Deadline: now.Add(b.ttl), Data: data}
+	b.seen.add(id, nil, now)
+	b.mu.Unlock()
+
+	b.relay(m)
+	return id, nil
+}
+
+// Handle a message that was emitted to us. It is delivered and relayed if we
+// didn't see it before and it didn't expire. It returns ErrMalformed if the
+// message couldn't be decoded.
+func (b *Broadcaster) Handle(ctx context.Context, data []byte) error {
+	var m Msg
+	if err := json.Unmarshal(data, &m); err != nil {
+		atomic.AddUint64(&b.stats.Malformed, 1)
+		return ErrMalformed
+	}
+
+	b.mu.Lock()
+	now := b.now()
+	if now.After(m.Deadline) {
+		b.mu.Unlock()
+		atomic.AddUint64(&b.stats.Expired, 1)
+		return nil
+	}
+
+	// the sender can't make a message outlive our own bounds, it would
+	// otherwise be forgotten and relayed again
+	m.Hops, m.Deadline = clamp(m, b.hops, now.Add(b.ttl))
+	fresh := b.seen.add(m.ID, nil, now)
+	deliver := b.deliver
+	b.mu.Unlock()
+	if !fresh {
+		atomic.AddUint64(&b.stats.Duplicates, 1)
+		return nil
+	}
+
+	atomic.AddUint64(&b.stats.Delivered, 1)
+	for _, f := range deliver {
+		f(m)
+	}
+
+	if m.Hops > 1 {
+		m.Hops--
+		b.relay(m)
+	}
+
+	return nil
+}
+
+// clamp the hops and deadline of a received message to the local bounds
+func clamp(m Msg, hops int, deadline time.Time) (int, time.Time) {
+	if m.Hops > hops {
+		m.Hops = hops
+	}
+
+	if m.Deadline.After(deadline) {
+		m.Deadline = deadline
+	}
+
+	return m.Hops, m.Deadline
+}
+
+// Listen handles the messages the receiver provides until it returns io.EOF
+func (b *Broadcaster) Listen(r Receiver) error {
+	for {
+		msg, err := r.Receive()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil || msg == nil {
+			continue
+		}
+
+		b.Handle(context.Background(), msg)
+	}
+}
+
+// relay the message to random peers from the sample in the background
+func (b *Broadcaster) relay(m Msg) {
+	data, _ := json.Marshal(m)
+
+	b.mu.Lock()
+	peers := b.net.Sample().Pick(b.rnd, b.fanout)
+	to := b.to
+	b.relays += len(peers)
+	b.mu.Unlock()
+
+	for _, p := range peers {
+		go func(p brahms.Node) {
+			defer b.done()
+			ctx, cancel := context.WithTimeout(context.Background(), to)
+			defer cancel()
+
+			if err := b.net.EmitTo(ctx, data, p); err != nil {
+				atomic.AddUint64(&b.stats.RelayFailed, 1)
+				return
+			}
+
+			atomic.AddUint64(&b.stats.Relayed, 1)
+		}(p)
+	}
+}
+
+// done marks a relay as done and wakes up those that wait when it was the last
+func (b *Broadcaster) done() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.relays--
+	if b.relays == 0 {
+		b.idle.Broadcast()
+	}
+}
+
+// Wait blocks until all relays that are in progress are done. Unlike a wait
+// group, relays may be started by peers while waiting.
+func (b *Broadcaster) Wait() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.relays > 0 {
+		b.idle.Wait()
+	}
+}
+
+// Stats returns a copy of the broadcaster's counters
+func (b *Broadcaster) Stats() Stats {
+	return Stats{
+		Delivered:   atomic.LoadUint64(&b.stats.Delivered),
+		Duplicates:  atomic.LoadUint64(&b.stats.Duplicates),
+		Expired:     atomic.LoadUint64(&b.stats.Expired),
+		Malformed:   atomic.LoadUint64(&b.stats.Malformed),
+		Relayed:     atomic.LoadUint64(&b.stats.Relayed),
+		RelayFailed: atomic.LoadUint64(&b.stats.RelayFailed),
+	}
+}
@@ -0,0 +1,209 @@
+package broadcast_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/advanderveer/brahms"
+	"github.com/advanderveer/brahms/broadcast"
+	"github.com/advanderveer/brahms/transport"
+	"github.com/advanderveer/go-test"
+)
+
+// memNet provides a broadcaster with the sample of a core in a mem network
+type memNet struct {
+	c  *brahms.Core
+	tr brahms.Transport
+}
+
+func (n memNet) Sample() brahms.View { return n.c.Sample() }
+func (n memNet) EmitTo(ctx context.Context, msg []byte, to brahms.Node) error {
+	return n.tr.Emit(ctx, msg, to)
+}
+
+// stubNet records the messages that are emitted to a fixed sample
+type stubNet struct {
+	sample brahms.View
+	msgs   []broadcast.Msg
+	mu     sync.Mutex
+}
+
+func (n *stubNet) Sample() brahms.View { return n.sample }
+func (n *stubNet) EmitTo(ctx context.Context, msg []byte, to brahms.Node) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var m broadcast.Msg
+	json.Unmarshal(msg, &m)
+	n.msgs = append(n.msgs, m)
+	return nil
+}
+
+func TestBroadcastHandle(t *testing.T) {
+	now := time.Unix(100, 0).UTC()
+	net := &stubNet{sample: brahms.NewView(brahms.N("127.0.0.1", 1), brahms.N("127.0.0.1", 2))}
+	b := broadcast.New(rand.New(rand.NewSource(1)), net)
+	b.SetClock(func() time.Time { return now })
+
+	var delivered []broadcast.Msg
+	b.OnDeliver(func(m broadcast.Msg) { delivered = append(delivered, m) })
+
+	m := broadcast.Msg{ID: broadcast.MID{0x01}, Hops: 2, Deadline: now.Add(time.Second), Data: []byte("foo")}
+	data, _ := json.Marshal(m)
+	test.Ok(t, b.Handle(context.Background(), data))
+	test.Ok(t, b.Handle(context.Background(), data))
+	b.Wait()
+
+	// delivered once, relayed to the fanout with one hop less
+	test.Equals(t, []broadcast.Msg{m}, delivered)
+	test.Equals(t, 2, len(net.msgs))
+	test.Equals(t, 1, net.msgs[0].Hops)
+	test.Equals(t, m.ID, net.msgs[0].ID)
+
+	// the last hop is delivered but not relayed
+	m2 := broadcast.Msg{ID: broadcast.MID{0x02}, Hops: 1, Deadline: now.Add(time.Second)}
+	data, _ = json.Marshal(m2)
+	test.Ok(t, b.Handle(context.Background(), data))
+	b.Wait()
+	test.Equals(t, 2, len(delivered))
+	test.Equals(t, 2, len(net.msgs))
+
+	// expired messages are neither delivered or relayed
+	m3 := broadcast.Msg{ID: broadcast.MID{0x03}, Hops: 2, Deadline: now.Add(-time.Second)}
+	data, _ = json.Marshal(m3)
+	test.Ok(t, b.Handle(context.Background(), data))
+	test.Equals(t, broadcast.ErrMalformed, b.Handle(context.Background(), []byte("{")))
+	test.Equals(t, broadcast.Stats{Delivered: 2, Duplicates: 1, Expired: 1, Malformed: 1, Relayed: 2}, b.Stats())
+
+	// messages we broadcast are relayed with the configured ttl
+	b.SetTTL(3, time.Minute)
+	b.SetFanout(1)
+	id, err := b.Broadcast([]byte("bar"))
+	test.Ok(t, err)
+	b.Wait()
+	test.Equals(t, 3, len(net.msgs))
+	test.Equals(t, broadcast.Msg{ID: id, Hops: 3, Deadline: now.Add(time.Minute), Data: []byte("bar")}, net.msgs[2])
+
+	// our own message is not delivered to us when it comes back
+	data, _ = json.Marshal(net.msgs[2])
+	test.Ok(t, b.Handle(context.Background(), data))
+	test.Equals(t, 2, len(delivered))
+
+	// hops and deadline of received messages are clamped to our own bounds
+	m4 := broadcast.Msg{ID: broadcast.MID{0x04}, Hops: 1000, Deadline: now.Add(time.Hour * 1000)}
+	data, _ = json.Marshal(m4)
+	test.Ok(t, b.Handle(context.Background(), data))
+	b.Wait()
+	test.Equals(t, broadcast.Msg{ID: m4.ID, Hops: 2, Deadline: now.Add(time.Minute)}, net.msgs[3])
+}
+
+// memCluster creates n cores in a mem network and runs rounds until they
+// converged such that every node is sampled
+func memCluster(r *rand.Rand, n int) (cores []*brahms.Core, tr *transport.MemNetTransport) {
+	p, _ := brahms.NewParams(0.45, 0.45, 0.1, 10, 10, 2, 0)
+	tr = transport.NewMemNetTransport()
+	for i := 0; i < n; i++ {
+		self := brahms.N("127.0.0.1", uint16(i+1))
+		other := brahms.N("127.0.0.1", uint16((i+1)%n+1))
+
+		c := brahms.NewCore(rand.New(rand.NewSource(r.Int63())), self, brahms.NewView(other), p, brahms.AlwaysRefresh, tr, time.Second)
+		tr.AddCore(c)
+		cores = append(cores, c)
+	}
+
+	for i := 0; i < 30; i++ {
+		for _, c := range cores {
+			c.UpdateView(time.Second)
+			c.ValidateSample(time.Second)
+		}
+	}
+
+	return
+}
+
+// settle waits until the nodes of a mem network stopped sending, sends cause
+// sends on other nodes so it waits until a pass over all of them completes none.
+func settle(waits []func(), sent func() uint64) {
+	for done := uint64(0); ; {
+		for _, w := range waits {
+			w()
+		}
+
+		if total := sent(); total != done {
+			done = total
+			continue
+		}
+
+		return
+	}
+}
+
+func TestBroadcastMemNetwork(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	r := rand.New(rand.NewSource(1))
+	n := 100
+	cores, tr := memCluster(r, n)
+
+	var mu sync.Mutex
+	var waits []func()
+	bcs := make([]*broadcast.Broadcaster, 0, n)
+	delivered := make([]map[broadcast.MID]int, n)
+	for i, c := range cores {
+		b := broadcast.New(rand.New(rand.NewSource(r.Int63())), memNet{c, tr})
+		b.SetFanout(10)
+
+		i := i
+		delivered[i] = map[broadcast.MID]int{}
+		b.OnDeliver(func(m broadcast.Msg) {
+			mu.Lock()
+			defer mu.Unlock()
+			delivered[i][m.ID]++
+		})
+
+		tr.AddPeer(c.Self(), transport.WithEmit(transport.CorePeer(c), b.Handle))
+		bcs = append(bcs, b)
+		waits = append(waits, b.Wait)
+	}
+
+	ids := make([]broadcast.MID, 0, 5)
+	for i := 0; i < 5; i++ {
+		id, err := bcs[r.Intn(n)].Broadcast([]byte(fmt.Sprintf("msg-%d", i)))
+		test.Ok(t, err)
+		ids = append(ids, id)
+	}
+
+	settle(waits, func() (total uint64) {
+		for _, b := range bcs {
+			total += b.Stats().Relayed + b.Stats().RelayFailed
+		}
+
+		return
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, id := range ids {
+		var nd int
+		for i := range delivered {
+			test.Assert(t, delivered[i][id] <= 1, "should deliver at most once")
+			nd += delivered[i][id]
+		}
+
+		test.Equals(t, n-1, nd) // every node but the origin
+	}
+
+	var dups uint64
+	for _, b := range bcs {
+		dups += b.Stats().Duplicates
+	}
+
+	test.Assert(t, dups > 0, "redundant relays should be deduplicated")
+}
@@ -0,0 +1,67 @@
+package broadcast
+
+import "time"
+
+// cache remembers the ids of messages that were seen within a time window. If
+// more then max ids are seen within the window the oldest are forgotten first.
+// Optionally, the message itself is remembered as well.
+type cache struct {
+	window time.Duration
+	seen   map[MID]*Msg
+	ring   []entry
+	head   int
+	n      int
+}
+
+// entry is an id and when it was seen
+type entry struct {
+	id MID
+	at time.Time
+}
+
+func newCache(window time.Duration, max int) *cache {
+	if max < 1 {
+		max = 1
+	}
+
+	return &cache{window: window, seen: make(map[MID]*Msg, max), ring: make([]entry, max)}
+}
+
+// add remembers the id with the message, which may be nil. It returns true, or
+// false if the id was already seen.
+func (c *cache) add(id MID, m *Msg, now time.Time) bool {
+	for c.n > 0 && now.Sub(c.ring[c.head].at) >= c.window {
+		c.evict() //expired
+	}
+
+	if _, ok := c.seen[id]; ok {
+		return false
+	}
+
+	if c.n >= len(c.ring) {
+		c.evict() //full
+	}
+
+	c.ring[(c.head+c.n)%len(c.ring)] = entry{id, now}
+	c.seen[id] = m
+	c.n++
+	return true
+}
+
+// get returns the message that was remembered with the id, if any
+func (c *cache) get(id MID) *Msg {
+	return c.seen[id]
+}
+
+// has returns whether the id was seen
+func (c *cache) has(id MID) bool {
+	_, ok := c.seen[id]
+	return ok
+}
+
+// evict forgets the oldest id
+func (c *cache) evict() {
+	delete(c.seen, c.ring[c.head].id)
+	c.head = (c.head + 1) % len(c.ring)
+	c.n--
+}
@@ -0,0 +1,35 @@
+package broadcast
+
+import (
+	"testing"
+	"time"
+
+	"github.com/advanderveer/go-test"
+)
+
+func TestCache(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := newCache(time.Second, 2)
+	test.Equals(t, true, c.add(MID{0x01}, nil, now))
+	test.Equals(t, false, c.add(MID{0x01}, nil, now))
+	test.Equals(t, true, c.add(MID{0x02}, nil, now.Add(time.Millisecond*500)))
+
+	// when full the oldest id is forgotten first
+	test.Equals(t, true, c.add(MID{0x03}, nil, now.Add(time.Millisecond*600)))
+	test.Equals(t, 2, len(c.seen))
+	test.Equals(t, false, c.add(MID{0x02}, nil, now.Add(time.Millisecond*700)))
+	test.Equals(t, true, c.add(MID{0x01}, nil, now.Add(time.Millisecond*700)))
+
+	// ids are forgotten after the window
+	test.Equals(t, true, c.add(MID{0x02}, nil, now.Add(time.Millisecond*1500)))
+	test.Equals(t, 2, len(c.seen))
+	test.Equals(t, true, c.add(MID{0x03}, nil, now.Add(time.Second*3)))
+	test.Equals(t, 1, len(c.seen))
+
+	// messages are optionally remembered with their id
+	m := &Msg{ID: MID{0x04}}
+	test.Equals(t, true, c.add(m.ID, m, now.Add(time.Second*3)))
+	test.Equals(t, m, c.get(m.ID))
+	test.Equals(t, (*Msg)(nil), c.get(MID{0x03}))
+	test.Equals(t, true, c.has(MID{0x03}))
+}
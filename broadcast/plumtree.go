@@ -0,0 +1,450 @@
+package broadcast
+
+import (
+	"context"
+	crand "crypto/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/advanderveer/brahms"
+)
+
+// DefaultGraftTimeout is how long a plumtree waits for a message that was
+// announced to it before it grafts the peer that announced it
+const DefaultGraftTimeout = time.Second
+
+// TreeTransport sends the messages of the plumtree protocol to peers, the
+// http and mem transports implement it
+type TreeTransport interface {
+	Gossip(ctx context.Context, self brahms.Node, m Msg, to brahms.Node) error
+	IHave(ctx context.Context, self brahms.Node, ids []MID, to brahms.Node) error
+	Graft(ctx context.Context, self brahms.Node, ids []MID, to brahms.Node) error
+	Prune(ctx context.Context, self brahms.Node, to brahms.Node) error
+}
+
+// TreeHandler handles the messages of the plumtree protocol that peers sent
+// to us, the Plumtree implements it
+type TreeHandler interface {
+	HandleGossip(ctx context.Context, from brahms.Node, m Msg) error
+	HandleIHave(ctx context.Context, from brahms.Node, ids []MID) error
+	HandleGraft(ctx context.Context, from brahms.Node, ids []MID) error
+	HandlePrune(ctx context.Context, from brahms.Node) error
+}
+
+// TreeStats counts what happened to the messages a plumtree received and sent
+type TreeStats struct {
+	Delivered  uint64 // messages that were delivered for the first time
+	Duplicates uint64 // messages that were delivered before
+	Expired    uint64 // messages that arrived after their deadline
+	Gossiped   uint64 // messages that were eagerly pushed to a peer
+	Announced  uint64 // message ids that were lazily pushed to a peer
+	Grafted    uint64 // grafts that were sent for a missing message
+	Pruned     uint64 // prunes that were sent for a duplicate message
+	SendFailed uint64 // sends that failed or timed out
+}
+
+// missing is a message that was announced to us but didn't arrive yet
+type missing struct {
+	from  []brahms.Node
+	timer *time.Timer
+}
+
+// Plumtree broadcasts messages along a spanning tree of the overlay, as
+// described in "Epidemic Broadcast Trees" by Leitão et al. Peers of the sample
+// start out as eager peers that receive every message. A peer that sends us a
+// message we already received is pruned to become a lazy peer, which only
+// receives the ids of messages. If an announced message doesn't arrive in time
+// the tree is repaired by grafting the peer that announced it.
+type Plumtree struct {
+	self    brahms.Node
+	peers   Peers
+	tr      TreeTransport
+	hops    int
+	ttl     time.Duration
+	to      time.Duration
+	graftTo time.Duration
+	now     func() time.Time
+	seen    *cache
+	eager   brahms.View
+	lazy    brahms.View
+	missing map[MID]*missing
+	deliver []func(m Msg)
+	stats   TreeStats
+	sending int
+	idle    *sync.Cond
+	mu      sync.Mutex
+}
+
+// NewPlumtree initializes a plumtree for the node that builds its tree over
+// the sample of peers and sends messages with the transport
+func NewPlumtree(self brahms.Node, peers Peers, tr TreeTransport) (p *Plumtree) {
+	p = &Plumtree{
+		self:    self,
+		peers:   peers,
+		tr:      tr,
+		hops:    DefaultHops,
+		ttl:     DefaultTTL,
+		to:      DefaultRelayTimeout,
+		graftTo: DefaultGraftTimeout,
+		now:     time.Now,
+		seen:    newCache(DefaultTTL, DefaultCacheSize),
+		eager:   brahms.View{},
+		lazy:    brahms.View{},
+		missing: map[MID]*missing{},
+	}
+
+	p.idle = sync.NewCond(&p.mu)
+	return p
+}
+
+// SetTTL configures how many times and for how long the messages we broadcast
+// are relayed. Messages are remembered for the ttl to repair the tree with,
+// such that it resets what messages are remembered.
+func (p *Plumtree) SetTTL(hops int, ttl time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hops, p.ttl = hops, ttl
+	p.seen = newCache(ttl, len(p.seen.ring))
+}
+
+// SetCacheSize configures the nr of messages that are remembered at most, it
+// resets what messages are remembered.
+func (p *Plumtree) SetCacheSize(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seen = newCache(p.ttl, n)
+}
+
+// SetRelayTimeout configures how long sending a message to a peer may take
+func (p *Plumtree) SetRelayTimeout(to time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.to = to
+}
+
+// SetGraftTimeout configures how long we wait for a message that was
+// announced to us, before the peer that announced it is grafted.
+func (p *Plumtree) SetGraftTimeout(to time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.graftTo = to
+}
+
+// SetClock configures the clock deadlines are checked with, by default this
+// is the wall clock.
+func (p *Plumtree) SetClock(now func() time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.now = now
+}
+
+// OnDeliver registers a callback that is called with every message the first
+// time it is received. Callbacks are called synchronously while handling the
+// message, they should not block.
+func (p *Plumtree) OnDeliver(f func(m Msg)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.deliver = append(p.deliver, f)
+}
+
+// Broadcast a message with a new id to the network. It is not delivered to
+// ourselves, sending happens in the background.
+func (p *Plumtree) Broadcast(data []byte) (id MID, err error) {
+	_, err = crand.Read(id[:])
+	if err != nil {
+		return id, err
+	}
+
+	sample := p.peers.Sample()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	m := Msg{ID: id, Hops: p.hops, Deadline: now.Add(p.ttl), Data: data}
+	p.seen.add(id, &m, now)
+	p.sync(sample)
+	p.push(m, brahms.Node{})
+	return id, nil
+}
+
+// HandleGossip handles a message that was eagerly pushed to us. A new message
+// is delivered and relayed, the sender of a duplicate is pruned.
+func (p *Plumtree) HandleGossip(ctx context.Context, from brahms.Node, m Msg) error {
+	sample := p.peers.Sample()
+
+	p.mu.Lock()
+	p.sync(sample)
+	id, now := from.Hash(), p.now()
+	if now.After(m.Deadline) {
+		p.mu.Unlock()
+		atomic.AddUint64(&p.stats.Expired, 1)
+		return nil
+	}
+
+	m.Hops, m.Deadline = clamp(m, p.hops, now.Add(p.ttl))
+	mm := m //remembered to answer grafts with
+	if !p.seen.add(m.ID, &mm, now) {
+		p.moveLazy(id)
+		p.send(func(ctx context.Context) error { return p.tr.Prune(ctx, p.self, from) }, &p.stats.Pruned)
+		p.mu.Unlock()
+		atomic.AddUint64(&p.stats.Duplicates, 1)
+		return nil
+	}
+
+	if ms, ok := p.missing[m.ID]; ok {
+		ms.timer.Stop()
+		delete(p.missing, m.ID)
+		p.wake()
+	}
+
+	// the sender is our parent in the tree
+	if n, ok := sample[id]; ok {
+		p.moveEager(id, n)
+	}
+
+	if m.Hops > 1 {
+		mm.Hops--
+		p.push(mm, from)
+	}
+
+	deliver := p.deliver
+	p.mu.Unlock()
+
+	atomic.AddUint64(&p.stats.Delivered, 1)
+	for _, f := range deliver {
+		f(m)
+	}
+
+	return nil
+}
+
+// HandleIHave handles the ids of messages that a peer announced to us, for
+// messages we don't have a graft timer is started.
+func (p *Plumtree) HandleIHave(ctx context.Context, from brahms.Node, ids []MID) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, mid := range ids {
+		if p.seen.has(mid) {
+			continue
+		}
+
+		ms, ok := p.missing[mid]
+		if !ok {
+			if len(p.missing) >= len(p.seen.ring) {
+				continue //too many missing messages to keep track of
+			}
+
+			mid := mid
+			ms = &missing{timer: time.AfterFunc(p.graftTo, func() { p.graft(mid) })}
+			p.missing[mid] = ms
+		}
+
+		ms.from = append(ms.from, from)
+	}
+
+	return nil
+}
+
+// HandleGraft handles a peer that requests messages it is missing, the peer
+// becomes an eager peer such that it receives messages through us. Grafts of
+// nodes that are not in our sample are ignored, others could otherwise have
+// us send them every message.
+func (p *Plumtree) HandleGraft(ctx context.Context, from brahms.Node, ids []MID) error {
+	sample := p.peers.Sample()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sync(sample)
+	from, ok := sample[from.Hash()]
+	if !ok {
+		return nil //not one of our peers
+	}
+
+	p.moveEager(from.Hash(), from)
+	for _, mid := range ids {
+		m := p.seen.get(mid)
+		if m == nil {
+			continue //no longer remembered
+		}
+
+		mm := *m
+		p.send(func(ctx context.Context) error { return p.tr.Gossip(ctx, p.self, mm, from) }, &p.stats.Gossiped)
+	}
+
+	return nil
+}
+
+// HandlePrune handles a peer that received a duplicate from us, it becomes a
+// lazy peer that only receives message ids. Only peers in our sample are
+// eager, prunes of other nodes have no effect.
+func (p *Plumtree) HandlePrune(ctx context.Context, from brahms.Node) error {
+	sample := p.peers.Sample()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sync(sample)
+	p.moveLazy(from.Hash())
+	return nil
+}
+
+// graft the first peer that announced a message that is still missing and
+// wait for the next peer if it doesn't arrive either. Any peer can announce a
+// message, but only peers in our sample become eager peers.
+func (p *Plumtree) graft(mid MID) {
+	sample := p.peers.Sample()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ms, ok := p.missing[mid]
+	if !ok {
+		return //arrived in the meantime
+	}
+
+	if len(ms.from) < 1 {
+		delete(p.missing, mid)
+		p.wake()
+		return
+	}
+
+	from := ms.from[0]
+	ms.from = ms.from[1:]
+	ms.timer = time.AfterFunc(p.graftTo, func() { p.graft(mid) })
+	p.sync(sample)
+	if n, ok := sample[from.Hash()]; ok {
+		p.moveEager(from.Hash(), n)
+	}
+
+	p.send(func(ctx context.Context) error { return p.tr.Graft(ctx, p.self, []MID{mid}, from) }, &p.stats.Grafted)
+}
+
+// push a message to our eager peers and announce it to our lazy peers, except
+// the peer we received it from. The caller must hold the lock.
+func (p *Plumtree) push(m Msg, from brahms.Node) {
+	fid := from.Hash()
+	for id, n := range p.eager {
+		if id == fid {
+			continue
+		}
+
+		n := n
+		p.send(func(ctx context.Context) error { return p.tr.Gossip(ctx, p.self, m, n) }, &p.stats.Gossiped)
+	}
+
+	for id, n := range p.lazy {
+		if id == fid {
+			continue
+		}
+
+		n := n
+		p.send(func(ctx context.Context) error { return p.tr.IHave(ctx, p.self, []MID{m.ID}, n) }, &p.stats.Announced)
+	}
+}
+
+// sync our eager and lazy peers with the sample: peers that left the sample
+// are forgotten and new peers start out as eager peers. The caller must hold
+// the lock.
+func (p *Plumtree) sync(sample brahms.View) {
+	for id := range p.eager {
+		if _, ok := sample[id]; !ok {
+			delete(p.eager, id)
+		}
+	}
+
+	for id := range p.lazy {
+		if _, ok := sample[id]; !ok {
+			delete(p.lazy, id)
+		}
+	}
+
+	for id, n := range sample {
+		_, eager := p.eager[id]
+		_, lazy := p.lazy[id]
+		if !eager && !lazy {
+			p.eager[id] = n
+		}
+	}
+}
+
+// moveEager makes the peer an eager peer, the caller must hold the lock
+func (p *Plumtree) moveEager(id brahms.NID, n brahms.Node) {
+	delete(p.lazy, id)
+	p.eager[id] = n
+}
+
+// moveLazy makes an eager peer a lazy peer, the caller must hold the lock
+func (p *Plumtree) moveLazy(id brahms.NID) {
+	n, ok := p.eager[id]
+	if !ok {
+		return
+	}
+
+	delete(p.eager, id)
+	p.lazy[id] = n
+}
+
+// send runs f in the background with the relay timeout and counts it, the
+// caller must hold the lock
+func (p *Plumtree) send(f func(ctx context.Context) error, counter *uint64) {
+	to := p.to
+	p.sending++
+	go func() {
+		defer p.done()
+		ctx, cancel := context.WithTimeout(context.Background(), to)
+		defer cancel()
+
+		if err := f(ctx); err != nil {
+			atomic.AddUint64(&p.stats.SendFailed, 1)
+			return
+		}
+
+		atomic.AddUint64(counter, 1)
+	}()
+}
+
+// done marks a send as done
+func (p *Plumtree) done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sending--
+	p.wake()
+}
+
+// wake up those that wait when no sends or grafts are in progress anymore,
+// the caller must hold the lock
+func (p *Plumtree) wake() {
+	if p.sending == 0 && len(p.missing) == 0 {
+		p.idle.Broadcast()
+	}
+}
+
+// Peers returns copies of our current eager and lazy peers
+func (p *Plumtree) Peers() (eager, lazy brahms.View) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.eager.Copy(), p.lazy.Copy()
+}
+
+// Wait blocks until all sends and grafts that are in progress are done.
+// Unlike a wait group, sends may be started by peers while waiting.
+func (p *Plumtree) Wait() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.sending > 0 || len(p.missing) > 0 {
+		p.idle.Wait()
+	}
+}
+
+// Stats returns a copy of the plumtree's counters
+func (p *Plumtree) Stats() TreeStats {
+	return TreeStats{
+		Delivered:  atomic.LoadUint64(&p.stats.Delivered),
+		Duplicates: atomic.LoadUint64(&p.stats.Duplicates),
+		Expired:    atomic.LoadUint64(&p.stats.Expired),
+		Gossiped:   atomic.LoadUint64(&p.stats.Gossiped),
+		Announced:  atomic.LoadUint64(&p.stats.Announced),
+		Grafted:    atomic.LoadUint64(&p.stats.Grafted),
+		Pruned:     atomic.LoadUint64(&p.stats.Pruned),
+		SendFailed: atomic.LoadUint64(&p.stats.SendFailed),
+	}
+}
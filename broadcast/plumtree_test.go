@@ -0,0 +1,341 @@
+package broadcast_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/advanderveer/brahms"
+	"github.com/advanderveer/brahms/broadcast"
+	"github.com/advanderveer/brahms/transport"
+	"github.com/advanderveer/go-test"
+)
+
+// stubPeers provides a fixed sample
+type stubPeers brahms.View
+
+func (p stubPeers) Sample() brahms.View { return brahms.View(p).Copy() }
+
+// treeSent is a plumtree message that was sent
+type treeSent struct {
+	typ string
+	to  uint16
+}
+
+// stubTree records the plumtree messages that are sent
+type stubTree struct {
+	sent []treeSent
+	mu   sync.Mutex
+}
+
+func (t *stubTree) record(typ string, to brahms.Node) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sent = append(t.sent, treeSent{typ, to.Port})
+	return nil
+}
+
+func (t *stubTree) take() (sent map[treeSent]struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sent = map[treeSent]struct{}{}
+	for _, s := range t.sent {
+		sent[s] = struct{}{}
+	}
+
+	t.sent = nil
+	return
+}
+
+func (t *stubTree) Gossip(ctx context.Context, self brahms.Node, m broadcast.Msg, to brahms.Node) error {
+	return t.record("gossip", to)
+}
+
+func (t *stubTree) IHave(ctx context.Context, self brahms.Node, ids []broadcast.MID, to brahms.Node) error {
+	return t.record("ihave", to)
+}
+
+func (t *stubTree) Graft(ctx context.Context, self brahms.Node, ids []broadcast.MID, to brahms.Node) error {
+	return t.record("graft", to)
+}
+
+func (t *stubTree) Prune(ctx context.Context, self brahms.Node, to brahms.Node) error {
+	return t.record("prune", to)
+}
+
+func TestPlumtree(t *testing.T) {
+	self, n1, n2 := brahms.N("127.0.0.1", 1), brahms.N("127.0.0.1", 2), brahms.N("127.0.0.1", 3)
+	tr := &stubTree{}
+	p := broadcast.NewPlumtree(*self, stubPeers(brahms.NewView(n1, n2)), tr)
+	p.SetGraftTimeout(time.Millisecond * 10)
+
+	var delivered []broadcast.Msg
+	p.OnDeliver(func(m broadcast.Msg) { delivered = append(delivered, m) })
+
+	// a new message is delivered and eagerly pushed to every other peer
+	m := broadcast.Msg{ID: broadcast.MID{0x01}, Hops: 2, Deadline: time.Now().Add(time.Minute), Data: []byte("foo")}
+	test.Ok(t, p.HandleGossip(context.Background(), *n1, m))
+	p.Wait()
+	test.Equals(t, []broadcast.Msg{m}, delivered)
+	test.Equals(t, map[treeSent]struct{}{{typ: "gossip", to: 3}: {}}, tr.take())
+
+	// the sender of a duplicate is pruned and becomes lazy
+	test.Ok(t, p.HandleGossip(context.Background(), *n2, m))
+	p.Wait()
+	test.Equals(t, 1, len(delivered))
+	test.Equals(t, map[treeSent]struct{}{{typ: "prune", to: 3}: {}}, tr.take())
+	eager, lazy := p.Peers()
+	test.Equals(t, brahms.NewView(n1), eager)
+	test.Equals(t, brahms.NewView(n2), lazy)
+
+	// our broadcasts are pushed to eager peers and announced to lazy peers
+	_, err := p.Broadcast([]byte("bar"))
+	test.Ok(t, err)
+	p.Wait()
+	test.Equals(t, map[treeSent]struct{}{{typ: "gossip", to: 2}: {}, {typ: "ihave", to: 3}: {}}, tr.take())
+
+	// a message that is announced but doesn't arrive is grafted
+	test.Ok(t, p.HandleIHave(context.Background(), *n2, []broadcast.MID{{0x02}}))
+	p.Wait()
+	sent := tr.take()
+	_, ok := sent[treeSent{typ: "graft", to: 3}]
+	test.Equals(t, true, ok)
+	eager, lazy = p.Peers()
+	test.Equals(t, brahms.NewView(n1, n2), eager)
+	test.Equals(t, brahms.View{}, lazy)
+
+	// a peer that grafts receives the message it misses
+	test.Ok(t, p.HandlePrune(context.Background(), *n1))
+	test.Ok(t, p.HandleGraft(context.Background(), *n1, []broadcast.MID{m.ID, {0x03}}))
+	p.Wait()
+	test.Equals(t, map[treeSent]struct{}{{typ: "gossip", to: 2}: {}}, tr.take())
+	eager, _ = p.Peers()
+	test.Equals(t, brahms.NewView(n1, n2), eager)
+
+	// messages that announced ids we already have are not grafted
+	test.Ok(t, p.HandleIHave(context.Background(), *n2, []broadcast.MID{m.ID}))
+	p.Wait()
+	test.Equals(t, map[treeSent]struct{}{}, tr.take())
+	test.Equals(t, broadcast.TreeStats{Delivered: 1, Duplicates: 1, Gossiped: 3, Announced: 1, Grafted: 1, Pruned: 1}, p.Stats())
+
+	// nodes outside our sample don't receive messages by grafting, and their
+	// announcements are grafted without making them eager peers
+	other := brahms.N("127.0.0.1", 4)
+	test.Ok(t, p.HandleGraft(context.Background(), *other, []broadcast.MID{m.ID}))
+	test.Ok(t, p.HandlePrune(context.Background(), *other))
+	test.Ok(t, p.HandleIHave(context.Background(), *other, []broadcast.MID{{0x05}}))
+	p.Wait()
+	test.Equals(t, map[treeSent]struct{}{{typ: "graft", to: 4}: {}}, tr.take())
+	eager, lazy = p.Peers()
+	test.Equals(t, brahms.NewView(n1, n2), eager)
+	test.Equals(t, brahms.View{}, lazy)
+
+	// hops and deadline of received messages are clamped to our own bounds
+	p.SetTTL(3, time.Minute)
+	m4 := broadcast.Msg{ID: broadcast.MID{0x04}, Hops: 1000, Deadline: time.Now().Add(time.Hour * 1000)}
+	test.Ok(t, p.HandleGossip(context.Background(), *n1, m4))
+	p.Wait()
+	test.Equals(t, 3, delivered[1].Hops)
+	test.Assert(t, delivered[1].Deadline.Before(time.Now().Add(time.Minute)), "deadline should be clamped")
+}
+
+// memTrees creates a plumtree for every core of a mem network
+func memTrees(r *rand.Rand, cores []*brahms.Core, tr *transport.MemNetTransport, deliver func(i int, m broadcast.Msg)) (pts []*broadcast.Plumtree, waits []func()) {
+	for i, c := range cores {
+		pt := broadcast.NewPlumtree(c.Self(), c, tr)
+		pt.SetGraftTimeout(time.Millisecond * 50)
+
+		i := i
+		pt.OnDeliver(func(m broadcast.Msg) { deliver(i, m) })
+		tr.AddPeer(c.Self(), transport.WithTree(transport.CorePeer(c), pt))
+		pts = append(pts, pt)
+		waits = append(waits, pt.Wait)
+	}
+
+	return
+}
+
+// treeSends returns the nr of messages the plumtrees sent
+func treeSends(pts []*broadcast.Plumtree) func() uint64 {
+	return func() (total uint64) {
+		for _, pt := range pts {
+			st := pt.Stats()
+			total += st.Gossiped + st.Announced + st.Grafted + st.Pruned + st.SendFailed
+		}
+
+		return
+	}
+}
+
+func TestPlumtreeMemNetwork(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	r := rand.New(rand.NewSource(1))
+	n := 100
+	cores, tr := memCluster(r, n)
+
+	var mu sync.Mutex
+	delivered := make([]map[broadcast.MID]int, n)
+	for i := range delivered {
+		delivered[i] = map[broadcast.MID]int{}
+	}
+
+	pts, waits := memTrees(r, cores, tr, func(i int, m broadcast.Msg) {
+		mu.Lock()
+		defer mu.Unlock()
+		delivered[i][m.ID]++
+	})
+
+	// the first message floods the sample, duplicates prune it into a tree
+	var dups []uint64
+	ids := make([]broadcast.MID, 0, 10)
+	for i := 0; i < 10; i++ {
+		var before uint64
+		for _, pt := range pts {
+			before += pt.Stats().Duplicates
+		}
+
+		id, err := pts[r.Intn(n)].Broadcast([]byte(fmt.Sprintf("msg-%d", i)))
+		test.Ok(t, err)
+		ids = append(ids, id)
+		settle(waits, treeSends(pts))
+
+		var after uint64
+		for _, pt := range pts {
+			after += pt.Stats().Duplicates
+		}
+
+		dups = append(dups, after-before)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, id := range ids {
+		var nd int
+		for i := range delivered {
+			test.Assert(t, delivered[i][id] <= 1, "should deliver at most once")
+			nd += delivered[i][id]
+		}
+
+		test.Equals(t, n-1, nd) // every node but the origin
+	}
+
+	test.Assert(t, dups[0] > uint64(n), fmt.Sprintf("first message should flood, got %d duplicates", dups[0]))
+	test.Assert(t, dups[len(dups)-1] < uint64(n/2), fmt.Sprintf("last message should follow the tree, got %d duplicates", dups[len(dups)-1]))
+}
+
+func TestPlumtreeRepair(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	r := rand.New(rand.NewSource(2))
+	n := 50
+	cores, tr := memCluster(r, n)
+
+	var mu sync.Mutex
+	delivered := map[int]int{}
+	pts, waits := memTrees(r, cores, tr, func(i int, m broadcast.Msg) {
+		mu.Lock()
+		defer mu.Unlock()
+		delivered[i]++
+	})
+
+	// build the tree
+	for i := 0; i < 5; i++ {
+		_, err := pts[0].Broadcast([]byte("build"))
+		test.Ok(t, err)
+		settle(waits, treeSends(pts))
+	}
+
+	// nodes that stop relaying break the tree, the lazy pushes repair it
+	dead := map[int]struct{}{}
+	for len(dead) < 5 {
+		i := 1 + r.Intn(n-1)
+		dead[i] = struct{}{}
+		tr.AddPeer(cores[i].Self(), transport.CorePeer(cores[i]))
+	}
+
+	mu.Lock()
+	delivered = map[int]int{}
+	mu.Unlock()
+
+	_, err := pts[0].Broadcast([]byte("repair"))
+	test.Ok(t, err)
+	settle(waits, treeSends(pts))
+
+	mu.Lock()
+	defer mu.Unlock()
+	test.Equals(t, n-1-len(dead), len(delivered))
+
+	var grafts uint64
+	for _, pt := range pts {
+		grafts += pt.Stats().Grafted
+	}
+
+	test.Assert(t, grafts > 0, "tree should be repaired by grafting")
+}
+
+func BenchmarkDuplicateRatio(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	n := 100
+	cores, tr := memCluster(r, n)
+
+	b.Run("fanout", func(b *testing.B) {
+		var waits []func()
+		bcs := make([]*broadcast.Broadcaster, 0, n)
+		for _, c := range cores {
+			bc := broadcast.New(rand.New(rand.NewSource(r.Int63())), memNet{c, tr})
+			tr.AddPeer(c.Self(), transport.WithEmit(transport.CorePeer(c), bc.Handle))
+			bcs = append(bcs, bc)
+			waits = append(waits, bc.Wait)
+		}
+
+		sent := func() (total uint64) {
+			for _, bc := range bcs {
+				total += bc.Stats().Relayed + bc.Stats().RelayFailed
+			}
+
+			return
+		}
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			bcs[r.Intn(n)].Broadcast([]byte("foo"))
+			settle(waits, sent)
+		}
+
+		var st broadcast.Stats
+		for _, bc := range bcs {
+			st.Delivered += bc.Stats().Delivered
+			st.Duplicates += bc.Stats().Duplicates
+		}
+
+		b.ReportMetric(float64(st.Duplicates)/float64(st.Delivered), "dups/delivery")
+		b.ReportMetric(float64(st.Delivered)/float64(b.N*(n-1)), "coverage")
+	})
+
+	b.Run("plumtree", func(b *testing.B) {
+		pts, waits := memTrees(r, cores, tr, func(int, broadcast.Msg) {})
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			pts[r.Intn(n)].Broadcast([]byte("foo"))
+			settle(waits, treeSends(pts))
+		}
+
+		var st broadcast.TreeStats
+		for _, pt := range pts {
+			st.Delivered += pt.Stats().Delivered
+			st.Duplicates += pt.Stats().Duplicates
+		}
+
+		b.ReportMetric(float64(st.Duplicates)/float64(st.Delivered), "dups/delivery")
+		b.ReportMetric(float64(st.Delivered)/float64(b.N*(n-1)), "coverage")
+	})
+}
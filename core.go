@@ -1,33 +1,66 @@
 package brahms
 
 import (
+	"context"
+	"crypto/ed25519"
+	"math"
 	"math/rand"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// Stats counts what happened to the pushes a core received
+type Stats struct {
+	PushesReceived uint64 // pushes with a valid stamp
+	PushesRejected uint64 // pushes with an invalid stamp or metadata
+	PushesDropped  uint64 // valid pushes that didn't fit the push buffer
+}
+
 // Core keeps the state of a node in the gossip network
 type Core struct {
 	rnd     *rand.Rand
 	self    *Node
 	view    atomic.Value
-	pushes  chan Node
+	pushes  atomic.Value
+	pushmu  sync.RWMutex
 	params  P
+	policy  ViewPolicy
 	sampler *Sampler
 	tr      Transport
+	events  *feed
+	stats   Stats
+	stamps  stampLog
+	period  time.Duration
+	jitter  float64
 	active  int32
+	inc     uint64
+	meta    atomic.Value
+	metamu  sync.Mutex
 }
 
-// NewCore initializes the core
-func NewCore(rnd *rand.Rand, self *Node, v0 View, p P, tr Transport, ito time.Duration) (c *Core) {
+// meta is the metadata of the core with its version
+type meta struct {
+	m   Meta
+	ver uint64
+}
+
+// NewCore initializes the core, without a view policy the view is always
+// refreshed.
+func NewCore(rnd *rand.Rand, self *Node, v0 View, p P, vp ViewPolicy, tr Transport, ito time.Duration) (c *Core) {
+	if vp == nil {
+		vp = AlwaysRefresh
+	}
+
 	c = &Core{
 		self:    self,
-		pushes:  make(chan Node, p.L1α()+10), // slightly larger then what the algorithm accepts
 		params:  p,
+		policy:  vp,
 		sampler: NewSampler(rnd, p.L2(), tr, ito),
 		tr:      tr,
 		rnd:     rnd,
+		events:  newFeed(),
 
 		// the active flag is implemented as an atomic uint32 so it can be read
 		// concurrently without locking the whole core. This happens when many
@@ -39,7 +72,18 @@ func NewCore(rnd *rand.Rand, self *Node, v0 View, p P, tr Transport, ito time.Du
 	// on reading and updating it
 	c.view.Store(v0)
 
-	//initialize the sampler with our initial view
+	// our metadata is an atomic value as well, it is read every time we push
+	// our own info
+	c.meta.Store(meta{self.Meta.Copy(), self.Ver})
+
+	// the push buffer is slightly larger then what the algorithm accepts, it
+	// is an atomic value such that it can grow with adaptive parameters
+	c.pushes.Store(make(chan Node, p.L1α()+10))
+
+	//initialize the sampler with our initial view, helpers of indirect probes
+	//identify us by our address and key
+	c.sampler.events = c.events
+	c.sampler.self = Node{IP: self.IP, Port: self.Port, Key: self.Key}
 	c.sampler.Update(v0)
 	return
 }
@@ -49,19 +93,179 @@ func (c *Core) Self() (n Node) {
 	n.IP = make(net.IP, len(c.self.IP))
 	copy(n.IP, c.self.IP)
 	n.Port = c.self.Port
+	n.Inc = atomic.LoadUint64(&c.inc)
+	md := c.meta.Load().(meta)
+	n.Meta, n.Ver = md.m.Copy(), md.ver
+	if c.self.Key != nil {
+		n.Key = make(ed25519.PublicKey, len(c.self.Key))
+		copy(n.Key, c.self.Key)
+	}
+
 	return
 }
 
+// SetMeta replaces the metadata this core advertises and bumps its version,
+// such that it replaces the older metadata as it is gossiped. It returns
+// ErrMetaTooLarge if the metadata exceeds its bounds.
+func (c *Core) SetMeta(m Meta) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
+	c.metamu.Lock()
+	defer c.metamu.Unlock()
+	c.meta.Store(meta{m.Copy(), c.meta.Load().(meta).ver + 1})
+	return nil
+}
+
+// Subscribe returns a subscription on membership events with a buffer of n
+// events. Events that don't fit the buffer are dropped, such that a slow
+// consumer never slows down the protocol.
+func (c *Core) Subscribe(n int) *Subscription {
+	return c.events.subscribe(n)
+}
+
+// SetProbeOrder configures how the samples to validate are picked
+func (c *Core) SetProbeOrder(o ProbeOrder) {
+	c.sampler.SetProbeOrder(o)
+}
+
+// SetIndirectProbes configures how many other samples are asked to probe a
+// sample that didn't respond to our direct probe, before it is invalidated.
+func (c *Core) SetIndirectProbes(k int) {
+	c.sampler.SetIndirectProbes(k)
+}
+
+// SetSubnetCap configures how many nodes of the same IPv4 /24 or IPv6 /48 can
+// be in the view and sample, zero means no cap.
+func (c *Core) SetSubnetCap(max int) {
+	c.sampler.SetSubnetCap(max)
+}
+
+// SetSuspicionTimeout configures how long a sample can be suspected of being
+// dead before it is invalidated. Suspected samples are not returned by Sample.
+func (c *Core) SetSuspicionTimeout(sto time.Duration) {
+	c.sampler.SetSuspicionTimeout(sto)
+}
+
+// SetClock configures the clock of the core, by default this is the wall clock
+// but a simulation can run cores on a virtual clock.
+func (c *Core) SetClock(now func() time.Time) {
+	c.sampler.SetClock(now)
+}
+
+// SetGossipPeriod configures the time between the start of two rounds. Each
+// period is randomly shortened or lengthened by up to a fraction (jitter) of
+// it, such that peers don't synchronize their rounds. The jitter must be in
+// [0, 1) such that periods never become zero or negative.
+func (c *Core) SetGossipPeriod(period time.Duration, jitter float64) error {
+	if !(jitter >= 0 && jitter < 1) {
+		return ErrJitterRange
+	}
+
+	c.period, c.jitter = period, jitter
+	return nil
+}
+
+// GossipPeriod returns the (jittered) time the next round should start after
+// the start of the last one.
+func (c *Core) GossipPeriod() time.Duration {
+	return time.Duration(float64(c.period) * (1 + c.jitter*(2*c.rnd.Float64()-1)))
+}
+
+// Refute is called when a peer tells us it suspects us of being dead at the
+// provided incarnation. We refute it by bumping our incarnation by one, which
+// is then gossiped with our node info. The incarnation is never raised to a
+// value picked by the peer so it can't be used to exhaust it.
+func (c *Core) Refute(inc uint64) {
+	for {
+		curr := atomic.LoadUint64(&c.inc)
+		if inc < curr || curr == math.MaxUint64 {
+			return //already refuted, or can't be
+		}
+
+		if atomic.CompareAndSwapUint64(&c.inc, curr, curr+1) {
+			return
+		}
+	}
+}
+
+// ProbeNode probes a node on behalf of another peer
+func (c *Core) ProbeNode(ctx context.Context, n Node) (ok bool) {
+	return c.tr.Probe(ctx, n) == nil
+}
+
 // ValidateSample validates if all samples are still responding
 func (c *Core) ValidateSample(to time.Duration) {
 	c.sampler.Validate(c.rnd, c.params.VN(), to)
 }
 
-// UpdateView runs the algorithm to update the view
+// ReseedSample rotates the seed of a random fraction (rate) of the sample's
+// slots and refills them from the current view. If the rate doesn't result in
+// a whole nr of slots the remainder is used as a probability to reseed one
+// more slot.
+func (c *Core) ReseedSample(rate float64) {
+	fn := rate * float64(c.params.L2())
+	n := int(fn)
+	if c.rnd.Float64() < fn-float64(n) {
+		n++
+	}
+
+	c.sampler.Reseed(c.rnd, n, c.view.Load().(View))
+}
+
+// UpdateView runs the algorithm to update the view. If the parameters are
+// adaptive they are resized to the latest estimate of the network size.
 func (c *Core) UpdateView(to time.Duration) {
-	c.view.Store(
-		Brahms(c.self, c.rnd, c.params, to, c.sampler, c.tr, c.pushes, c.view.Load().(View)),
-	)
+	self := c.Self()
+	old := c.view.Load().(View)
+	v := Brahms(&self, c.rnd, c.params, c.policy, to, c.sampler, c.tr, c.pushes.Load().(chan Node), old)
+	c.view.Store(v)
+	c.viewChanged(old, v)
+
+	ap, ok := c.params.(AdaptiveP)
+	if !ok {
+		return
+	}
+
+	ap.Adapt(c.sampler.EstimateSize())
+	c.sampler.Resize(c.rnd, ap.L2(), v)
+	c.resizePushes(ap.L1α() + 10)
+}
+
+// resizePushes grows the push buffer to hold at least n pushes. Pushes that
+// are still in the old buffer are moved to the new one, pushes that are
+// received concurrently wait until the new buffer is in place.
+func (c *Core) resizePushes(n int) {
+	c.pushmu.Lock()
+	defer c.pushmu.Unlock()
+
+	old := c.pushes.Load().(chan Node)
+	if cap(old) >= n {
+		return
+	}
+
+	pushes := make(chan Node, n)
+	for len(old) > 0 {
+		pushes <- <-old
+	}
+
+	c.pushes.Store(pushes)
+}
+
+// viewChanged publishes an event if the view changed
+func (c *Core) viewChanged(old, v View) {
+	added, removed := v.Diff(old), old.Diff(v)
+	if len(added) < 1 && len(removed) < 1 {
+		return
+	}
+
+	c.events.publish(Event{Type: ViewChanged, Time: c.sampler.clock(), Added: added, Removed: removed})
+}
+
+// EstimateSize returns an estimate of the nr of nodes in the network
+func (c *Core) EstimateSize() float64 {
+	return c.sampler.EstimateSize()
 }
 
 // ReadView returns a copy of our current local view
@@ -78,19 +282,84 @@ func (c *Core) IsActive() (ok bool) {
 	return atomic.LoadInt32(&(c.active)) == 1
 }
 
-// ReceiveNode gets called when another peer pushes its info
-func (c *Core) ReceiveNode(other Node) {
+// ReceiveNode gets called when another peer pushes its info. The push is only
+// considered if the stamp proves enough work was done to push it to us, the
+// stamp wasn't used before and its metadata is within bounds, it returns false
+// otherwise.
+func (c *Core) ReceiveNode(other Node, st Stamp) (ok bool) {
+	id, now, d := other.Hash(), Epoch(c.sampler.clock()), c.params.D()
+	if other.Meta.Validate() != nil || !st.Valid(id, c.self.Hash(), now, d) || (d > 0 && !c.stamps.use(id, st, now)) {
+		atomic.AddUint64(&c.stats.PushesRejected, 1)
+		return false
+	}
+
+	atomic.AddUint64(&c.stats.PushesReceived, 1)
+	c.pushmu.RLock()
+	defer c.pushmu.RUnlock()
 	select {
-	case c.pushes <- other:
+	case c.pushes.Load().(chan Node) <- other:
 	default: //push buffer is full, discard
+		atomic.AddUint64(&c.stats.PushesDropped, 1)
 	}
+
+	return true
 }
 
-// Deactivate clears the view and sets the core to non-active state
+// Stats returns a copy of the core's push counters
+func (c *Core) Stats() Stats {
+	return Stats{
+		PushesReceived: atomic.LoadUint64(&c.stats.PushesReceived),
+		PushesRejected: atomic.LoadUint64(&c.stats.PushesRejected),
+		PushesDropped:  atomic.LoadUint64(&c.stats.PushesDropped),
+	}
+}
+
+// Deactivate clears the view and sets the core to non-active state, all event
+// subscriptions are closed.
 func (c *Core) Deactivate() {
 	atomic.StoreInt32(&(c.active), 0)
 	c.view.Store(View{})
 	c.sampler.Clear()
+	c.events.close()
+}
+
+// Snapshot captures the sampler and view of the core
+func (c *Core) Snapshot() (snap *Snapshot) {
+	snap = &Snapshot{Version: SnapshotVersion}
+	c.sampler.Snapshot(snap)
+	snap.View = c.view.Load().(View).Sorted()
+	return
+}
+
+// Restore the sampler and view from a snapshot. The snapshot's view is merged
+// with the current view and the sampler is resized to the current parameters
+// if they differ from the snapshot.
+func (c *Core) Restore(snap *Snapshot) (err error) {
+	if snap.Version != SnapshotVersion {
+		return ErrSnapshotVersion
+	}
+
+	err = c.sampler.Restore(snap)
+	if err != nil {
+		return err
+	}
+
+	old := c.view.Load().(View)
+	v := old.Copy()
+	for _, n := range snap.View {
+		n := n
+		v.Concat(NewView(&n))
+	}
+
+	c.view.Store(v)
+	c.viewChanged(old, v)
+
+	// adaptive parameters will resize the sampler on the next update instead
+	if _, ok := c.params.(AdaptiveP); !ok {
+		c.sampler.Resize(c.rnd, c.params.L2(), v)
+	}
+
+	return
 }
 
 // Sample returns a copy of the peer samples this core has
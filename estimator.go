@@ -0,0 +1,106 @@
+package brahms
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// KMV estimates the nr of distinct ids in a stream by only remembering the k
+// minimum hash values it has seen, as described here:
+// https://research.neustar.biz/2012/07/09/sketch-of-the-day-k-minimum-values/
+// ids are hashed with a random salt such that an adversary can't pick ids
+// that skew the estimate. It is not safe for concurrent use.
+type KMV struct {
+	k    int
+	salt [32]byte
+	mins [][32]byte
+}
+
+// NewKMV initializes an estimator that keeps k minimum values
+func NewKMV(rnd *rand.Rand, k int) (e *KMV) {
+	e = &KMV{k: k, mins: make([][32]byte, 0, k)}
+	rnd.Read(e.salt[:])
+	return
+}
+
+// Add an id to the estimator
+func (e *KMV) Add(id NID) {
+	var buf [64]byte
+	copy(buf[:32], id[:])
+	copy(buf[32:], e.salt[:])
+	h := sha256.Sum256(buf[:])
+	i := sort.Search(len(e.mins), func(i int) bool {
+		return bytes.Compare(e.mins[i][:], h[:]) >= 0
+	})
+
+	if i < len(e.mins) && e.mins[i] == h {
+		return //already seen
+	}
+
+	if i >= e.k {
+		return //not a minimum value
+	}
+
+	if len(e.mins) < e.k {
+		e.mins = append(e.mins, [32]byte{})
+	}
+
+	copy(e.mins[i+1:], e.mins[i:])
+	e.mins[i] = h
+}
+
+// Estimate the nr of distinct ids that were added
+func (e *KMV) Estimate() float64 {
+	if len(e.mins) < e.k {
+		return float64(len(e.mins)) //we've seen every id, the count is exact
+	}
+
+	// the k-th minimum hash as a fraction of the hash space
+	max := e.mins[len(e.mins)-1]
+	frac := float64(binary.BigEndian.Uint64(max[:])) / math.Pow(2, 64)
+	return float64(e.k-1) / frac
+}
+
+// next returns an empty estimator that keeps as many minimum values, with a
+// salt that is derived from ours.
+func (e *KMV) next() *KMV {
+	return &KMV{k: e.k, salt: sha256.Sum256(e.salt[:]), mins: make([][32]byte, 0, e.k)}
+}
+
+// WindowKMV estimates the nr of distinct ids that were added recently. It keeps
+// a KMV sketch of the current and the previous window and forgets the previous
+// one when it is rotated. Such that ids that are no longer added stop counting
+// after two windows, while the estimate doesn't drop when a window starts. It
+// is not safe for concurrent use.
+type WindowKMV struct {
+	curr *KMV
+	prev *KMV
+}
+
+// NewWindowKMV initializes an estimator with sketches that keep k minimum values
+func NewWindowKMV(rnd *rand.Rand, k int) *WindowKMV {
+	return &WindowKMV{curr: NewKMV(rnd, k)}
+}
+
+// Add an id to the current window
+func (w *WindowKMV) Add(id NID) { w.curr.Add(id) }
+
+// Rotate starts a new window, ids that were only added before the current
+// window are forgotten.
+func (w *WindowKMV) Rotate() {
+	w.prev, w.curr = w.curr, w.curr.next()
+}
+
+// Estimate the nr of distinct ids that were added in the current or previous
+// window, whichever saw the most.
+func (w *WindowKMV) Estimate() float64 {
+	if w.prev == nil {
+		return w.curr.Estimate()
+	}
+
+	return math.Max(w.curr.Estimate(), w.prev.Estimate())
+}
@@ -0,0 +1,62 @@
+package brahms_test
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/advanderveer/brahms"
+	"github.com/advanderveer/go-test"
+)
+
+func TestKMVEstimate(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	e := brahms.NewKMV(r, 256)
+	test.Equals(t, 0.0, e.Estimate())
+
+	// below k the estimate is exact, and duplicates are not counted
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 100; j++ {
+			e.Add(brahms.N("127.0.0.1", uint16(j)).Hash())
+		}
+	}
+
+	test.Equals(t, 100.0, e.Estimate())
+
+	for _, n := range []int{1000, 10000, 50000} {
+		e := brahms.NewKMV(r, 256)
+		for i := 0; i < n; i++ {
+			var id brahms.NID
+			r.Read(id[:])
+			e.Add(id)
+		}
+
+		rerr := math.Abs(e.Estimate()-float64(n)) / float64(n)
+		test.Assert(t, rerr < 0.15, fmt.Sprintf("estimate of %d should be accurate, got: %f", n, e.Estimate()))
+	}
+}
+
+func TestWindowKMVEstimate(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	e := brahms.NewWindowKMV(r, 256)
+	for j := 0; j < 100; j++ {
+		e.Add(brahms.N("127.0.0.1", uint16(j)).Hash())
+	}
+
+	// the previous window still counts while the current one fills up
+	e.Rotate()
+	for j := 0; j < 10; j++ {
+		e.Add(brahms.N("127.0.0.1", uint16(j)).Hash())
+	}
+
+	test.Equals(t, 100.0, e.Estimate())
+
+	// ids that were not added in the last two windows are forgotten
+	e.Rotate()
+	for j := 0; j < 10; j++ {
+		e.Add(brahms.N("127.0.0.1", uint16(j)).Hash())
+	}
+
+	test.Equals(t, 10.0, e.Estimate())
+}
@@ -0,0 +1,178 @@
+package brahms
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType describes what kind of membership change an event reports
+type EventType int
+
+const (
+	// ViewChanged is emitted when an update changed the nodes in the view
+	ViewChanged EventType = iota
+
+	// SampleReplaced is emitted when a sampler slot now holds another node
+	SampleReplaced
+
+	// NodeInvalidated is emitted when a sample is invalidated because it no
+	// longer responded to probes
+	NodeInvalidated
+
+	// InvalidationExpired is emitted when an invalidated node is forgotten and
+	// may be considered again
+	InvalidationExpired
+
+	// PushFloodRejected is emitted when the view was not updated because more
+	// nodes were pushed to us then the algorithm accepts (len(push) > L1α)
+	PushFloodRejected
+
+	// PullRejected is emitted when (part of) a pull response was rejected,
+	// because it held more nodes then an honest peer would return or nodes
+	// that are malformed.
+	PullRejected
+)
+
+func (et EventType) String() string {
+	switch et {
+	case ViewChanged:
+		return "view_changed"
+	case SampleReplaced:
+		return "sample_replaced"
+	case NodeInvalidated:
+		return "node_invalidated"
+	case InvalidationExpired:
+		return "invalidation_expired"
+	case PushFloodRejected:
+		return "push_flood_rejected"
+	case PullRejected:
+		return "pull_rejected"
+	default:
+		return "unknown"
+	}
+}
+
+// Event reports a change in membership, which fields are set depends on the
+// type of event.
+type Event struct {
+	Type EventType
+	Time time.Time
+
+	// ID of the node the event is about, for invalidations and slot
+	// replacements.
+	ID NID
+
+	// Node is the node that was invalidated or took a sampler slot. For slot
+	// replacements Old is the node it replaced (if any) and Slot its index.
+	// For rejected pulls it is the peer that was pulled from.
+	Node Node
+	Old  Node
+	Slot int
+
+	// Added and Removed describe how the view changed
+	Added   View
+	Removed View
+
+	// Pushes is the nr of pushes that was rejected as a flood
+	Pushes int
+
+	// Rejected is the nr of nodes of a pull response that were rejected
+	Rejected int
+}
+
+// Subscription receives events from a core. Events are buffered but never
+// block the protocol: if the buffer is full the event is dropped and counted,
+// a consumer that fell behind can then resync by reading the current view and
+// sample. The channel is closed when the core is deactivated or the
+// subscription is closed.
+type Subscription struct {
+	C       <-chan Event
+	c       chan Event
+	dropped uint64
+	feed    *feed
+}
+
+// Dropped returns the nr of events that were dropped because the consumer
+// didn't keep up.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close the subscription, no more events will be sent on its channel
+func (s *Subscription) Close() {
+	s.feed.unsubscribe(s)
+}
+
+// feed distributes events to subscriptions, it is safe to publish on a nil feed
+type feed struct {
+	subs   map[*Subscription]struct{}
+	closed bool
+	mu     sync.Mutex
+}
+
+func newFeed() *feed {
+	return &feed{subs: make(map[*Subscription]struct{})}
+}
+
+func (f *feed) subscribe(n int) (s *Subscription) {
+	c := make(chan Event, n)
+	s = &Subscription{C: c, c: c, feed: f}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		close(c)
+		return
+	}
+
+	f.subs[s] = struct{}{}
+	return
+}
+
+func (f *feed) unsubscribe(s *Subscription) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.subs[s]; !ok {
+		return //already closed
+	}
+
+	delete(f.subs, s)
+	close(s.c)
+}
+
+func (f *feed) publish(e Event) {
+	if f == nil {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.subs) < 1 {
+		return
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	for s := range f.subs {
+		select {
+		case s.c <- e:
+		default: //slow consumer, drop it
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+// close all subscriptions and stop accepting new ones
+func (f *feed) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for s := range f.subs {
+		close(s.c)
+	}
+
+	f.subs = make(map[*Subscription]struct{})
+	f.closed = true
+}
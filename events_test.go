@@ -0,0 +1,211 @@
+package brahms_test
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/advanderveer/brahms"
+	"github.com/advanderveer/brahms/transport"
+	"github.com/advanderveer/go-test"
+)
+
+// drain all events that are currently buffered
+func drain(sub *brahms.Subscription) (evs map[brahms.EventType][]brahms.Event) {
+	evs = map[brahms.EventType][]brahms.Event{}
+	for {
+		select {
+		case ev, ok := <-sub.C:
+			if !ok {
+				return
+			}
+
+			evs[ev.Type] = append(evs[ev.Type], ev)
+		default:
+			return
+		}
+	}
+}
+
+func TestCoreEvents(t *testing.T) {
+	n1 := brahms.N("127.0.0.1", 1)
+	n2 := brahms.N("127.0.0.1", 2)
+	n3 := brahms.N("127.0.0.1", 3)
+
+	rnd := rand.New(rand.NewSource(1))
+	prm, _ := brahms.NewParams(0.45, 0.45, 0.1, 10, 10, 10, 0)
+	tr := transport.NewMemNetTransport()
+	c1 := brahms.NewCore(rnd, n1, brahms.NewView(n2), prm, brahms.AlwaysRefresh, tr, time.Millisecond*20)
+	tr.AddCore(c1)
+	c2 := brahms.NewCore(rnd, n2, brahms.NewView(n3), prm, brahms.AlwaysRefresh, tr, time.Millisecond*20)
+	tr.AddCore(c2)
+	c3 := brahms.NewCore(rnd, n3, brahms.NewView(n1), prm, brahms.AlwaysRefresh, tr, time.Millisecond*20)
+	tr.AddCore(c3)
+
+	sub := c1.Subscribe(100)
+
+	// c1 learns about n3 through c2
+	c2.UpdateView(time.Millisecond)
+	c1.UpdateView(time.Millisecond)
+	evs := drain(sub)
+	test.Equals(t, 1, len(evs[brahms.ViewChanged]))
+	test.Equals(t, brahms.NewView(n3), evs[brahms.ViewChanged][0].Added)
+	test.Equals(t, false, evs[brahms.ViewChanged][0].Time.IsZero())
+	test.Assert(t, len(evs[brahms.SampleReplaced]) > 0, "n3 should have replaced sample slots")
+	for _, ev := range evs[brahms.SampleReplaced] {
+		test.Equals(t, n3.Hash(), ev.ID)
+		test.Equals(t, n2.Hash(), ev.Old.Hash())
+	}
+
+	// an unchanged view should not emit
+	c1.ValidateSample(time.Millisecond)
+	test.Equals(t, 0, len(drain(sub)))
+
+	// when n3 leaves it is invalidated, and after the timeout forgotten
+	c3.Deactivate()
+	c1.ValidateSample(time.Millisecond)
+	evs = drain(sub)
+	test.Equals(t, 1, len(evs[brahms.NodeInvalidated]))
+	test.Equals(t, n3.Hash(), evs[brahms.NodeInvalidated][0].ID)
+
+	time.Sleep(time.Millisecond * 20)
+	c1.ValidateSample(time.Millisecond)
+	evs = drain(sub)
+	test.Equals(t, 1, len(evs[brahms.InvalidationExpired]))
+	test.Equals(t, n3.Hash(), evs[brahms.InvalidationExpired][0].ID)
+
+	// the subscription is closed on deactivation
+	c1.Deactivate()
+	_, ok := <-sub.C
+	test.Equals(t, false, ok)
+	_, ok = <-c1.Subscribe(1).C
+	test.Equals(t, false, ok)
+}
+
+func TestCorePushFloodEvent(t *testing.T) {
+	n1 := brahms.N("127.0.0.1", 1)
+	n2 := brahms.N("127.0.0.1", 2)
+
+	rnd := rand.New(rand.NewSource(1))
+	prm, _ := brahms.NewParams(0.45, 0.45, 0.1, 10, 10, 2, 0)
+	tr := transport.NewMemNetTransport()
+	c1 := brahms.NewCore(rnd, n1, brahms.NewView(n2), prm, brahms.AlwaysRefresh, tr, time.Second)
+	tr.AddCore(c1)
+	c2 := brahms.NewCore(rnd, n2, brahms.NewView(n1), prm, brahms.AlwaysRefresh, tr, time.Second)
+	tr.AddCore(c2)
+
+	sub := c1.Subscribe(100)
+	for i := 0; i < prm.L1α()+1; i++ {
+		test.Equals(t, true, c1.ReceiveNode(*brahms.N("127.0.0.1", uint16(100+i)), brahms.Stamp{}))
+	}
+
+	c1.UpdateView(time.Millisecond)
+	evs := drain(sub)
+	test.Equals(t, 1, len(evs[brahms.PushFloodRejected]))
+	test.Equals(t, prm.L1α()+1, evs[brahms.PushFloodRejected][0].Pushes)
+	test.Equals(t, 0, len(evs[brahms.ViewChanged]))
+	test.Equals(t, brahms.NewView(n2), c1.ReadView())
+
+	// pushes that don't fit the buffer are dropped
+	for i := 0; i < prm.L1α()+11; i++ {
+		c1.ReceiveNode(*brahms.N("127.0.0.1", uint16(200+i)), brahms.Stamp{})
+	}
+
+	test.Equals(t, uint64(1), c1.Stats().PushesDropped)
+}
+
+func TestCorePullRejectedEvent(t *testing.T) {
+	n1 := brahms.N("127.0.0.1", 1)
+	n2 := brahms.N("127.0.0.1", 2)
+	n3 := brahms.N("127.0.0.1", 3)
+
+	rnd := rand.New(rand.NewSource(1))
+	prm, _ := brahms.NewParams(0.45, 0.45, 0.1, 10, 10, 2, 0)
+	tr := transport.NewMockTransport()
+	c1 := brahms.NewCore(rnd, n1, brahms.NewView(n2), prm, brahms.AlwaysRefresh, tr, time.Second)
+	sub := c1.Subscribe(100)
+
+	// malformed nodes and ourselves are rejected, valid nodes are not
+	tr.SetPull(n2.Hash(), brahms.NewView(n1, n3, brahms.N("127.0.0.1", 0), &brahms.Node{Port: 4}))
+	c1.UpdateView(time.Second)
+	evs := drain(sub)
+	test.Equals(t, 1, len(evs[brahms.PullRejected]))
+	test.Equals(t, n2.Hash(), evs[brahms.PullRejected][0].ID)
+	test.Equals(t, 3, evs[brahms.PullRejected][0].Rejected)
+	_, ok := c1.ReadView()[n3.Hash()]
+	test.Equals(t, true, ok)
+
+	// a response larger then an honest peer's view is rejected as a whole
+	big := brahms.NewView()
+	for i := 0; i < brahms.MaxPullSize(prm)+1; i++ {
+		big.Concat(brahms.NewView(brahms.N("127.0.1.1", uint16(100+i))))
+	}
+
+	tr.SetPull(n3.Hash(), big)
+	tr.SetPull(n2.Hash(), big)
+	c1.UpdateView(time.Second)
+	evs = drain(sub)
+	test.Assert(t, len(evs[brahms.PullRejected]) > 0, "should have rejected the pull")
+	test.Equals(t, len(big), evs[brahms.PullRejected][0].Rejected)
+	test.Equals(t, 0, len(c1.Sample().Inter(big)))
+}
+
+// rejectingTransport imitates a transport that rejected nodes of every pull
+type rejectingTransport struct{ *transport.MockTransport }
+
+func (t rejectingTransport) Pull(ctx context.Context, from brahms.Node) (brahms.View, error) {
+	v, err := t.MockTransport.Pull(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+
+	return v, brahms.PullRejectedErr{Rejected: 2}
+}
+
+func TestTransportPullRejectedEvent(t *testing.T) {
+	n1 := brahms.N("127.0.0.1", 1)
+	n2 := brahms.N("127.0.0.1", 2)
+	n3 := brahms.N("127.0.0.1", 3)
+
+	rnd := rand.New(rand.NewSource(1))
+	prm, _ := brahms.NewParams(0.45, 0.45, 0.1, 10, 10, 2, 0)
+	tr := rejectingTransport{transport.NewMockTransport()}
+	c1 := brahms.NewCore(rnd, n1, brahms.NewView(n2), prm, brahms.AlwaysRefresh, tr, time.Second)
+	sub := c1.Subscribe(100)
+
+	// nodes rejected by the transport are reported, the rest is still pulled
+	tr.SetPull(n2.Hash(), brahms.NewView(n1, n3))
+	c1.UpdateView(time.Second)
+	evs := drain(sub)
+	test.Equals(t, 1, len(evs[brahms.PullRejected]))
+	test.Equals(t, 3, evs[brahms.PullRejected][0].Rejected)
+	_, ok := c1.ReadView()[n3.Hash()]
+	test.Equals(t, true, ok)
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	n1 := brahms.N("127.0.0.1", 1)
+	n2 := brahms.N("127.0.0.1", 2)
+
+	rnd := rand.New(rand.NewSource(1))
+	prm, _ := brahms.NewParams(0.45, 0.45, 0.1, 10, 10, 2, 0)
+	tr := transport.NewMemNetTransport()
+	c1 := brahms.NewCore(rnd, n1, brahms.NewView(), prm, brahms.AlwaysRefresh, tr, time.Second)
+	tr.AddCore(c1)
+	c2 := brahms.NewCore(rnd, n2, brahms.NewView(n1), prm, brahms.AlwaysRefresh, tr, time.Second)
+	tr.AddCore(c2)
+
+	slow, closed := c1.Subscribe(1), c1.Subscribe(1)
+	closed.Close()
+	closed.Close() //should be idempotent
+	_, ok := <-closed.C
+	test.Equals(t, false, ok)
+
+	// the update should not block on the full buffer, but count the drops
+	c2.UpdateView(time.Millisecond)
+	c1.UpdateView(time.Millisecond)
+	test.Equals(t, 1, len(drain(slow)))
+	test.Assert(t, slow.Dropped() > 0, "should have dropped events")
+	test.Equals(t, uint64(0), closed.Dropped())
+}
@@ -0,0 +1,198 @@
+// Package metrics implements counters and histograms that are exposed in the
+// Prometheus text exposition format, without depending on a client library.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultLatencyBuckets are upper bounds in seconds that fit request latencies
+var DefaultLatencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+// metric is anything that can write itself in the exposition format
+type metric interface {
+	name() string
+	write(w io.Writer) error
+}
+
+// Counter is a value that only goes up
+type Counter struct {
+	n, help string
+	v       uint64
+}
+
+// Inc increments the counter by one
+func (c *Counter) Inc() { c.Add(1) }
+
+// Add n to the counter
+func (c *Counter) Add(n uint64) { atomic.AddUint64(&c.v, n) }
+
+// Value returns the current value of the counter
+func (c *Counter) Value() uint64 { return atomic.LoadUint64(&c.v) }
+
+func (c *Counter) name() string { return c.n }
+
+func (c *Counter) write(w io.Writer) (err error) {
+	_, err = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.n, c.help, c.n, c.n, c.Value())
+	return
+}
+
+// funcMetric is a counter or gauge that reads its value when it is exposed
+type funcMetric struct {
+	n, help, typ string
+	f            func() float64
+}
+
+func (f *funcMetric) name() string { return f.n }
+
+func (f *funcMetric) write(w io.Writer) (err error) {
+	_, err = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", f.n, f.help, f.n, f.typ, f.n, format(f.f()))
+	return
+}
+
+// Histogram counts observations in configurable buckets
+type Histogram struct {
+	n, help string
+	bounds  []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+	mu      sync.Mutex
+}
+
+// Observe a value
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.bounds {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+
+	h.count++
+	h.sum += v
+}
+
+// Count returns the nr of observations
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *Histogram) name() string { return h.n }
+
+func (h *Histogram) write(w io.Writer) (err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	_, err = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.n, h.help, h.n)
+	if err != nil {
+		return err
+	}
+
+	for i, b := range h.bounds {
+		_, err = fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.n, format(b), h.counts[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %s\n%s_count %d\n",
+		h.n, h.count, h.n, format(h.sum), h.n, h.count)
+	return
+}
+
+// Registry holds metrics and exposes them over http
+type Registry struct {
+	metrics map[string]metric
+	mu      sync.RWMutex
+}
+
+// NewRegistry initializes an empty registry
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[m.name()]; ok {
+		panic("metrics: duplicate metric name: " + m.name())
+	}
+
+	r.metrics[m.name()] = m
+}
+
+// Counter registers a new counter
+func (r *Registry) Counter(name, help string) (c *Counter) {
+	c = &Counter{n: name, help: help}
+	r.register(c)
+	return
+}
+
+// CounterFunc registers a counter whose value is read from f when exposed
+func (r *Registry) CounterFunc(name, help string, f func() float64) {
+	r.register(&funcMetric{n: name, help: help, typ: "counter", f: f})
+}
+
+// GaugeFunc registers a gauge whose value is read from f when exposed
+func (r *Registry) GaugeFunc(name, help string, f func() float64) {
+	r.register(&funcMetric{n: name, help: help, typ: "gauge", f: f})
+}
+
+// Histogram registers a histogram with the provided (sorted) bucket bounds
+func (r *Registry) Histogram(name, help string, buckets []float64) (h *Histogram) {
+	h = &Histogram{n: name, help: help, bounds: buckets, counts: make([]uint64, len(buckets))}
+	r.register(h)
+	return
+}
+
+// Write all metrics, sorted by name, in the text exposition format
+func (r *Registry) Write(w io.Writer) (err error) {
+	r.mu.RLock()
+	ms := make([]metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		ms = append(ms, m)
+	}
+
+	r.mu.RUnlock()
+
+	sort.Slice(ms, func(i, j int) bool { return ms[i].name() < ms[j].name() })
+	for _, m := range ms {
+		err = m.write(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	return
+}
+
+// ServeHTTP exposes the metrics
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.Write(w)
+}
+
+// format a float the way the exposition format expects it
+func format(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,56 @@
+package metrics_test
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/advanderveer/brahms/metrics"
+	"github.com/advanderveer/go-test"
+)
+
+func TestRegistry(t *testing.T) {
+	r := metrics.NewRegistry()
+	c := r.Counter("foo_total", "Nr of foos.")
+	h := r.Histogram("bar_seconds", "Duration of bars.", []float64{0.1, 1})
+	r.GaugeFunc("baz", "Current baz.", func() float64 { return math.Inf(1) })
+	r.CounterFunc("qux_total", "Nr of quxs.", func() float64 { return 2.5 })
+
+	c.Inc()
+	c.Add(2)
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+	test.Equals(t, uint64(3), c.Value())
+	test.Equals(t, uint64(3), h.Count())
+
+	buf := bytes.NewBuffer(nil)
+	test.Ok(t, r.Write(buf))
+	test.Equals(t, `# HELP bar_seconds Duration of bars.
+# TYPE bar_seconds histogram
+bar_seconds_bucket{le="0.1"} 1
+bar_seconds_bucket{le="1"} 2
+bar_seconds_bucket{le="+Inf"} 3
+bar_seconds_sum 5.55
+bar_seconds_count 3
+# HELP baz Current baz.
+# TYPE baz gauge
+baz +Inf
+# HELP foo_total Nr of foos.
+# TYPE foo_total counter
+foo_total 3
+# HELP qux_total Nr of quxs.
+# TYPE qux_total counter
+qux_total 2.5
+`, buf.String())
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	test.Equals(t, "text/plain; version=0.0.4", rec.Header().Get("Content-Type"))
+	test.Equals(t, buf.String(), rec.Body.String())
+
+	// names must be unique
+	defer func() { test.Assert(t, recover() != nil, "should panic") }()
+	r.Counter("foo_total", "Nr of foos.")
+}
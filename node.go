@@ -1,13 +1,32 @@
 package brahms
 
 import (
+	"crypto/ed25519"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"net"
 	"strconv"
 )
 
+var (
+	// ErrInvalidNID is returned when an encoded node id couldn't be decoded
+	ErrInvalidNID = errors.New("invalid node id")
+
+	// ErrMetaTooLarge is returned when node metadata exceeds its bounds
+	ErrMetaTooLarge = errors.New("node metadata too large")
+)
+
+const (
+	// MaxMetaEntries is the nr of key/value pairs node metadata can hold
+	MaxMetaEntries = 16
+
+	// MaxMetaSize is the nr of bytes the keys and values of node metadata can
+	// add up to
+	MaxMetaSize = 512
+)
+
 // NID is a node id
 type NID [32]byte
 
@@ -18,6 +37,22 @@ func (id NID) Bytes() []byte {
 	return id[:]
 }
 
+// MarshalText encodes the full id as hex, such that it can be used as a key in
+// encoded maps
+func (id NID) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(id[:])), nil
+}
+
+// UnmarshalText decodes an id from its full hex encoding
+func (id *NID) UnmarshalText(text []byte) error {
+	if hex.DecodedLen(len(text)) != len(id) {
+		return ErrInvalidNID
+	}
+
+	_, err := hex.Decode(id[:], text)
+	return err
+}
+
 // IsNil returns whether the is its zero value
 func (id NID) IsNil() bool {
 	return id == NID{}
@@ -28,23 +63,111 @@ func N(ip string, port uint16) (n *Node) {
 	return &Node{IP: net.ParseIP(ip), Port: port}
 }
 
-// Node describes how to reach another peer in the network
+// NK describes a node by its ip info and the public key it identifies with
+func NK(ip string, port uint16, key ed25519.PublicKey) (n *Node) {
+	n = N(ip, port)
+	n.Key = key
+	return
+}
+
+// Meta holds key/value metadata a node advertises about itself, e.g. its role
+// or zone. It is bounded such that it can be gossiped with every node.
+type Meta map[string]string
+
+// Validate returns ErrMetaTooLarge if the metadata exceeds its bounds
+func (m Meta) Validate() error {
+	if len(m) > MaxMetaEntries {
+		return ErrMetaTooLarge
+	}
+
+	var size int
+	for k, v := range m {
+		size += len(k) + len(v)
+	}
+
+	if size > MaxMetaSize {
+		return ErrMetaTooLarge
+	}
+
+	return nil
+}
+
+// Copy returns a copy of the metadata
+func (m Meta) Copy() (c Meta) {
+	if m == nil {
+		return nil
+	}
+
+	c = make(Meta, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+
+	return
+}
+
+// Node describes how to reach another peer in the network and, optionally, the
+// public key it can prove its identity with. The incarnation is not part of
+// its id, a node bumps it to refute that it is suspected of being dead. The
+// metadata is not part of its id either, a node bumps its version when it
+// changes such that newer metadata replaces older metadata as it is gossiped.
 type Node struct {
 	IP   net.IP
 	Port uint16
+	Key  ed25519.PublicKey
+	Inc  uint64
+	Meta Meta
+	Ver  uint64
+}
+
+// Newer returns whether the node info is newer then the other info of the
+// same node: it has a higher incarnation or, for the same incarnation, a
+// higher metadata version.
+func (n Node) Newer(o Node) bool {
+	if n.Inc != o.Inc {
+		return n.Inc > o.Inc
+	}
+
+	return n.Ver > o.Ver
 }
 
-// Hash a node description into an ip
+// Hash a node description into an id. The id is derived from the address and
+// the public key (if any) together, such that no-one can claim an id without
+// holding the private key and peers can't rebind a known key to an address of
+// their choosing.
 func (n *Node) Hash() (id NID) {
-	pb := make([]byte, 2)
-	binary.BigEndian.PutUint16(pb, n.Port)
-	return NID(sha256.Sum256(append(n.IP, pb...)))
+	data := make([]byte, len(n.IP)+2, len(n.IP)+2+len(n.Key))
+	copy(data, n.IP)
+	binary.BigEndian.PutUint16(data[len(n.IP):], n.Port)
+	data = append(data, n.Key...)
+	return NID(sha256.Sum256(data))
 }
 
 func (n *Node) String() string {
 	return n.IP.String() + ":" + strconv.Itoa(int(n.Port))
 }
 
+// Subnet returns the IPv4 /24 or IPv6 /48 the node's address is part of.
+// Addresses in the same subnet are cheap to come by for a single party, such
+// that it is used to limit how many of them are sampled.
+func (n Node) Subnet() string {
+	if ip4 := n.IP.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+
+	if ip6 := n.IP.To16(); ip6 != nil {
+		return (&net.IPNet{IP: ip6.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+	}
+
+	return ""
+}
+
+// IsValid returns whether the node has an address it could be reached at and
+// its metadata is within bounds
+func (n Node) IsValid() bool {
+	return n.IP.To16() != nil && !n.IP.IsUnspecified() && n.Port != 0 && n.Meta.Validate() == nil
+}
+
 // IsZero returns whether the node is a zero value
 func (n Node) IsZero() bool {
 	return (n.IP == nil && n.Port == 0)
@@ -1,7 +1,10 @@
 package brahms
 
 import (
+	"crypto/ed25519"
 	"net"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/advanderveer/go-test"
@@ -35,6 +38,27 @@ func TestNodeHashing(t *testing.T) {
 	test.Equals(t, false, n.IsZero())
 }
 
+func TestNodeValid(t *testing.T) {
+	test.Equals(t, true, N("127.0.0.1", 1).IsValid())
+	test.Equals(t, true, N("::1", 1).IsValid())
+	test.Equals(t, false, N("127.0.0.1", 0).IsValid())
+	test.Equals(t, false, N("0.0.0.0", 1).IsValid())
+	test.Equals(t, false, Node{Port: 1}.IsValid())
+	test.Equals(t, false, Node{IP: net.IP{1, 2, 3}, Port: 1}.IsValid())
+}
+
+func TestNodeKeyHashing(t *testing.T) {
+	n1 := N("127.0.0.1", 1)
+	n2 := NK("127.0.0.1", 1, ed25519.PublicKey{0x01})
+	n3 := NK("127.0.0.1", 1, ed25519.PublicKey{0x02})
+
+	test.Equals(t, ed25519.PublicKey{0x01}, n2.Key)
+	test.Assert(t, n1.Hash() != n2.Hash(), "key should change the id")
+	test.Assert(t, n2.Hash() != n3.Hash(), "different keys should give different ids")
+	test.Equals(t, n2.Hash(), NK("127.0.0.1", 1, ed25519.PublicKey{0x01}).Hash())
+	test.Assert(t, n2.Hash() != NK("10.0.0.1", 2, ed25519.PublicKey{0x01}).Hash(), "the key is bound to the address")
+}
+
 var rid NID
 
 func BenchmarkNodeHashing(b *testing.B) {
@@ -47,3 +71,42 @@ func BenchmarkNodeHashing(b *testing.B) {
 
 	rid = id
 }
+
+func TestNodeSubnet(t *testing.T) {
+	test.Equals(t, "10.1.2.0/24", N("10.1.2.3", 1).Subnet())
+	test.Equals(t, N("10.1.2.3", 1).Subnet(), N("10.1.2.200", 2).Subnet())
+	test.Equals(t, "2001:db8:1::/48", N("2001:db8:1:2::1", 1).Subnet())
+	test.Equals(t, "", Node{}.Subnet())
+}
+
+func TestNodeMeta(t *testing.T) {
+	n1 := N("127.0.0.1", 1)
+	n2 := N("127.0.0.1", 1)
+	n2.Meta, n2.Ver = Meta{"role": "db"}, 1
+	test.Equals(t, n1.Hash(), n2.Hash())
+	test.Equals(t, true, n2.Newer(*n1))
+	test.Equals(t, false, n1.Newer(*n2))
+	test.Equals(t, false, n2.Newer(*n2))
+
+	n1.Inc = 1 // a higher incarnation is newer, regardless of the version
+	test.Equals(t, true, n1.Newer(*n2))
+
+	test.Ok(t, Meta(nil).Validate())
+	test.Ok(t, Meta{"zone": "eu-west"}.Validate())
+	test.Equals(t, ErrMetaTooLarge, Meta{"k": strings.Repeat("v", MaxMetaSize)}.Validate())
+
+	many := Meta{}
+	for i := 0; i <= MaxMetaEntries; i++ {
+		many[strconv.Itoa(i)] = ""
+	}
+
+	test.Equals(t, ErrMetaTooLarge, many.Validate())
+	n2.Meta = many
+	test.Equals(t, false, n2.IsValid())
+
+	m := Meta{"role": "db"}
+	c := m.Copy()
+	c["role"] = "web"
+	test.Equals(t, "db", m["role"])
+	test.Equals(t, Meta(nil), Meta(nil).Copy())
+}
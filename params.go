@@ -2,7 +2,9 @@ package brahms
 
 import (
 	"errors"
+	"math"
 	"strconv"
+	"sync"
 )
 
 var (
@@ -17,6 +19,9 @@ var (
 
 	//ErrL2AtLeast is returned when l2 is too low
 	ErrL2AtLeast = errors.New("l2 must be at least 1" + strconv.Itoa(minL2))
+
+	//ErrJitterRange is returned when the gossip jitter is not in [0, 1)
+	ErrJitterRange = errors.New("jitter must be at least 0 and less than 1")
 )
 
 // P offers parameter values to the algorithm
@@ -27,15 +32,24 @@ type P interface {
 	L1β() int
 	L1γ() int
 	VN() int
+	D() int
+}
+
+// MaxPullSize returns the nr of nodes a pull response can hold at most. An
+// honest peer returns its view of l1 nodes, the margin allows for peers that
+// (temporarily) use other parameters, e.g. adaptive ones.
+func MaxPullSize(p P) int {
+	return 2 * (p.L1α() + p.L1β() + p.L1γ())
 }
 
-// NewParams checks initializes the protocol parameters
-func NewParams(α, β, γ float64, l1, l2, vn int) (p P, err error) {
+// NewParams checks initializes the protocol parameters. The d parameter
+// configures the nr of leading zero bits a push's proof-of-work must have.
+func NewParams(α, β, γ float64, l1, l2, vn, d int) (p P, err error) {
 	if α+β+γ != 1 {
 		return nil, ErrPartsDonAddToOne
 	}
 
-	params := &params{vn: vn}
+	params := &params{vn: vn, d: d}
 	if l1 < minL1 {
 		return nil, ErrL1AtLeast
 	}
@@ -59,6 +73,7 @@ type params struct {
 	bl1 int
 	cl1 int
 	vn  int
+	d   int
 }
 
 func (p *params) L2() int  { return p._l2 }
@@ -66,3 +81,62 @@ func (p *params) L1α() int { return p.al1 }
 func (p *params) L1β() int { return p.bl1 }
 func (p *params) L1γ() int { return p.cl1 }
 func (p *params) VN() int  { return p.vn }
+func (p *params) D() int   { return p.d }
+
+// AdaptiveP is implemented by parameters that change as the estimated size of
+// the network changes.
+type AdaptiveP interface {
+	P
+	Adapt(n float64)
+}
+
+// NewAdaptiveParams initializes parameters that size l1 and l2 to be m·∛n for
+// an estimated network size of n. They start out at the minimal sizes until
+// the first estimate comes in. Since they hold state they should not be shared
+// between cores.
+func NewAdaptiveParams(α, β, γ, m float64, vn, d int) (p AdaptiveP, err error) {
+	if α+β+γ != 1 {
+		return nil, ErrPartsDonAddToOne
+	}
+
+	ap := &adaptiveParams{α: α, β: β, γ: γ, m: m}
+	ap.params.vn, ap.params.d = vn, d
+	ap.Adapt(0)
+	return ap, nil
+}
+
+type adaptiveParams struct {
+	params
+	α, β, γ, m float64
+	mu         sync.RWMutex
+}
+
+// Adapt resizes the parameters to a new network size estimate
+func (p *adaptiveParams) Adapt(n float64) {
+	l := int(math.Round(p.m * math.Cbrt(n)))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	l1, l2 := l, l
+	if l1 < minL1 {
+		l1 = minL1
+	}
+
+	if l2 < minL2 {
+		l2 = minL2
+	}
+
+	// NOTE: we round up such that small networks still push, pull and sample
+	// from the history at least a single node each round
+	fl1 := float64(l1)
+	p.al1 = int(math.Ceil(fl1*p.α - 1e-9))
+	p.bl1 = int(math.Ceil(fl1*p.β - 1e-9))
+	p.cl1 = int(math.Ceil(fl1*p.γ - 1e-9))
+	p._l2 = l2
+}
+
+func (p *adaptiveParams) L2() int  { p.mu.RLock(); defer p.mu.RUnlock(); return p._l2 }
+func (p *adaptiveParams) L1α() int { p.mu.RLock(); defer p.mu.RUnlock(); return p.al1 }
+func (p *adaptiveParams) L1β() int { p.mu.RLock(); defer p.mu.RUnlock(); return p.bl1 }
+func (p *adaptiveParams) L1γ() int { p.mu.RLock(); defer p.mu.RUnlock(); return p.cl1 }
@@ -7,21 +7,47 @@ import (
 )
 
 func TestParams(t *testing.T) {
-	p1, err := NewParams(0.1, 0.7, 0.2, 100, 10, 5)
+	p1, err := NewParams(0.1, 0.7, 0.2, 100, 10, 5, 8)
 	test.Ok(t, err)
 	test.Equals(t, 10, p1.L1α())
 	test.Equals(t, 70, p1.L1β())
 	test.Equals(t, 20, p1.L1γ())
 	test.Equals(t, 10, p1.L2())
+	test.Equals(t, 8, p1.D())
 }
 
 func TestParamsFails(t *testing.T) {
-	_, err := NewParams(0.1, 0.6, 0.2, 100, 10, 5)
+	_, err := NewParams(0.1, 0.6, 0.2, 100, 10, 5, 0)
 	test.Equals(t, ErrPartsDonAddToOne, err)
 
-	_, err = NewParams(0.1, 0.7, 0.2, 1, 10, 5)
+	_, err = NewParams(0.1, 0.7, 0.2, 1, 10, 5, 0)
 	test.Equals(t, ErrL1AtLeast, err)
 
-	_, err = NewParams(0.1, 0.7, 0.2, 10, 0, 5)
+	_, err = NewParams(0.1, 0.7, 0.2, 10, 0, 5, 0)
 	test.Equals(t, ErrL2AtLeast, err)
 }
+
+func TestAdaptiveParams(t *testing.T) {
+	_, err := NewAdaptiveParams(0.1, 0.6, 0.2, 1, 2, 0)
+	test.Equals(t, ErrPartsDonAddToOne, err)
+
+	p, err := NewAdaptiveParams(0.45, 0.45, 0.1, 2, 2, 8)
+	test.Ok(t, err)
+	test.Equals(t, 2, p.VN())
+	test.Equals(t, 8, p.D())
+
+	// without estimate, starts out at the minimum but still pushes and pulls
+	test.Equals(t, 1, p.L1α())
+	test.Equals(t, 1, p.L1β())
+	test.Equals(t, 1, p.L1γ())
+	test.Equals(t, minL2, p.L2())
+
+	p.Adapt(1000)
+	test.Equals(t, 9, p.L1α())
+	test.Equals(t, 9, p.L1β())
+	test.Equals(t, 2, p.L1γ())
+	test.Equals(t, 20, p.L2())
+
+	p.Adapt(10)
+	test.Equals(t, 4, p.L2())
+}
@@ -0,0 +1,84 @@
+package brahms
+
+import "sync"
+
+// ViewPolicy decides whether the view is refreshed with the nodes that were
+// pushed and pulled in a round. It is only consulted if the nr of pushes
+// didn't exceed L1α, a flood of pushes never refreshes the view.
+type ViewPolicy interface {
+	Refresh(p P, push, pull View) bool
+}
+
+// ViewPolicyFunc allows a function to be used as a view policy
+type ViewPolicyFunc func(p P, push, pull View) bool
+
+// Refresh calls the function
+func (f ViewPolicyFunc) Refresh(p P, push, pull View) bool { return f(p, push, pull) }
+
+var (
+	// AlwaysRefresh refreshes the view every round, even if nothing was pushed
+	// or pulled. Non-responding peers are then reset from the view in small
+	// networks, but the view fluctuates more.
+	AlwaysRefresh ViewPolicy = ViewPolicyFunc(func(p P, push, pull View) bool { return true })
+
+	// RefreshOnPushOrPull only refreshes the view if anything was pushed or
+	// pulled this round.
+	RefreshOnPushOrPull ViewPolicy = ViewPolicyFunc(func(p P, push, pull View) bool {
+		return len(push) > 0 || len(pull) > 0
+	})
+
+	// RefreshOnPushAndPull only refreshes the view if nodes were both pushed
+	// and pulled this round, as described in the paper.
+	RefreshOnPushAndPull ViewPolicy = ViewPolicyFunc(func(p P, push, pull View) bool {
+		return len(push) > 0 && len(pull) > 0
+	})
+)
+
+// AnomalyPolicy holds back a refresh if the nr of pushes in a round is
+// anomalous compared to the recent rounds, other rounds are decided by the
+// policy it wraps. It keeps state and can only be used by a single core.
+type AnomalyPolicy struct {
+	vp     ViewPolicy
+	window int
+	factor float64
+	hist   []int
+	mu     sync.Mutex
+}
+
+// NewAnomalyPolicy wraps a policy such that rounds with more then factor times
+// the average nr of pushes of the last window rounds don't refresh the view.
+func NewAnomalyPolicy(vp ViewPolicy, window int, factor float64) *AnomalyPolicy {
+	return &AnomalyPolicy{vp: vp, window: window, factor: factor}
+}
+
+// Refresh implements the view policy
+func (ap *AnomalyPolicy) Refresh(p P, push, pull View) bool {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+
+	var sum int
+	for _, n := range ap.hist {
+		sum += n
+	}
+
+	// the average is at least one push, such that a few pushes after a quiet
+	// period are not considered anomalous
+	avg := 1.0
+	if len(ap.hist) > 0 && float64(sum)/float64(len(ap.hist)) > avg {
+		avg = float64(sum) / float64(len(ap.hist))
+	}
+
+	// NOTE: anomalous rounds are part of the history, such that a lasting
+	// increase (e.g. the network growing) is accepted after a few rounds.
+	anomalous := len(ap.hist) >= ap.window && float64(len(push)) > ap.factor*avg
+	ap.hist = append(ap.hist, len(push))
+	if len(ap.hist) > ap.window {
+		ap.hist = ap.hist[1:]
+	}
+
+	if anomalous {
+		return false
+	}
+
+	return ap.vp.Refresh(p, push, pull)
+}
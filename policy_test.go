@@ -0,0 +1,56 @@
+package brahms_test
+
+import (
+	"testing"
+
+	"github.com/advanderveer/brahms"
+	"github.com/advanderveer/go-test"
+)
+
+func TestViewPolicies(t *testing.T) {
+	p, _ := brahms.NewParams(0.45, 0.45, 0.1, 10, 10, 2, 0)
+	n1 := brahms.N("127.0.0.1", 1)
+	empty, some := brahms.NewView(), brahms.NewView(n1)
+
+	for _, c := range []struct {
+		vp   brahms.ViewPolicy
+		exps [4]bool //none, only push, only pull, both
+	}{
+		{brahms.AlwaysRefresh, [4]bool{true, true, true, true}},
+		{brahms.RefreshOnPushOrPull, [4]bool{false, true, true, true}},
+		{brahms.RefreshOnPushAndPull, [4]bool{false, false, false, true}},
+	} {
+		test.Equals(t, c.exps[0], c.vp.Refresh(p, empty, empty))
+		test.Equals(t, c.exps[1], c.vp.Refresh(p, some, empty))
+		test.Equals(t, c.exps[2], c.vp.Refresh(p, empty, some))
+		test.Equals(t, c.exps[3], c.vp.Refresh(p, some, some))
+	}
+}
+
+func TestAnomalyPolicy(t *testing.T) {
+	p, _ := brahms.NewParams(0.45, 0.45, 0.1, 100, 10, 2, 0)
+	pushes := func(n int) (v brahms.View) {
+		v = brahms.View{}
+		for i := 0; i < n; i++ {
+			n := brahms.N("127.0.0.1", uint16(i))
+			v[n.Hash()] = *n
+		}
+
+		return
+	}
+
+	ap := brahms.NewAnomalyPolicy(brahms.RefreshOnPushOrPull, 3, 2)
+
+	// without a history nothing is anomalous, and the wrapped policy decides
+	test.Equals(t, true, ap.Refresh(p, pushes(10), nil))
+	test.Equals(t, true, ap.Refresh(p, pushes(2), nil))
+	test.Equals(t, false, ap.Refresh(p, pushes(0), nil))
+
+	// avg is 4, more then twice that is anomalous
+	test.Equals(t, true, ap.Refresh(p, pushes(8), nil))
+	test.Equals(t, false, ap.Refresh(p, pushes(30), nil))
+
+	// a lasting increase is accepted once it dominates the window
+	test.Equals(t, false, ap.Refresh(p, pushes(30), nil))
+	test.Equals(t, true, ap.Refresh(p, pushes(30), nil))
+}
@@ -0,0 +1,123 @@
+package brahms
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// PoWEpoch is the length of the time window a push stamp is valid for. Stamps
+// from the current and the previous epoch are accepted to allow for some clock
+// skew between peers.
+var PoWEpoch = time.Minute
+
+// Stamp proves that a small amount of work was performed to push a node's
+// info to a specific peer during a specific time epoch.
+type Stamp struct {
+	Epoch int64
+	Nonce uint64
+}
+
+// Epoch returns the proof-of-work epoch for a given point in time
+func Epoch(t time.Time) int64 {
+	return t.UnixNano() / int64(PoWEpoch)
+}
+
+// MintStamp searches for a stamp that proves the work of d leading zero bits
+// for a push from the 'from' node to the 'to' node, starting at the provided
+// nonce. Stamps can only be used once so pushes within the same epoch should
+// start their search at a different (random) nonce.
+func MintStamp(from, to NID, epoch int64, nonce uint64, d int) (st Stamp) {
+	st.Epoch, st.Nonce = epoch, nonce
+	for ; !st.proves(from, to, d); st.Nonce++ {
+	}
+
+	return
+}
+
+// Valid returns whether the stamp proves enough work for a push from 'from' to
+// 'to' and was minted for the epoch 'now' or the one before it. If no work is
+// required (d <= 0) any stamp is valid.
+func (st Stamp) Valid(from, to NID, now int64, d int) bool {
+	if d <= 0 {
+		return true //no work required
+	}
+
+	if st.Epoch != now && st.Epoch != now-1 {
+		return false //too old, or from the future
+	}
+
+	return st.proves(from, to, d)
+}
+
+// stampLog remembers the stamps that were used in the epochs stamps are valid
+// for, such that a stamp can't be replayed to push the same node over and over.
+type stampLog struct {
+	mu   sync.Mutex
+	now  int64
+	used map[stampUse]struct{}
+}
+
+// stampUse is a stamp used for a push from a specific node
+type stampUse struct {
+	from NID
+	st   Stamp
+}
+
+// use records the stamp for a push from 'from' during the epoch 'now', it
+// returns false if the stamp was used before. Stamps of older epochs are
+// forgotten as soon as the epoch changes, they are no longer valid anyway.
+func (l *stampLog) use(from NID, st Stamp, now int64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.used == nil || now != l.now {
+		used := make(map[stampUse]struct{})
+		for u := range l.used {
+			if u.st.Epoch >= now-1 {
+				used[u] = struct{}{}
+			}
+		}
+
+		l.now, l.used = now, used
+	}
+
+	u := stampUse{from, st}
+	if _, ok := l.used[u]; ok {
+		return false
+	}
+
+	l.used[u] = struct{}{}
+	return true
+}
+
+// proves returns whether the stamp hash has at least d leading zero bits
+func (st Stamp) proves(from, to NID, d int) bool {
+	if d <= 0 {
+		return true
+	}
+
+	data := make([]byte, 0, len(from)+len(to)+16)
+	data = append(data, from[:]...)
+	data = append(data, to[:]...)
+	data = append(data, make([]byte, 16)...)
+	binary.BigEndian.PutUint64(data[64:], uint64(st.Epoch))
+	binary.BigEndian.PutUint64(data[72:], st.Nonce)
+
+	h := sha256.Sum256(data)
+	for i := 0; i < len(h); i += 8 {
+		z := bits.LeadingZeros64(binary.BigEndian.Uint64(h[i:]))
+		if z >= d {
+			return true
+		}
+
+		if z < 64 {
+			return false
+		}
+
+		d -= 64
+	}
+
+	return false
+}
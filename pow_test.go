@@ -0,0 +1,42 @@
+package brahms_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/advanderveer/brahms"
+	"github.com/advanderveer/go-test"
+)
+
+func TestPoWStamp(t *testing.T) {
+	n1 := brahms.N("127.0.0.1", 1)
+	n2 := brahms.N("127.0.0.1", 2)
+	n3 := brahms.N("127.0.0.1", 3)
+
+	d := 12
+	now := brahms.Epoch(time.Now())
+	st := brahms.MintStamp(n1.Hash(), n2.Hash(), now, 0, d)
+	test.Equals(t, now, st.Epoch)
+
+	// valid for the current and the next epoch
+	test.Equals(t, true, st.Valid(n1.Hash(), n2.Hash(), now, d))
+	test.Equals(t, true, st.Valid(n1.Hash(), n2.Hash(), now+1, d))
+	test.Equals(t, false, st.Valid(n1.Hash(), n2.Hash(), now+2, d))
+	test.Equals(t, false, st.Valid(n1.Hash(), n2.Hash(), now-1, d))
+
+	// not valid for other senders or receivers
+	test.Equals(t, false, st.Valid(n3.Hash(), n2.Hash(), now, d))
+	test.Equals(t, false, st.Valid(n1.Hash(), n3.Hash(), now, d))
+
+	// not enough work for a harder difficulty, without work any stamp is fine
+	test.Equals(t, false, brahms.Stamp{Epoch: now}.Valid(n1.Hash(), n2.Hash(), now, d))
+	test.Equals(t, true, brahms.Stamp{}.Valid(n1.Hash(), n2.Hash(), now, 0))
+}
+
+func BenchmarkMintStamp(b *testing.B) {
+	n1 := brahms.N("127.0.0.1", 1)
+	n2 := brahms.N("127.0.0.1", 2)
+	for i := 0; i < b.N; i++ {
+		brahms.MintStamp(n1.Hash(), n2.Hash(), int64(i), 0, 8)
+	}
+}
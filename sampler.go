@@ -1,10 +1,13 @@
 package brahms
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"math/big"
 	"math/rand"
+	"runtime"
+	"sort"
 	"sync"
 	"time"
 )
@@ -25,33 +28,96 @@ var MaxSampleRank = SampleRank{
 	0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
 }
 
-// Prober allows for probing peers to determine if they are still online
+// KMVSize is the nr of minimum values the sampler's network size estimator
+// keeps. Its relative error is roughly 1/√KMVSize
+var KMVSize = 256
+
+// KMVWindow is the nr of updates after which the sampler's network size
+// estimator starts a new window, nodes that left the network stop counting
+// towards the estimate after two windows.
+var KMVWindow = 50
+
+// ProbeOrder determines how the sampler picks the samples it validates
+type ProbeOrder int
+
+const (
+	// RandomProbeOrder picks a random subset of the samples every validation
+	RandomProbeOrder ProbeOrder = iota
+
+	// RoundRobinProbeOrder walks over the sample slots in a random order that
+	// is reshuffled at the end of every cycle, like SWIM does. With l2 slots
+	// and n probes per validation, a dead sample is probed within 2·⌈l2/n⌉
+	// validations.
+	RoundRobinProbeOrder
+)
+
+// Prober allows for probing peers to determine if they are still online, a
+// nil error means the peer is alive.
 type Prober interface {
-	Probe(ctx context.Context, c chan<- NID, id NID, n Node)
+	Probe(ctx context.Context, n Node) error
+}
+
+// IndirectProber allows for asking another peer to probe a node on our behalf
+type IndirectProber interface {
+	ProbeReq(ctx context.Context, via Node, n Node) error
+}
+
+type requesterKey struct{}
+
+// WithRequester returns a context that tells the indirect prober which node
+// asks for the probe. Transports that can't identify the requester otherwise,
+// like in-memory ones, pass it on such that the helper can check it.
+func WithRequester(ctx context.Context, n Node) context.Context {
+	return context.WithValue(ctx, requesterKey{}, n)
+}
+
+// Requester returns the node that asks for an indirect probe, if any
+func Requester(ctx context.Context) (n Node, ok bool) {
+	n, ok = ctx.Value(requesterKey{}).(Node)
+	return
 }
 
 // Sampler holds a sample from a node stream such that it is not biased by the
 // nr of times a appears in the stream.
 type Sampler struct {
-	seeds   [][32]byte
-	mins    []SampleRank
-	sample  []Node
-	invalid map[NID]time.Time
+	seeds    [][32]byte
+	mins     []SampleRank
+	sample   []Node
+	ids      []NID
+	gen      uint64
+	invalid  map[NID]time.Time
+	suspects map[NID]suspicion
+	est      *WindowKMV
+	updates  int
+	events   *feed
+
+	order    ProbeOrder
+	cycle    []int
+	cursor   int
+	indirect int
+	maxnet   int
 
 	ito    time.Duration
+	sto    time.Duration
+	now    func() time.Time
 	prober Prober
+	self   Node
 	mu     sync.RWMutex
 }
 
 // NewSampler initializes a sampler with the provided source of randomness
 func NewSampler(rnd *rand.Rand, l2 int, pr Prober, ito time.Duration) (s *Sampler) {
 	s = &Sampler{
-		mins:    make([]SampleRank, l2),
-		sample:  make([]Node, l2),
-		seeds:   make([][32]byte, l2),
-		invalid: make(map[NID]time.Time),
-		ito:     ito,
-		prober:  pr,
+		mins:     make([]SampleRank, l2),
+		sample:   make([]Node, l2),
+		ids:      make([]NID, l2),
+		seeds:    make([][32]byte, l2),
+		invalid:  make(map[NID]time.Time),
+		suspects: make(map[NID]suspicion),
+		est:      NewWindowKMV(rnd, KMVSize),
+		ito:      ito,
+		now:      time.Now,
+		prober:   pr,
 	}
 
 	for i := 0; i < l2; i++ {
@@ -62,23 +128,246 @@ func NewSampler(rnd *rand.Rand, l2 int, pr Prober, ito time.Duration) (s *Sample
 	return
 }
 
-// Validate if a random subset of the sampled nodes are still alive
+// SetClock configures the clock that decides when invalidations and
+// suspicions expire.
+func (s *Sampler) SetClock(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = now
+}
+
+// clock returns the current time according to the configured clock
+func (s *Sampler) clock() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.now()
+}
+
+// SetSubnetCap configures how many distinct nodes from the same subnet can be
+// sampled, zero means no cap. Slots that are already taken are not affected.
+func (s *Sampler) SetSubnetCap(max int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxnet = max
+}
+
+// SubnetCap returns how many distinct nodes of the same subnet can be sampled
+func (s *Sampler) SubnetCap() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.maxnet
+}
+
+// SetProbeOrder configures how samples are picked for validation
+func (s *Sampler) SetProbeOrder(o ProbeOrder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.order = o
+}
+
+// SetIndirectProbes configures the nr of other samples that are asked to probe
+// a sample that didn't respond to our own probe. Zero disables indirect probing
+func (s *Sampler) SetIndirectProbes(k int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.indirect = k
+}
+
+// SetSuspicionTimeout configures how long a sample can be suspected of being
+// dead before it is invalidated. Zero disables suspicion, samples are then
+// invalidated as soon as they fail to respond.
+func (s *Sampler) SetSuspicionTimeout(sto time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sto = sto
+}
+
+// pick n samples to validate, according to the configured probe order
+func (s *Sampler) pick(rnd *rand.Rand, n int) (p View) {
+	s.mu.RLock()
+	order := s.order
+	s.mu.RUnlock()
+	if order != RoundRobinProbeOrder {
+		return s.Sample().Pick(rnd, n)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.cycle) != len(s.sample) {
+		s.cycle, s.cursor = rnd.Perm(len(s.sample)), 0 //slots were resized
+	}
+
+	// walk the slots from the cursor, at most one full cycle per call. Empty
+	// slots or samples that are already picked are skipped
+	p = View{}
+	for i := 0; i < len(s.sample) && len(p) < n; i++ {
+		if s.cursor >= len(s.cycle) {
+			s.cycle, s.cursor = rnd.Perm(len(s.sample)), 0
+		}
+
+		sn, id := s.sample[s.cycle[s.cursor]], s.ids[s.cycle[s.cursor]]
+		s.cursor++
+		if sn.IsZero() {
+			continue
+		}
+
+		if _, ok := s.suspects[id]; ok {
+			continue //suspects are probed every validation anyway
+		}
+
+		p[id] = sn
+	}
+
+	return
+}
+
+// Validate if a subset of the sampled nodes are still alive. Samples that
+// don't respond to a direct probe are probed indirectly through other samples
+// (if configured). If a suspicion timeout is configured they then become
+// suspects that are probed every validation, until they respond, refute the
+// suspicion or the timeout expires. Only then are they invalidated.
 func (s *Sampler) Validate(rnd *rand.Rand, n int, to time.Duration) {
-	sample := s.Sample().Pick(rnd, n)
-	probes := make(chan NID, len(sample))
+	sample := s.pick(rnd, n)
+
+	s.mu.RLock()
+	k := s.indirect
+	suspected := make(map[NID]suspicion, len(s.suspects))
+	for i, n := range s.sample {
+		id := s.ids[i]
+		if sus, ok := s.suspects[id]; ok {
+			suspected[id] = sus
+			sample[id] = n
+		}
+	}
+
+	s.mu.RUnlock()
+
+	// probing with the suspected incarnation allows the node to refute it
+	probeCtx := func(ctx context.Context, id NID) context.Context {
+		if sus, ok := suspected[id]; ok {
+			return WithSuspicion(ctx, sus.inc)
+		}
+
+		return ctx
+	}
+
+	probes := make([]func(ctx context.Context, c chan<- NID), 0, len(sample))
+	for id, n := range sample {
+		id, n := id, n
+		probes = append(probes, func(ctx context.Context, c chan<- NID) {
+			if s.prober.Probe(probeCtx(ctx, id), n) == nil {
+				c <- id
+			}
+		})
+	}
+
+	alive := await(to, probes)
+	failed := View{}
+	for id, n := range sample {
+		if _, ok := alive[id]; !ok {
+			failed[id] = n
+		}
+	}
+
+	// ask k other samples to probe the failed nodes on our behalf, such that a
+	// lossy link between us and the node doesn't get it invalidated
+	if ip, ok := s.prober.(IndirectProber); ok && k > 0 && len(failed) > 0 {
+		helpers := s.Sample().Diff(failed).Pick(rnd, k)
+		probes = probes[:0]
+		for id, n := range failed {
+			for _, via := range helpers {
+				id, n, via := id, n, via
+				probes = append(probes, func(ctx context.Context, c chan<- NID) {
+					if ip.ProbeReq(WithRequester(probeCtx(ctx, id), s.self), via, n) == nil {
+						c <- id
+					}
+				})
+			}
+		}
+
+		for id := range await(to, probes) {
+			alive[id] = struct{}{}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// decide what happens to each probed node that didn't respond (in time)
+	now := s.now()
+	invalidate := map[NID]struct{}{}
+	for id, n := range sample {
+		if _, ok := alive[id]; ok {
+			delete(s.suspects, id) //this sample replied to the probe, keep it
+			continue
+		}
+
+		if s.sto > 0 {
+			sus, ok := s.suspects[id]
+			if !ok {
+				s.suspects[id] = suspicion{at: now, inc: n.Inc}
+				continue //start suspecting
+			}
+
+			if now.Sub(sus.at) < s.sto {
+				continue //still suspected, but not dead yet
+			}
+		}
 
-	// @TODO probe only an unpredictable subset every call
+		delete(s.suspects, id)
+		invalidate[id] = struct{}{}
+	}
+
+	// reset the invalidated samples and mark them as such
+	sampled := map[NID]struct{}{}
+	for i := range s.sample {
+		id := s.ids[i]
+		if _, ok := invalidate[id]; !ok {
+			sampled[id] = struct{}{}
+			continue
+		}
+
+		s.invalid[id] = now
+		s.sample[i], s.ids[i] = Node{}, NID{}
+		s.mins[i] = MaxSampleRank
+	}
 
+	for id := range invalidate {
+		s.events.publish(Event{Type: NodeInvalidated, Time: now, ID: id, Node: sample[id]})
+	}
+
+	// forget suspects that are no longer sampled
+	for id := range s.suspects {
+		if _, ok := sampled[id]; !ok {
+			delete(s.suspects, id)
+		}
+	}
+
+	// clear old invalidated nodes
+	for id, t := range s.invalid {
+		if now.Sub(t) < s.ito {
+			continue //still fresh
+		}
+
+		//eviction expired
+		delete(s.invalid, id)
+		s.events.publish(Event{Type: InvalidationExpired, Time: now, ID: id})
+	}
+}
+
+// await runs the probes concurrently and returns the ids that were reported
+// alive before they all returned or the timeout expired
+func await(to time.Duration, probes []func(ctx context.Context, c chan<- NID)) (alive map[NID]struct{}) {
+	c := make(chan NID, len(probes))
 	done := make(chan struct{})
 	go func() {
 		ctx, cancel := context.WithTimeout(context.Background(), to)
 		defer cancel()
 
-		// probe all currently sampled nodes
 		var wg sync.WaitGroup
-		for id, n := range sample {
+		for _, probe := range probes {
 			wg.Add(1)
-			go func(id NID, n Node) { s.prober.Probe(ctx, probes, id, n); wg.Done() }(id, n)
+			go func(probe func(ctx context.Context, c chan<- NID)) { probe(ctx, c); wg.Done() }(probe)
 		}
 
 		// wait for all the return early, or context to cancel whatever is still probing
@@ -87,83 +376,232 @@ func (s *Sampler) Validate(rnd *rand.Rand, n int, to time.Duration) {
 	}()
 	<-done
 
-	//read the indexes of all probes that returned a response
-	alive := map[NID]struct{}{}
+	//read the ids of all probes that returned a response
+	alive = map[NID]struct{}{}
 DRAIN:
 	for {
 		select {
-		case id := <-probes:
+		case id := <-c:
 			alive[id] = struct{}{}
 		default:
 			break DRAIN
 		}
 	}
 
-	// remove any sample that didn't respond (in time) to the probe
-	s.mu.Lock()
-	for i, n := range s.sample {
-		id := n.Hash()
-		if _, ok := sample[id]; !ok {
-			continue //node was not probed, keep it for now
-		}
+	return
+}
 
-		if _, ok := alive[id]; ok {
-			continue //this sample replied to the probe, keep it
+// rankParallelism is the nr of ranks (nodes × slots) an update computes before
+// the work is spread over multiple goroutines
+var rankParallelism = 1 << 14
+
+// rank returns the rank of the id for a slot with the provided seed, it is a
+// seeded crypto hash such that the rank can't be predicted by others.
+func rank(id NID, seed *[32]byte) SampleRank {
+	var buf [64]byte
+	copy(buf[:32], id[:])
+	copy(buf[32:], seed[:])
+	return SampleRank(sha256.Sum256(buf[:]))
+}
+
+// lowest finds, for each slot seed, the index of the id with the lowest rank.
+// Slots are split over goroutines if there is a lot of ranking to be done.
+func lowest(ids []NID, seeds [][32]byte) (mins []SampleRank, idx []int) {
+	mins, idx = make([]SampleRank, len(seeds)), make([]int, len(seeds))
+	work := func(from, to int) {
+		for i := from; i < to; i++ {
+			mins[i], idx[i] = MaxSampleRank, -1
+			for j, id := range ids {
+				if hv := rank(id, &seeds[i]); bytes.Compare(hv[:], mins[i][:]) < 0 {
+					mins[i], idx[i] = hv, j
+				}
+			}
 		}
+	}
 
-		// reset the sample otherwise and mark as invalidated
-		s.invalid[id] = time.Now()
-		s.sample[i] = Node{}
-		s.mins[i] = MaxSampleRank
+	shards := runtime.GOMAXPROCS(0)
+	if len(ids)*len(seeds) < rankParallelism || shards < 2 || len(seeds) < 2 {
+		work(0, len(seeds))
+		return
 	}
 
-	// clear old invalidated nodes
-	for id, t := range s.invalid {
-		if time.Now().Sub(t) < s.ito {
-			continue //still fresh
+	var wg sync.WaitGroup
+	size := (len(seeds) + shards - 1) / shards
+	for from := 0; from < len(seeds); from += size {
+		to := from + size
+		if to > len(seeds) {
+			to = len(seeds)
 		}
 
-		//eviction expired
-		delete(s.invalid, id)
+		wg.Add(1)
+		go func(from, to int) { work(from, to); wg.Done() }(from, to)
 	}
 
-	s.mu.Unlock()
+	wg.Wait()
+	return
 }
 
-// Update the sampler with a new set of ids
+// Update the sampler with a new set of ids. The ranks are computed before the
+// sampler is locked, such that readers of the sample are not held up by it.
 func (s *Sampler) Update(v View) {
+	ids := make([]NID, 0, len(v))
+	for id := range v {
+		ids = append(ids, id)
+	}
+
+	s.mu.RLock()
+	seeds, gen, capped := append([][32]byte{}, s.seeds...), s.gen, s.maxnet > 0
+	s.mu.RUnlock()
+
+	var mins []SampleRank
+	var idx []int
+	if !capped {
+		mins, idx = lowest(ids, seeds)
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for _, n := range v.Sorted() {
+	if s.events != nil {
+		defer s.replaced(append([]Node{}, s.sample...), append([]NID{}, s.ids...))
+	}
+
+	if s.updates++; s.updates%KMVWindow == 0 {
+		s.est.Rotate()
+	}
+
+	for _, id := range ids {
+		s.est.Add(id)
 
-		id := n.Hash()
-		for i, v := range s.mins {
+		// a newer incarnation refutes any suspicion we had of the node
+		if sus, ok := s.suspects[id]; ok && v[id].Inc > sus.inc {
+			delete(s.suspects, id)
+		}
+	}
+
+	// the seeds changed while we were ranking, or a cap makes the outcome
+	// depend on the order of the ids
+	if capped || gen != s.gen {
+		s.updateCapped(v, ids)
+		return
+	}
 
-			// we use a seeded crypto hash to rank a sample
-			hv := SampleRank(sha256.Sum256(append(id[:], s.seeds[i][:]...)))
-			if hv.ToInt().Cmp(v.ToInt()) < 0 {
-				s.mins[i] = hv
-				s.sample[i] = n
+	for i, j := range idx {
+		if j < 0 {
+			continue //nothing to rank
+		}
+
+		s.offer(i, mins[i], ids[j], v[ids[j]])
+	}
+}
+
+// offer a node for a slot, it takes the slot if it has a lower rank
+func (s *Sampler) offer(i int, hv SampleRank, id NID, n Node) (taken bool) {
+	switch bytes.Compare(hv[:], s.mins[i][:]) {
+	case -1:
+		s.mins[i], s.sample[i], s.ids[i] = hv, n, id
+		return true
+	case 0:
+		if n.Newer(s.sample[i]) {
+			s.sample[i] = n //same node, but newer info
+		}
+	}
+
+	return false
+}
+
+// updateCapped updates the slots one node at a time, in the order of their ids,
+// such that the nr of distinct nodes per subnet can be kept under the cap. A
+// node can hold several slots, it only counts once towards the cap.
+func (s *Sampler) updateCapped(v View, ids []NID) {
+	sort.Slice(ids, func(i int, j int) bool { return bytes.Compare(ids[i][:], ids[j][:]) < 0 })
+
+	// with a cap, we keep count of the slots each node of a subnet holds
+	var subnets map[string]map[NID]int
+	if s.maxnet > 0 {
+		subnets = map[string]map[NID]int{}
+		for i, n := range s.sample {
+			if !n.IsZero() {
+				hold(subnets, n.Subnet(), s.ids[i], 1)
 			}
 		}
 	}
 
-	return
+	for _, id := range ids {
+		n := v[id]
+		sn := n.Subnet()
+		for i := range s.mins {
+			hv := rank(id, &s.seeds[i])
+			if subnets != nil && bytes.Compare(hv[:], s.mins[i][:]) < 0 {
+				old, oid := s.sample[i], s.ids[i]
+				held := subnets[sn]
+				last := !old.IsZero() && old.Subnet() == sn && held[oid] == 1
+				if held[id] == 0 && len(held) >= s.maxnet && !last {
+					continue //subnet has enough nodes in the sample
+				}
+
+				hold(subnets, sn, id, 1)
+				if !old.IsZero() {
+					hold(subnets, old.Subnet(), oid, -1)
+				}
+			}
+
+			s.offer(i, hv, id, n)
+		}
+	}
+}
+
+// hold adds d to the nr of slots the node of the subnet holds, nodes that hold
+// no slots are forgotten.
+func hold(subnets map[string]map[NID]int, sn string, id NID, d int) {
+	held, ok := subnets[sn]
+	if !ok {
+		held = map[NID]int{}
+		subnets[sn] = held
+	}
+
+	if held[id] += d; held[id] <= 0 {
+		delete(held, id)
+	}
+
+	if len(held) < 1 {
+		delete(subnets, sn)
+	}
+}
+
+// replaced publishes an event for every slot that holds another node then before
+func (s *Sampler) replaced(old []Node, oldids []NID) {
+	for i, n := range s.sample {
+		if i >= len(old) || n.IsZero() {
+			continue
+		}
+
+		if !old[i].IsZero() && oldids[i] == s.ids[i] {
+			continue //same node, possibly a newer incarnation
+		}
+
+		s.events.publish(Event{Type: SampleReplaced, Time: s.now(), ID: s.ids[i], Node: n, Old: old[i], Slot: i})
+	}
 }
 
-// Sample returns a un-biases sample from all seen nodes
+// Sample returns a un-biases sample from all seen nodes, nodes that are
+// suspected of being dead are left out.
 func (s *Sampler) Sample() (v View) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	v = View{}
-	for _, n := range s.sample {
+	v = make(View, len(s.sample))
+	for i, n := range s.sample {
 		if n.IsZero() {
 			continue
 		}
 
-		v[n.Hash()] = n
+		id := s.ids[i]
+		if _, ok := s.suspects[id]; ok {
+			continue //suspected of being dead
+		}
+
+		v[id] = n
 	}
 
 	return
@@ -178,6 +616,119 @@ func (s *Sampler) Clear() {
 	}
 
 	s.sample = make([]Node, len(s.mins))
+	s.ids = make([]NID, len(s.mins))
+	s.suspects = make(map[NID]suspicion)
+}
+
+// EstimateSize returns an estimate of the nr of distinct nodes the sampler has
+// seen in the recent stream of updates.
+func (s *Sampler) EstimateSize() float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.est.Estimate()
+}
+
+// Resize grows or shrinks the nr of slots in the sampler to l2. Slots that are
+// added get a new seed and are filled with the current sample and the provided
+// view, slots that are removed are forgotten.
+func (s *Sampler) Resize(rnd *rand.Rand, l2 int, v View) {
+	s.mu.Lock()
+	if l2 == len(s.mins) {
+		s.mu.Unlock()
+		return
+	}
+
+	s.gen++
+	if l2 < len(s.mins) {
+		s.mins, s.sample, s.ids, s.seeds = s.mins[:l2], s.sample[:l2], s.ids[:l2], s.seeds[:l2]
+		s.mu.Unlock()
+		return
+	}
+
+	for i := len(s.mins); i < l2; i++ {
+		var seed [32]byte
+		rnd.Read(seed[:])
+
+		s.seeds = append(s.seeds, seed)
+		s.mins = append(s.mins, MaxSampleRank)
+		s.sample = append(s.sample, Node{})
+		s.ids = append(s.ids, NID{})
+	}
+
+	s.mu.Unlock()
+	s.Update(s.Sample().Concat(v))
+}
+
+// Reseed rotates n randomly picked slots of the sampler: they get a new seed,
+// their rank is reset and they're refilled from the provided view. Slots that
+// were taken by adversarial ids will then only be refilled by what the node
+// sees from now on.
+func (s *Sampler) Reseed(rnd *rand.Rand, n int, v View) {
+	s.mu.Lock()
+	s.gen++
+	for i, idx := range rnd.Perm(len(s.seeds)) {
+		if i >= n {
+			break
+		}
+
+		rnd.Read(s.seeds[idx][:])
+		s.mins[idx] = MaxSampleRank
+		s.sample[idx], s.ids[idx] = Node{}, NID{}
+	}
+
+	s.mu.Unlock()
+	s.Update(v)
+}
+
+// Snapshot copies the sampler state into the snapshot
+func (s *Sampler) Snapshot(snap *Snapshot) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snap.Seeds = append([][32]byte{}, s.seeds...)
+	snap.Mins = append([]SampleRank{}, s.mins...)
+	snap.Sample = append([]Node{}, s.sample...)
+	snap.Invalid = make(map[NID]time.Time, len(s.invalid))
+	for id, t := range s.invalid {
+		snap.Invalid[id] = t
+	}
+}
+
+// Restore the sampler state from a snapshot, the nr of slots becomes that of
+// the snapshot.
+func (s *Sampler) Restore(snap *Snapshot) (err error) {
+	if len(snap.Seeds) != len(snap.Mins) || len(snap.Mins) != len(snap.Sample) {
+		return ErrSnapshotInconsistent
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seeds = append([][32]byte{}, snap.Seeds...)
+	s.mins = append([]SampleRank{}, snap.Mins...)
+	s.sample = append([]Node{}, snap.Sample...)
+	s.ids = make([]NID, len(s.sample))
+	for i, n := range s.sample {
+		if !n.IsZero() {
+			s.ids[i] = n.Hash()
+		}
+	}
+
+	s.gen++
+	s.invalid = make(map[NID]time.Time, len(snap.Invalid))
+	for id, t := range snap.Invalid {
+		s.invalid[id] = t
+	}
+
+	return
+}
+
+// Suspected returns whether a given node is currently suspected of being dead
+func (s *Sampler) Suspected(id NID) (ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok = s.suspects[id]
+	return
 }
 
 // RecentlyInvalidated returns whether a given node was recently invalidated
@@ -2,8 +2,10 @@ package brahms_test
 
 import (
 	"context"
+	"fmt"
 	"math/big"
 	"math/rand"
+	"sync"
 	"testing"
 	"time"
 
@@ -11,15 +13,15 @@ import (
 	"github.com/advanderveer/go-test"
 )
 
-type proberFunc func(ctx context.Context, c chan<- brahms.NID, id brahms.NID, n brahms.Node)
+type proberFunc func(ctx context.Context, n brahms.Node) error
 
-func (pr proberFunc) Probe(ctx context.Context, c chan<- brahms.NID, i brahms.NID, n brahms.Node) {
-	pr(ctx, c, i, n)
+func (pr proberFunc) Probe(ctx context.Context, n brahms.Node) error {
+	return pr(ctx, n)
 }
 
 func TestSampler(t *testing.T) {
 	r := rand.New(rand.NewSource(3))
-	pr := proberFunc(func(ctx context.Context, c chan<- brahms.NID, id brahms.NID, n brahms.Node) { c <- id })
+	pr := proberFunc(func(ctx context.Context, n brahms.Node) error { return nil })
 	s := brahms.NewSampler(r, 10, pr, time.Second)
 
 	t.Run("empty sampler should return empty view as sample", func(t *testing.T) {
@@ -60,16 +62,16 @@ func TestSamplerValidation(t *testing.T) {
 	n3 := brahms.N("127.0.0.1", 3)
 	n4 := brahms.N("127.0.0.1", 4)
 
-	pr := proberFunc(func(ctx context.Context, c chan<- brahms.NID, id brahms.NID, n brahms.Node) {
+	pr := proberFunc(func(ctx context.Context, n brahms.Node) error {
 		if n.IsZero() {
 			t.Fatalf("probe func called with zero node")
 		}
 
 		if n.Hash() == n3.Hash() {
-			return //n3 doesn't respond
+			return brahms.ErrTimeout //n3 doesn't respond
 		}
 
-		c <- id
+		return nil
 	})
 
 	r := rand.New(rand.NewSource(3))
@@ -95,7 +97,327 @@ func TestSamplerValidation(t *testing.T) {
 	test.Equals(t, false, s.RecentlyInvalidated(n3.Hash()))
 }
 
+type indirectProber struct {
+	proberFunc
+	req func(ctx context.Context, via brahms.Node, n brahms.Node) error
+}
+
+func (pr indirectProber) ProbeReq(ctx context.Context, via brahms.Node, n brahms.Node) error {
+	return pr.req(ctx, via, n)
+}
+
+func TestSamplerIndirectProbing(t *testing.T) {
+	n1 := brahms.N("127.0.0.1", 1)
+	n2 := brahms.N("127.0.0.1", 2)
+	n3 := brahms.N("127.0.0.1", 3)
+	n4 := brahms.N("127.0.0.1", 4)
+
+	// the link between us and n3 drops packets, n4 is actually down
+	var mu sync.Mutex
+	vias := map[brahms.NID]int{}
+	pr := indirectProber{
+		proberFunc: func(ctx context.Context, n brahms.Node) error {
+			if id := n.Hash(); id == n3.Hash() || id == n4.Hash() {
+				return brahms.ErrTimeout
+			}
+
+			return nil
+		},
+		req: func(ctx context.Context, via brahms.Node, n brahms.Node) error {
+			if via.Hash() == n3.Hash() || via.Hash() == n4.Hash() {
+				t.Fatalf("suspects should not be asked to probe")
+			}
+
+			mu.Lock()
+			vias[via.Hash()]++
+			mu.Unlock()
+			if n.Hash() == n4.Hash() {
+				return brahms.ErrTimeout
+			}
+
+			return nil
+		},
+	}
+
+	r := rand.New(rand.NewSource(1))
+	s := brahms.NewSampler(r, 20, pr, time.Second)
+	s.Update(brahms.NewView(n1, n2, n3, n4))
+
+	// without indirect probes, n3 would have been invalidated
+	s.SetIndirectProbes(2)
+	s.Validate(r, 20, time.Millisecond*10)
+	test.Equals(t, brahms.NewView(n1, n2, n3), s.Sample())
+	test.Equals(t, true, s.RecentlyInvalidated(n4.Hash()))
+	test.Equals(t, false, s.RecentlyInvalidated(n3.Hash()))
+	test.Equals(t, map[brahms.NID]int{n1.Hash(): 2, n2.Hash(): 2}, vias)
+
+	s.SetIndirectProbes(0)
+	s.Validate(r, 20, time.Millisecond*10)
+	test.Equals(t, brahms.NewView(n1, n2), s.Sample())
+}
+
+func TestSamplerSuspicion(t *testing.T) {
+	n1 := brahms.N("127.0.0.1", 1)
+	n2 := brahms.N("127.0.0.1", 2)
+
+	var mu sync.Mutex
+	var refutes []uint64
+	pr := proberFunc(func(ctx context.Context, n brahms.Node) error {
+		if inc, ok := brahms.Suspicion(ctx); ok {
+			mu.Lock()
+			refutes = append(refutes, inc)
+			mu.Unlock()
+		}
+
+		if n.Hash() == n2.Hash() {
+			return brahms.ErrTimeout
+		}
+
+		return nil
+	})
+
+	r := rand.New(rand.NewSource(1))
+	s := brahms.NewSampler(r, 10, pr, time.Second)
+	s.SetSuspicionTimeout(time.Millisecond * 20)
+	s.Update(brahms.NewView(n1, n2))
+
+	// n2 doesn't respond, it is suspected and left out of the sample
+	s.Validate(r, 10, time.Millisecond)
+	test.Equals(t, true, s.Suspected(n2.Hash()))
+	test.Equals(t, false, s.RecentlyInvalidated(n2.Hash()))
+	test.Equals(t, brahms.NewView(n1), s.Sample())
+
+	// suspects are probed every validation with the suspected incarnation
+	s.Validate(r, 0, time.Millisecond)
+	test.Equals(t, true, s.Suspected(n2.Hash()))
+	test.Equals(t, []uint64{0}, refutes)
+
+	// a newer incarnation refutes the suspicion
+	n2b := *n2
+	n2b.Inc = 1
+	s.Update(brahms.NewView(&n2b))
+	test.Equals(t, false, s.Suspected(n2.Hash()))
+	test.Equals(t, brahms.NewView(n1, &n2b), s.Sample())
+	test.Equals(t, uint64(1), s.Sample()[n2.Hash()].Inc)
+
+	// but a stale incarnation does not
+	s.Validate(r, 10, time.Millisecond)
+	test.Equals(t, true, s.Suspected(n2.Hash()))
+	s.Update(brahms.NewView(&n2b))
+	test.Equals(t, true, s.Suspected(n2.Hash()))
+
+	// after the suspicion timeout it is invalidated
+	time.Sleep(time.Millisecond * 20)
+	s.Validate(r, 0, time.Millisecond)
+	test.Equals(t, false, s.Suspected(n2.Hash()))
+	test.Equals(t, true, s.RecentlyInvalidated(n2.Hash()))
+	test.Equals(t, brahms.NewView(n1), s.Sample())
+}
+
+func TestSamplerReseed(t *testing.T) {
+	n1 := brahms.N("127.0.0.1", 1)
+	n2 := brahms.N("127.0.0.1", 2)
+	n3 := brahms.N("127.0.0.1", 3)
+
+	r := rand.New(rand.NewSource(1))
+	s := brahms.NewSampler(r, 10, nil, time.Second)
+	s.Update(brahms.NewView(n1, n2))
+	test.Equals(t, brahms.NewView(n1, n2), s.Sample())
+
+	// reseeding no slots should keep the sample as is
+	s.Reseed(r, 0, brahms.NewView())
+	test.Equals(t, brahms.NewView(n1, n2), s.Sample())
+
+	// reseeding all slots without a view to refill from clears the sample
+	s.Reseed(r, 100, brahms.NewView())
+	test.Equals(t, brahms.NewView(), s.Sample())
+
+	// a reseeded sample is refilled by the provided view
+	s.Reseed(r, 10, brahms.NewView(n3))
+	test.Equals(t, brahms.NewView(n3), s.Sample())
+}
+
+func TestSamplerResize(t *testing.T) {
+	n1 := brahms.N("127.0.0.1", 1)
+	n2 := brahms.N("127.0.0.1", 2)
+	n3 := brahms.N("127.0.0.1", 3)
+
+	r := rand.New(rand.NewSource(1))
+	s := brahms.NewSampler(r, 1, nil, time.Second)
+	s.Update(brahms.NewView(n1, n2, n3))
+	test.Equals(t, 1, len(s.Sample()))
+	test.Equals(t, 3.0, s.EstimateSize())
+
+	// grown slots are filled from the current sample and the view
+	s.Resize(r, 20, brahms.NewView(n3))
+	test.Equals(t, true, len(s.Sample()) >= 1)
+	s.Update(brahms.NewView(n1, n2, n3))
+	test.Equals(t, brahms.NewView(n1, n2, n3), s.Sample())
+
+	s.Resize(r, 1, brahms.NewView())
+	test.Equals(t, 1, len(s.Sample()))
+}
+
+func TestSamplerProbeOrderDetectionTime(t *testing.T) {
+	l2, vn, trials := 20, 2, 100
+
+	// detection returns the nr of validations it took to remove a dead sample
+	detection := func(r *rand.Rand, o brahms.ProbeOrder) (rounds int) {
+		var dead brahms.NID
+		pr := proberFunc(func(ctx context.Context, n brahms.Node) error {
+			if n.Hash() == dead {
+				return brahms.ErrTimeout
+			}
+
+			return nil
+		})
+
+		s := brahms.NewSampler(r, l2, pr, time.Second)
+		s.SetProbeOrder(o)
+		for i := 0; i < 100; i++ {
+			s.Update(brahms.NewView(brahms.N("127.0.0.1", uint16(r.Intn(1000)))))
+		}
+
+		// start a cycle, then pick a random sample to die
+		s.Validate(r, vn, time.Millisecond)
+		for id := range s.Sample().Pick(r, 1) {
+			dead = id
+		}
+
+		for ; ; rounds++ {
+			if _, ok := s.Sample()[dead]; !ok {
+				return
+			}
+
+			s.Validate(r, vn, time.Millisecond)
+		}
+	}
+
+	r := rand.New(rand.NewSource(1))
+	var rndMax, rndTot, rrMax, rrTot int
+	for i := 0; i < trials; i++ {
+		d := detection(r, brahms.RandomProbeOrder)
+		rndTot += d
+		if d > rndMax {
+			rndMax = d
+		}
+
+		d = detection(r, brahms.RoundRobinProbeOrder)
+		rrTot += d
+		if d > rrMax {
+			rrMax = d
+		}
+	}
+
+	t.Logf("random order: mean %.1f, max %d rounds", float64(rndTot)/float64(trials), rndMax)
+	t.Logf("round-robin order: mean %.1f, max %d rounds", float64(rrTot)/float64(trials), rrMax)
+
+	// round robin has an upper bound to detect a dead sample, random doesn't
+	test.Assert(t, rrMax <= 2*l2/vn, fmt.Sprintf("round robin should detect within %d rounds, took %d", 2*l2/vn, rrMax))
+	test.Assert(t, rndMax > rrMax, "random probing should have a longer worst case detection time")
+}
+
 func TestSampleRank(t *testing.T) {
 	test.Equals(t, 0, brahms.SampleRank{}.ToInt().Cmp(big.NewInt(0)))
 	test.Equals(t, "115792089237316195423570985008687907853269984665640564039457584007913129639935", brahms.MaxSampleRank.ToInt().String())
 }
+
+func TestSamplerSubnetCap(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	pr := proberFunc(func(ctx context.Context, n brahms.Node) error { return nil })
+	s := brahms.NewSampler(r, 10, pr, time.Second)
+	s.SetSubnetCap(2)
+
+	// many fake ids behind a single /24, and a few honest nodes elsewhere
+	sybils, honest := brahms.View{}, brahms.View{}
+	for i := 0; i < 500; i++ {
+		sybils.Concat(brahms.NewView(brahms.N(fmt.Sprintf("10.0.0.%d", i%256), uint16(1000+i))))
+	}
+
+	for i := 0; i < 20; i++ {
+		honest.Concat(brahms.NewView(brahms.N(fmt.Sprintf("10.%d.1.1", i+1), 1)))
+	}
+
+	s.Update(sybils)
+	test.Equals(t, 2, len(s.Sample()))
+	s.Update(honest)
+	test.Assert(t, len(s.Sample().Inter(sybils)) <= 2, "sybils should take at most two slots")
+	test.Assert(t, len(s.Sample().Inter(honest)) > 5, "honest nodes should take the other slots")
+
+	// the cap counts distinct nodes, a node that holds many slots counts once
+	s = brahms.NewSampler(r, 10, pr, time.Second)
+	s.SetSubnetCap(2)
+	s.Update(brahms.NewView(brahms.N("10.0.0.1", 1)))
+	snap := &brahms.Snapshot{}
+	s.Snapshot(snap)
+	for _, n := range snap.Sample {
+		test.Equals(t, "10.0.0.1", n.IP.String())
+	}
+
+	s.Update(brahms.NewView(brahms.N("10.0.0.2", 1), brahms.N("10.0.0.3", 1), brahms.N("10.0.0.4", 1)))
+	test.Equals(t, 2, len(s.Sample()))
+
+	// the same holds for ipv6 /48s
+	s = brahms.NewSampler(r, 10, pr, time.Second)
+	s.SetSubnetCap(3)
+	for i := 0; i < 500; i++ {
+		s.Update(brahms.NewView(brahms.N(fmt.Sprintf("2001:db8:1:%x::1", i), 1)))
+	}
+
+	test.Equals(t, 3, len(s.Sample()))
+}
+
+// nodes returns a view of n nodes with distinct addresses
+func nodes(n int) (v brahms.View) {
+	v = brahms.View{}
+	for i := 0; i < n; i++ {
+		nn := brahms.N(fmt.Sprintf("10.%d.%d.%d", i>>16&0xff, i>>8&0xff, i&0xff), 1)
+		v[nn.Hash()] = *nn
+	}
+
+	return
+}
+
+func BenchmarkSamplerUpdate(b *testing.B) {
+	pr := proberFunc(func(ctx context.Context, n brahms.Node) error { return nil })
+	for _, c := range []struct{ l2, n int }{{10, 20}, {100, 1000}, {1000, 10000}} {
+		v := nodes(c.n)
+		b.Run(fmt.Sprintf("l2=%d,n=%d", c.l2, c.n), func(b *testing.B) {
+			s := brahms.NewSampler(rand.New(rand.NewSource(1)), c.l2, pr, time.Second)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.Update(v)
+			}
+		})
+	}
+}
+
+func BenchmarkSamplerSampleDuringUpdate(b *testing.B) {
+	pr := proberFunc(func(ctx context.Context, n brahms.Node) error { return nil })
+	s := brahms.NewSampler(rand.New(rand.NewSource(1)), 1000, pr, time.Second)
+	v := nodes(10000)
+	s.Update(v)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				s.Update(v)
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.Sample()
+		}
+	})
+
+	b.StopTimer()
+	close(done)
+}
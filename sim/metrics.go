@@ -0,0 +1,199 @@
+package sim
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/advanderveer/brahms"
+)
+
+// Metrics describe the network of active cores at the end of a round
+type Metrics struct {
+	Round  int `json:"round"`
+	Active int `json:"active"`
+
+	// in-degree of active nodes in the views of active nodes
+	InDegreeMean   float64 `json:"in_degree_mean"`
+	InDegreeStdDev float64 `json:"in_degree_stddev"`
+	InDegreeMin    int     `json:"in_degree_min"`
+	InDegreeMax    int     `json:"in_degree_max"`
+
+	// SampleUniformity is the entropy of how often each active node appears
+	// in the samples of active nodes, normalized to [0, 1]. It is 1 when every
+	// node is sampled equally often.
+	SampleUniformity float64 `json:"sample_uniformity"`
+
+	// Partitions is the nr of groups of active nodes that don't have each
+	// other in their views, directly or through others.
+	Partitions int `json:"partitions"`
+
+	// fraction of byzantine ids in the views and samples of active honest
+	// nodes, the max shows if any node got eclipsed
+	ByzantineViewMean   float64 `json:"byzantine_view_mean"`
+	ByzantineViewMax    float64 `json:"byzantine_view_max"`
+	ByzantineSampleMean float64 `json:"byzantine_sample_mean"`
+	ByzantineSampleMax  float64 `json:"byzantine_sample_max"`
+}
+
+// measure the network
+func (s *Sim) measure(round int) (m Metrics) {
+	m.Round = round
+
+	// ids of active nodes, in-degrees and the sets they are part of
+	active := make(map[brahms.NID]int, len(s.cores))
+	for i, c := range s.cores {
+		if c.IsActive() {
+			active[Addr(i).Hash()] = len(active)
+		}
+	}
+
+	m.Active = len(active)
+	if m.Active < 1 {
+		return
+	}
+
+	indeg := make([]int, len(active))
+	sampled := make([]int, len(active))
+	sets := newUnion(len(active))
+	for i, c := range s.cores {
+		from, ok := active[Addr(i).Hash()]
+		if !ok {
+			continue
+		}
+
+		view, sample := c.ReadView(), c.Sample()
+		bv, bs := s.cl.Fraction(view), s.cl.Fraction(sample)
+		m.ByzantineViewMean += bv / float64(m.Active)
+		m.ByzantineSampleMean += bs / float64(m.Active)
+		m.ByzantineViewMax = math.Max(m.ByzantineViewMax, bv)
+		m.ByzantineSampleMax = math.Max(m.ByzantineSampleMax, bs)
+
+		for id := range view {
+			if to, ok := active[id]; ok {
+				indeg[to]++
+				sets.join(from, to)
+			}
+		}
+
+		for id := range sample {
+			if to, ok := active[id]; ok {
+				sampled[to]++
+			}
+		}
+	}
+
+	m.InDegreeMin = math.MaxInt32
+	var sum float64
+	for _, d := range indeg {
+		sum += float64(d)
+		if d < m.InDegreeMin {
+			m.InDegreeMin = d
+		}
+		if d > m.InDegreeMax {
+			m.InDegreeMax = d
+		}
+	}
+
+	m.InDegreeMean = sum / float64(len(indeg))
+	for _, d := range indeg {
+		m.InDegreeStdDev += (float64(d) - m.InDegreeMean) * (float64(d) - m.InDegreeMean)
+	}
+
+	m.InDegreeStdDev = math.Sqrt(m.InDegreeStdDev / float64(len(indeg)))
+	m.SampleUniformity = uniformity(sampled)
+	m.Partitions = sets.count()
+	return
+}
+
+// uniformity returns the normalized entropy of the counts
+func uniformity(counts []int) float64 {
+	if len(counts) < 2 {
+		return 1
+	}
+
+	var total float64
+	for _, c := range counts {
+		total += float64(c)
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	var h float64
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / total
+			h -= p * math.Log(p)
+		}
+	}
+
+	return h / math.Log(float64(len(counts)))
+}
+
+// union is a disjoint set of node indexes
+type union []int
+
+func newUnion(n int) (u union) {
+	u = make(union, n)
+	for i := range u {
+		u[i] = i
+	}
+
+	return
+}
+
+func (u union) find(i int) int {
+	for u[i] != i {
+		u[i] = u[u[i]]
+		i = u[i]
+	}
+
+	return i
+}
+
+func (u union) join(a, b int) { u[u.find(a)] = u.find(b) }
+
+func (u union) count() (n int) {
+	for i := range u {
+		if u.find(i) == i {
+			n++
+		}
+	}
+
+	return
+}
+
+// WriteJSON writes the metrics as a JSON array
+func WriteJSON(w io.Writer, ms []Metrics) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ms)
+}
+
+// WriteCSV writes the metrics as CSV with a header row
+func WriteCSV(w io.Writer, ms []Metrics) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"round", "active",
+		"in_degree_mean", "in_degree_stddev", "in_degree_min", "in_degree_max",
+		"sample_uniformity", "partitions",
+		"byzantine_view_mean", "byzantine_view_max", "byzantine_sample_mean", "byzantine_sample_max",
+	})
+
+	ff := func(f float64) string { return strconv.FormatFloat(f, 'f', 4, 64) }
+	for _, m := range ms {
+		cw.Write([]string{
+			strconv.Itoa(m.Round), strconv.Itoa(m.Active),
+			ff(m.InDegreeMean), ff(m.InDegreeStdDev), strconv.Itoa(m.InDegreeMin), strconv.Itoa(m.InDegreeMax),
+			ff(m.SampleUniformity), strconv.Itoa(m.Partitions),
+			ff(m.ByzantineViewMean), ff(m.ByzantineViewMax), ff(m.ByzantineSampleMean), ff(m.ByzantineSampleMax),
+		})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,385 @@
+// Package sim runs large Brahms networks on a virtual clock. Rounds of all
+// cores and the pushes between them are events in a single queue, such that a
+// run is deterministic for a given seed and doesn't depend on the speed of the
+// machine it runs on.
+package sim
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/advanderveer/brahms"
+	"github.com/advanderveer/brahms/transport"
+)
+
+// timeout bounds each round in real time, the simulated transport answers
+// immediately so rounds always end early. It only guards against a stuck run.
+const timeout = time.Minute
+
+// Epoch is the virtual time at which every simulation starts
+var Epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ErrNoParams is returned when the simulation is configured without parameters
+var ErrNoParams = errors.New("simulation requires protocol parameters")
+
+// Config configures a simulation
+type Config struct {
+	// Seed decides all randomness of the run
+	Seed int64
+
+	// Nodes is the nr of cores in the network
+	Nodes int
+
+	// Bootstrap is the nr of nodes each core initially has in its view, the
+	// first is always the next node such that the network starts connected.
+	Bootstrap int
+
+	// Params of the protocol, each core uses the same parameters. Adaptive
+	// parameters are shared and should be created per core through NewParams
+	Params    brahms.P
+	NewParams func() brahms.P
+
+	// ViewPolicy of each core, defaults to always refreshing
+	ViewPolicy func() brahms.ViewPolicy
+
+	// Period and jitter of the gossip rounds, the period is also the interval
+	// at which the network is measured.
+	Period time.Duration
+	Jitter float64
+
+	// Latency of a push, pushes that arrive after the receiver started its
+	// round are considered the next round. Pulls and probes are answered
+	// without latency.
+	Latency time.Duration
+
+	// ReseedRate of the samples each round
+	ReseedRate float64
+
+	// InvalidationTimeout is how long invalidated nodes are not considered
+	InvalidationTimeout time.Duration
+
+	// Byzantine is the nr of colluding adversaries that join the network,
+	// next to the honest nodes. Each round they attack their victims with a
+	// budget of pushes.
+	Byzantine int
+	Attack    transport.Attack
+	Pushes    int
+
+	// Victims is the nr of honest nodes the adversaries target, all honest
+	// nodes are targeted if it is zero.
+	Victims int
+}
+
+// DefaultConfig returns a configuration for a network of n nodes with view
+// and sample sizes of about the cube root of n, as suggested by the paper.
+func DefaultConfig(seed int64, n int) Config {
+	l := 2
+	for l*l*l < n {
+		l++
+	}
+
+	p, _ := brahms.NewParams(0.45, 0.45, 0.1, l, l, 1+l/5, 0)
+	return Config{
+		Seed:                seed,
+		Nodes:               n,
+		Bootstrap:           1,
+		Params:              p,
+		Period:              time.Second,
+		Jitter:              0.1,
+		Latency:             time.Millisecond * 50,
+		InvalidationTimeout: time.Second * 10,
+	}
+}
+
+// kind of event, events at the same time are processed in this order
+type kind int
+
+const (
+	deliverPush kind = iota
+	nodeRound
+	byzantineRound
+	measure
+)
+
+// event is something that happens at a point in virtual time. Node indexes
+// break ties such that the order doesn't depend on when events were queued.
+type event struct {
+	at       time.Time
+	kind     kind
+	to, from int
+	id       brahms.NID
+	node     brahms.Node
+	st       brahms.Stamp
+}
+
+// queue of events, ordered by time
+type queue []*event
+
+func (q queue) Len() int      { return len(q) }
+func (q queue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q queue) Less(i, j int) bool {
+	switch {
+	case !q[i].at.Equal(q[j].at):
+		return q[i].at.Before(q[j].at)
+	case q[i].kind != q[j].kind:
+		return q[i].kind < q[j].kind
+	case q[i].to != q[j].to:
+		return q[i].to < q[j].to
+	case q[i].from != q[j].from:
+		return q[i].from < q[j].from
+	default:
+		return bytes.Compare(q[i].id[:], q[j].id[:]) < 0
+	}
+}
+
+func (q *queue) Push(x interface{}) { *q = append(*q, x.(*event)) }
+func (q *queue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// Sim is a simulated network of cores
+type Sim struct {
+	cfg     Config
+	rnd     *rand.Rand
+	now     time.Time
+	cores   []*brahms.Core
+	advs    []*transport.Adversary
+	peers   []transport.Peer
+	cl      *transport.Collusion
+	index   map[brahms.NID]int
+	queue   queue
+	metrics []Metrics
+	mu      sync.Mutex
+}
+
+// New sets up a network of cores that are yet to run their first round
+func New(cfg Config) (s *Sim, err error) {
+	if cfg.Params == nil && cfg.NewParams == nil {
+		return nil, ErrNoParams
+	}
+
+	if cfg.ViewPolicy == nil {
+		cfg.ViewPolicy = func() brahms.ViewPolicy { return brahms.AlwaysRefresh }
+	}
+
+	s = &Sim{
+		cfg:   cfg,
+		rnd:   rand.New(rand.NewSource(cfg.Seed)),
+		now:   Epoch,
+		index: make(map[brahms.NID]int, cfg.Nodes),
+	}
+
+	nodes := make([]*brahms.Node, cfg.Nodes+cfg.Byzantine)
+	for i := range nodes {
+		nodes[i] = Addr(i)
+		s.index[nodes[i].Hash()] = i
+	}
+
+	clock := func() time.Time { return s.now }
+	for i, self := range nodes[:cfg.Nodes] {
+		v0 := brahms.NewView(nodes[(i+1)%cfg.Nodes])
+		for len(v0) < cfg.Bootstrap && len(v0) < cfg.Nodes-1 {
+			if j := s.rnd.Intn(cfg.Nodes); j != i {
+				v0[nodes[j].Hash()] = *nodes[j]
+			}
+		}
+
+		p := cfg.Params
+		if cfg.NewParams != nil {
+			p = cfg.NewParams()
+		}
+
+		rnd := rand.New(rand.NewSource(s.rnd.Int63()))
+		c := brahms.NewCore(rnd, self, v0, p, cfg.ViewPolicy(), &simTransport{s, i}, cfg.InvalidationTimeout)
+		c.SetClock(clock)
+		if err = c.SetGossipPeriod(cfg.Period, cfg.Jitter); err != nil {
+			return nil, err
+		}
+
+		s.cores = append(s.cores, c)
+		s.peers = append(s.peers, transport.CorePeer(c))
+
+		// the first rounds are spread over the first period
+		s.schedule(&event{at: s.now.Add(time.Duration(s.rnd.Int63n(int64(cfg.Period)))), kind: nodeRound, to: i})
+	}
+
+	// adversaries know their victims from the start
+	victims := brahms.View{}
+	for _, i := range s.rnd.Perm(cfg.Nodes) {
+		if cfg.Victims > 0 && len(victims) >= cfg.Victims {
+			break
+		}
+
+		victims[nodes[i].Hash()] = *nodes[i]
+	}
+
+	p := cfg.Params
+	if cfg.NewParams != nil {
+		p = cfg.NewParams()
+	}
+
+	s.cl = transport.NewCollusion(s.rnd.Int63())
+	for i, self := range nodes[cfg.Nodes:] {
+		i += cfg.Nodes
+		a := transport.NewAdversary(*self, s.cl, cfg.Attack, &simTransport{s, i}, p.D())
+		a.Targets = victims
+		if cfg.Pushes > 0 {
+			a.Pushes = cfg.Pushes
+		}
+
+		s.advs = append(s.advs, a)
+		s.peers = append(s.peers, a)
+		s.schedule(&event{at: s.now.Add(time.Duration(s.rnd.Int63n(int64(cfg.Period)))), kind: byzantineRound, to: i})
+	}
+
+	s.schedule(&event{at: s.now.Add(cfg.Period), kind: measure})
+	return
+}
+
+// Addr returns the address of the i-th node of a simulated network
+func Addr(i int) *brahms.Node {
+	return &brahms.Node{IP: net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)).To4(), Port: 1}
+}
+
+// Cores returns the cores of the honest nodes in the network
+func (s *Sim) Cores() []*brahms.Core { return s.cores }
+
+// Collusion returns the adversaries' collusion, it tells which nodes are
+// byzantine.
+func (s *Sim) Collusion() *transport.Collusion { return s.cl }
+
+// Now returns the current virtual time
+func (s *Sim) Now() time.Time { return s.now }
+
+// Metrics returns the measurements of all rounds so far
+func (s *Sim) Metrics() []Metrics { return s.metrics }
+
+// Kill deactivates n random active cores, they stop gossiping and refuse all
+// requests from then on.
+func (s *Sim) Kill(n int) {
+	var active []int
+	for i, c := range s.cores {
+		if c.IsActive() {
+			active = append(active, i)
+		}
+	}
+
+	s.rnd.Shuffle(len(active), func(i, j int) { active[i], active[j] = active[j], active[i] })
+	for i := 0; i < n && i < len(active); i++ {
+		s.cores[active[i]].Deactivate()
+	}
+}
+
+// Run the simulation until the network was measured another n rounds, the
+// measurements of these rounds are returned.
+func (s *Sim) Run(n int) []Metrics {
+	until := len(s.metrics) + n
+	for len(s.metrics) < until && len(s.queue) > 0 {
+		e := heap.Pop(&s.queue).(*event)
+		s.now = e.at
+
+		switch e.kind {
+		case deliverPush:
+			s.peers[e.to].HandlePush(context.Background(), e.node, e.st)
+
+		case nodeRound:
+			c := s.cores[e.to]
+			if !c.IsActive() {
+				continue //killed cores don't schedule another round
+			}
+
+			c.UpdateView(timeout)
+			c.ValidateSample(timeout)
+			c.ReseedSample(s.cfg.ReseedRate)
+			s.schedule(&event{at: s.now.Add(c.GossipPeriod()), kind: nodeRound, to: e.to})
+
+		case byzantineRound:
+			s.advs[e.to-len(s.cores)].Round(context.Background(), s.now)
+			s.schedule(&event{at: s.now.Add(s.cfg.Period), kind: byzantineRound, to: e.to})
+
+		case measure:
+			s.metrics = append(s.metrics, s.measure(len(s.metrics)+1))
+			s.schedule(&event{at: s.now.Add(s.cfg.Period), kind: measure})
+		}
+	}
+
+	return s.metrics[until-n:]
+}
+
+// schedule an event, it is safe to call from the goroutines of a round
+func (s *Sim) schedule(e *event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	heap.Push(&s.queue, e)
+}
+
+// simTransport delivers messages of one node through the event queue
+type simTransport struct {
+	s    *Sim
+	from int
+}
+
+// peer returns the index and peer of the node, or ErrRefused if it is unknown
+func (t *simTransport) peer(n brahms.Node) (i int, p transport.Peer, err error) {
+	i, ok := t.s.index[n.Hash()]
+	if !ok {
+		return 0, nil, brahms.ErrRefused
+	}
+
+	return i, t.s.peers[i], nil
+}
+
+// Push queues the delivery of the push, it is handled on arrival
+func (t *simTransport) Push(ctx context.Context, self brahms.Node, st brahms.Stamp, to brahms.Node) error {
+	i, _, err := t.peer(to)
+	if err != nil {
+		return err
+	}
+
+	t.s.schedule(&event{at: t.s.now.Add(t.s.cfg.Latency), kind: deliverPush, to: i, from: t.from, id: self.Hash(), node: self, st: st})
+	return nil
+}
+
+// Pull returns the view of the peer at the time of the request
+func (t *simTransport) Pull(ctx context.Context, from brahms.Node) (brahms.View, error) {
+	_, p, err := t.peer(from)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.HandlePull(ctx)
+}
+
+// Probe asks the peer if it is alive
+func (t *simTransport) Probe(ctx context.Context, n brahms.Node) error {
+	_, p, err := t.peer(n)
+	if err != nil {
+		return err
+	}
+
+	return p.HandleProbe(ctx)
+}
+
+// ProbeReq asks the other peer to probe the node
+func (t *simTransport) ProbeReq(ctx context.Context, via brahms.Node, n brahms.Node) error {
+	_, p, err := t.peer(via)
+	if err != nil {
+		return err
+	}
+
+	return p.HandleProbeReq(ctx, *Addr(t.from), n)
+}
+
+// Emit is not simulated
+func (t *simTransport) Emit(ctx context.Context, msg []byte, to brahms.Node) error {
+	return brahms.ErrRefused
+}
@@ -0,0 +1,105 @@
+package sim_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/advanderveer/brahms"
+	"github.com/advanderveer/brahms/sim"
+	"github.com/advanderveer/brahms/transport"
+	"github.com/advanderveer/go-test"
+)
+
+func TestSimDeterministic(t *testing.T) {
+	run := func(seed int64) []sim.Metrics {
+		s, err := sim.New(sim.DefaultConfig(seed, 100))
+		test.Ok(t, err)
+		s.Run(10)
+		s.Kill(10)
+		return s.Run(10)
+	}
+
+	m1 := run(1)
+	test.Equals(t, m1, run(1))
+	test.Assert(t, m1[9] != run(2)[9], "other seeds should result in other networks")
+}
+
+func TestSimConverges(t *testing.T) {
+	s, err := sim.New(sim.DefaultConfig(1, 500))
+	test.Ok(t, err)
+
+	ms := s.Run(30)
+	test.Equals(t, 30, len(ms))
+	test.Equals(t, 30, ms[29].Round)
+	test.Equals(t, 500, ms[29].Active)
+	test.Equals(t, 1, ms[29].Partitions)
+	test.Assert(t, ms[29].InDegreeMean > 3, "views should have filled up, got: %v", ms[29].InDegreeMean)
+	test.Assert(t, ms[29].SampleUniformity > ms[0].SampleUniformity, "samples should become more uniform")
+	test.Assert(t, ms[29].SampleUniformity > 0.8, "samples should be uniform, got: %v", ms[29].SampleUniformity)
+
+	// the network heals after a fifth of the nodes die
+	s.Kill(100)
+	ms = s.Run(20)
+	test.Equals(t, 400, ms[19].Active)
+	test.Equals(t, 1, ms[19].Partitions)
+	test.Equals(t, 50, len(s.Metrics()))
+}
+
+func TestSimOutput(t *testing.T) {
+	ms := []sim.Metrics{{Round: 1, Active: 2, InDegreeMean: 1.5, InDegreeMax: 2, SampleUniformity: 1, Partitions: 1}}
+
+	buf := bytes.NewBuffer(nil)
+	test.Ok(t, sim.WriteCSV(buf, ms))
+	test.Equals(t, "round,active,in_degree_mean,in_degree_stddev,in_degree_min,in_degree_max,sample_uniformity,partitions,"+
+		"byzantine_view_mean,byzantine_view_max,byzantine_sample_mean,byzantine_sample_max\n"+
+		"1,2,1.5000,0.0000,0,2,1.0000,1,0.0000,0.0000,0.0000,0.0000\n", buf.String())
+
+	buf.Reset()
+	test.Ok(t, sim.WriteJSON(buf, ms))
+	test.Assert(t, strings.Contains(buf.String(), `"in_degree_mean": 1.5`), "should contain field")
+
+	var back []sim.Metrics
+	test.Ok(t, json.Unmarshal(buf.Bytes(), &back))
+	test.Equals(t, ms, back)
+}
+
+func BenchmarkSimRound10k(b *testing.B) {
+	s, err := sim.New(sim.DefaultConfig(1, 10000))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	s.Run(b.N)
+}
+
+func TestSimByzantine(t *testing.T) {
+	run := func(attack transport.Attack, victims int) sim.Metrics {
+		cfg := sim.DefaultConfig(1, 150)
+		cfg.Params, _ = brahms.NewParams(0.45, 0.45, 0.1, 12, 12, 3, 0)
+		cfg.Byzantine, cfg.Attack, cfg.Victims, cfg.Pushes = 15, attack, victims, 20
+
+		s, err := sim.New(cfg)
+		test.Ok(t, err)
+		ms := s.Run(30)
+		test.Equals(t, true, s.Collusion().IsMember(sim.Addr(150).Hash()))
+		return ms[len(ms)-1]
+	}
+
+	// flooding and poisoning gets byzantine ids into the views, but the
+	// samples stay close to the fraction of byzantine nodes (~9%)
+	m := run(transport.PushFlood|transport.PullPoison, 0)
+	test.Assert(t, m.ByzantineViewMean > 0, "byzantine ids should be in views")
+	test.Assert(t, m.ByzantineSampleMean < 0.2, "sample should resist, got: %v", m.ByzantineSampleMean)
+	test.Equals(t, 1, m.Partitions)
+
+	// without pushes nobody learns about the poisoners
+	m = run(transport.PullPoison, 0)
+	test.Equals(t, 0.0, m.ByzantineViewMax)
+
+	// a single eclipsed victim keeps honest nodes in its sample
+	m = run(transport.Eclipse, 1)
+	test.Assert(t, m.ByzantineSampleMax < 0.5, "victim should not be eclipsed, got: %v", m.ByzantineSampleMax)
+}
@@ -0,0 +1,152 @@
+package snow
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/advanderveer/brahms"
+)
+
+// ErrTooFewAnswers is returned when fewer than k peers answered a query
+var ErrTooFewAnswers = errors.New("too few peers answered the query")
+
+const (
+	// DefaultQueryTimeout is how long querying a single peer may take
+	DefaultQueryTimeout = time.Second
+
+	// DefaultRetries is the nr of times peers that didn't answer are replaced
+	// by other peers from the sample
+	DefaultRetries = 2
+)
+
+// Peers provides the peers that are queried, the agent implements it
+type Peers interface {
+	Sample() brahms.View
+}
+
+// Transport queries a peer for its preference on a topic
+type Transport interface {
+	Query(ctx context.Context, topic string, c CID, to brahms.Node) (CID, error)
+}
+
+// Handler answers the queries of peers with our preference on a topic
+type Handler interface {
+	HandleQuery(ctx context.Context, topic string, c CID) (CID, error)
+}
+
+// QueryStats counts the queries a querier sent
+type QueryStats struct {
+	Answered uint64 // queries that a peer answered
+	Failed   uint64 // queries that failed or timed out
+	TooFew   uint64 // rounds in which fewer than k peers answered
+}
+
+// SampleQuerier queries k random peers of the sample concurrently. Peers that
+// don't answer in time are replaced by other peers of the sample, such that a
+// few unresponsive peers don't stall the decision.
+type SampleQuerier struct {
+	rnd     *rand.Rand
+	peers   Peers
+	tr      Transport
+	to      time.Duration
+	retries int
+	stats   QueryStats
+	mu      sync.Mutex
+}
+
+// NewSampleQuerier initializes a querier that picks peers with rnd from the
+// sample of peers and queries them with the transport.
+func NewSampleQuerier(rnd *rand.Rand, peers Peers, tr Transport) *SampleQuerier {
+	return &SampleQuerier{
+		rnd:     rnd,
+		peers:   peers,
+		tr:      tr,
+		to:      DefaultQueryTimeout,
+		retries: DefaultRetries,
+	}
+}
+
+// SetTimeout configures how long querying a single peer may take
+func (q *SampleQuerier) SetTimeout(to time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.to = to
+}
+
+// SetRetries configures how many times peers that didn't answer are replaced
+func (q *SampleQuerier) SetRetries(n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.retries = n
+}
+
+// Query k random peers from the sample for their preference on the topic. It
+// returns ErrTooFewAnswers if fewer than k peers answered, after retrying.
+func (q *SampleQuerier) Query(ctx context.Context, topic string, c CID, k int) (p Pref, err error) {
+	q.mu.Lock()
+	to, retries := q.to, q.retries
+	q.mu.Unlock()
+
+	p = Pref{}
+	left := q.peers.Sample()
+	for i := 0; i <= retries && p.Size() < k; i++ {
+		q.mu.Lock()
+		peers := left.Pick(q.rnd, k-p.Size())
+		q.mu.Unlock()
+		if len(peers) < 1 {
+			break //no more peers left to ask
+		}
+
+		left = left.Diff(peers)
+		for rid := range q.ask(ctx, to, topic, c, peers) {
+			p[rid]++
+		}
+	}
+
+	if p.Size() < k {
+		atomic.AddUint64(&q.stats.TooFew, 1)
+		return p, ErrTooFewAnswers
+	}
+
+	return p, nil
+}
+
+// ask the peers concurrently and return the preferences that were answered
+func (q *SampleQuerier) ask(ctx context.Context, to time.Duration, topic string, c CID, peers brahms.View) <-chan CID {
+	answers := make(chan CID, len(peers))
+	var wg sync.WaitGroup
+	wg.Add(len(peers))
+	for _, n := range peers {
+		go func(n brahms.Node) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, to)
+			defer cancel()
+
+			rid, err := q.tr.Query(ctx, topic, c, n)
+			if err != nil || rid == NilC {
+				atomic.AddUint64(&q.stats.Failed, 1)
+				return
+			}
+
+			atomic.AddUint64(&q.stats.Answered, 1)
+			answers <- rid
+		}(n)
+	}
+
+	wg.Wait()
+	close(answers)
+	return answers
+}
+
+// Stats returns a copy of the querier's counters
+func (q *SampleQuerier) Stats() QueryStats {
+	return QueryStats{
+		Answered: atomic.LoadUint64(&q.stats.Answered),
+		Failed:   atomic.LoadUint64(&q.stats.Failed),
+		TooFew:   atomic.LoadUint64(&q.stats.TooFew),
+	}
+}
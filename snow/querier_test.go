@@ -0,0 +1,91 @@
+package snow_test
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/advanderveer/brahms"
+	"github.com/advanderveer/brahms/snow"
+	"github.com/advanderveer/go-test"
+)
+
+// stubPeers provides a fixed sample
+type stubPeers brahms.View
+
+func (p stubPeers) Sample() brahms.View { return brahms.View(p).Copy() }
+
+// stubTransport answers queries with a fixed choice, except for the ports it
+// refuses or that hang until the query times out.
+type stubTransport struct {
+	choice  snow.CID
+	refuse  map[uint16]bool
+	hang    map[uint16]bool
+	queried map[uint16]int
+	mu      sync.Mutex
+}
+
+func (tr *stubTransport) Query(ctx context.Context, topic string, c snow.CID, to brahms.Node) (snow.CID, error) {
+	tr.mu.Lock()
+	tr.queried[to.Port]++
+	tr.mu.Unlock()
+
+	switch {
+	case tr.refuse[to.Port]:
+		return snow.NilC, brahms.ErrRefused
+	case tr.hang[to.Port]:
+		<-ctx.Done()
+		return snow.NilC, ctx.Err()
+	}
+
+	return tr.choice, nil
+}
+
+func TestSampleQuerier(t *testing.T) {
+	peers := stubPeers(brahms.NewView(
+		brahms.N("127.0.0.1", 1), brahms.N("127.0.0.1", 2), brahms.N("127.0.0.1", 3),
+		brahms.N("127.0.0.1", 4), brahms.N("127.0.0.1", 5), brahms.N("127.0.0.1", 6)))
+
+	tr := &stubTransport{
+		choice:  snow.CID{0x01},
+		refuse:  map[uint16]bool{1: true},
+		hang:    map[uint16]bool{2: true},
+		queried: map[uint16]int{},
+	}
+
+	q := snow.NewSampleQuerier(rand.New(rand.NewSource(1)), peers, tr)
+	q.SetTimeout(time.Millisecond * 10)
+
+	// peers that don't answer are replaced by others from the sample
+	p, err := q.Query(context.Background(), "foo", snow.CID{0x02}, 4)
+	test.Ok(t, err)
+	test.Equals(t, snow.Pref{{0x01}: 4}, p)
+	for port, n := range tr.queried {
+		test.Assert(t, n == 1, "peer %d should be queried once, got: %d", port, n)
+	}
+
+	failed := q.Stats().Failed
+	test.Equals(t, uint64(len(tr.queried)-4), failed)
+
+	// not enough peers answer when there are no replacements left
+	tr.queried = map[uint16]int{}
+	p, err = q.Query(context.Background(), "foo", snow.CID{0x02}, 5)
+	test.Equals(t, snow.ErrTooFewAnswers, err)
+	test.Equals(t, 4, p.Size())
+	test.Equals(t, 6, len(tr.queried))
+
+	// without retries the failed peers are not replaced
+	q.SetRetries(0)
+	for i := 0; i < 10; i++ {
+		p, err = q.Query(context.Background(), "foo", snow.CID{0x02}, 6)
+		test.Equals(t, snow.ErrTooFewAnswers, err)
+		test.Equals(t, 4, p.Size())
+	}
+
+	st := q.Stats()
+	test.Equals(t, uint64(11), st.TooFew)
+	test.Equals(t, failed+2+20, st.Failed)
+	test.Equals(t, uint64(4+4+40), st.Answered)
+}
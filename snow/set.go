@@ -0,0 +1,98 @@
+package snow
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Elem is an element that is proposed for a set. Elements with the same
+// conflict key exclude each other, at most one of them is accepted. Elements
+// that don't conflict with others should have a unique key, e.g. their id.
+type Elem struct {
+	ID       CID
+	Conflict string
+}
+
+// Set decides which elements of a proposed set are accepted, by running a
+// decision for every conflict set. Each node prefers the element of a conflict
+// set it saw first, through a proposal or a peer's query, and the network
+// converges on one of them. Decisions run on topics, such that they are driven
+// by the rounds of the topics like any other decision.
+type Set struct {
+	topic string
+	ts    *Topics
+}
+
+// NewSet initializes consensus on the set with the topic, decisions on its
+// conflict sets run on topics that are prefixed with it.
+func NewSet(topic string, ts *Topics) (s *Set, err error) {
+	if len(topic) < 1 || strings.Contains(topic, "/") {
+		return nil, ErrInvalidTopic
+	}
+
+	return &Set{topic: topic, ts: ts}, nil
+}
+
+// Propose elements for the set. For conflict sets we already prefer an
+// element of, the proposed element is ignored.
+func (s *Set) Propose(elems ...Elem) (err error) {
+	for _, e := range elems {
+		_, err = s.ts.Propose(s.prefix()+e.Conflict, e.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Accepted returns the accepted element of every conflict set that is decided
+// and the nr of conflict sets that are still undecided.
+func (s *Set) Accepted() (accepted map[string]CID, undecided int) {
+	accepted = map[string]CID{}
+	for topic, sn := range s.ts.WithPrefix(s.prefix()) {
+		c := sn.Decided()
+		if c == NilC {
+			undecided++
+			continue
+		}
+
+		accepted[strings.TrimPrefix(topic, s.prefix())] = c
+	}
+
+	return
+}
+
+// Await runs rounds of the decisions on the set every interval until every
+// conflict set we know of is decided or the context is done. Conflict sets
+// that peers bring in while waiting are awaited as well.
+func (s *Set) Await(ctx context.Context, interval time.Duration) (accepted map[string]CID, err error) {
+	var undecided int
+	for {
+		for _, sn := range s.ts.WithPrefix(s.prefix()) {
+			sn.Decide(ctx)
+		}
+
+		accepted, undecided = s.Accepted()
+		if undecided == 0 {
+			return accepted, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return accepted, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Forget the decisions on the set
+func (s *Set) Forget() {
+	for topic := range s.ts.WithPrefix(s.prefix()) {
+		s.ts.Forget(topic)
+	}
+}
+
+// prefix returns the prefix of the topics of the set's conflict sets
+func (s *Set) prefix() string { return s.topic + "/" }
@@ -0,0 +1,163 @@
+package snow_test
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/advanderveer/brahms"
+	"github.com/advanderveer/brahms/snow"
+	"github.com/advanderveer/brahms/transport"
+	"github.com/advanderveer/go-test"
+)
+
+func TestSet(t *testing.T) {
+	_, err := snow.NewSet("", nil)
+	test.Equals(t, snow.ErrInvalidTopic, err)
+	_, err = snow.NewSet("a/b", nil)
+	test.Equals(t, snow.ErrInvalidTopic, err)
+
+	q := snow.QuerierFunc(func(ctx context.Context, topic string, c snow.CID, k int) (snow.Pref, error) {
+		return snow.Pref{c: k}, nil
+	})
+
+	ts := snow.NewTopics(q, snow.Params{K: 1, Alpha: 1, Beta: 1})
+	s, err := snow.NewSet("block-1", ts)
+	test.Ok(t, err)
+
+	// the first element of a conflict set is preferred
+	test.Ok(t, s.Propose(snow.Elem{snow.CID{0x01}, "tx-a"}, snow.Elem{snow.CID{0x02}, "tx-b"}, snow.Elem{snow.CID{0x03}, "tx-a"}))
+	test.Equals(t, snow.CID{0x01}, ts.Get("block-1/tx-a").Preferred())
+
+	// conflict sets that peers bring in are part of the set
+	_, err = ts.HandleQuery(context.Background(), "block-1/tx-c", snow.CID{0x04})
+	test.Ok(t, err)
+	_, err = ts.HandleQuery(context.Background(), "block-2/tx-d", snow.CID{0x05})
+	test.Ok(t, err)
+
+	accepted, undecided := s.Accepted()
+	test.Equals(t, map[string]snow.CID{}, accepted)
+	test.Equals(t, 3, undecided)
+
+	accepted, err = s.Await(context.Background(), time.Millisecond)
+	test.Ok(t, err)
+	test.Equals(t, map[string]snow.CID{"tx-a": {0x01}, "tx-b": {0x02}, "tx-c": {0x04}}, accepted)
+
+	s.Forget()
+	accepted, undecided = s.Accepted()
+	test.Equals(t, 0, len(accepted)+undecided)
+	test.Assert(t, ts.Get("block-2/tx-d") != nil, "other sets should not be forgotten")
+}
+
+// byzantine answers every query with a choice other than the queried one, to
+// keep honest nodes from agreeing.
+type byzantine struct {
+	choices map[string][]snow.CID
+}
+
+func (b byzantine) HandleQuery(ctx context.Context, topic string, c snow.CID) (snow.CID, error) {
+	for _, o := range b.choices[topic] {
+		if o != c {
+			return o, nil
+		}
+	}
+
+	return snow.CID{0xff}, nil
+}
+
+func TestSetMemNetwork(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	r := rand.New(rand.NewSource(1))
+	n, byz := 100, 10
+	bp, _ := brahms.NewParams(0.45, 0.45, 0.1, 10, 20, 2, 0)
+	p, _ := snow.NewParams(10, 7, 20)
+	tr := transport.NewMemNetTransport()
+
+	cores := make([]*brahms.Core, 0, n)
+	for i := 0; i < n; i++ {
+		self := brahms.N("127.0.0.1", uint16(i+1))
+		other := brahms.N("127.0.0.1", uint16((i+1)%n+1))
+
+		c := brahms.NewCore(rand.New(rand.NewSource(r.Int63())), self, brahms.NewView(other), bp, brahms.AlwaysRefresh, tr, time.Second)
+		tr.AddCore(c)
+		cores = append(cores, c)
+	}
+
+	for i := 0; i < 30; i++ {
+		for _, c := range cores {
+			c.UpdateView(time.Second)
+			c.ValidateSample(time.Second)
+		}
+	}
+
+	// two conflicting proposals that share an element, an element that only a
+	// few nodes propose and a byzantine minority that answers against them all
+	x1, x2, y, z := snow.Elem{snow.CID{0x01}, "x"}, snow.Elem{snow.CID{0x02}, "x"}, snow.Elem{snow.CID{0x03}, "y"}, snow.Elem{snow.CID{0x04}, "z"}
+	b := byzantine{map[string][]snow.CID{"block/x": {x1.ID, x2.ID}, "block/y": {y.ID}, "block/z": {z.ID}}}
+	for _, c := range cores[:byz] {
+		tr.AddPeer(c.Self(), transport.WithSnow(transport.CorePeer(c), b))
+	}
+
+	sets := make([]*snow.Set, 0, n-byz)
+	for i, c := range cores[byz:] {
+		q := snow.NewSampleQuerier(rand.New(rand.NewSource(r.Int63())), c, tr)
+		ts := snow.NewTopics(q, p)
+		tr.AddPeer(c.Self(), transport.WithSnow(transport.CorePeer(c), ts))
+
+		s, err := snow.NewSet("block", ts)
+		test.Ok(t, err)
+		sets = append(sets, s)
+
+		switch {
+		case i%2 == 0:
+			test.Ok(t, s.Propose(x1, y))
+		default:
+			test.Ok(t, s.Propose(x2, y))
+		}
+
+		if i%15 == 0 {
+			test.Ok(t, s.Propose(z))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	// nodes that decided may learn of conflict sets afterwards, so they are
+	// awaited again until none of them is undecided
+	for pending := true; pending; {
+		var wg sync.WaitGroup
+		wg.Add(len(sets))
+		for _, s := range sets {
+			go func(s *snow.Set) {
+				defer wg.Done()
+				_, err := s.Await(ctx, time.Millisecond)
+				test.Ok(t, err)
+			}(s)
+		}
+
+		wg.Wait()
+
+		pending = false
+		for _, s := range sets {
+			if _, undecided := s.Accepted(); undecided > 0 {
+				pending = true
+			}
+		}
+	}
+
+	exp, _ := sets[0].Accepted()
+	test.Equals(t, 3, len(exp))
+	test.Equals(t, y.ID, exp["y"])
+	test.Equals(t, z.ID, exp["z"])
+	test.Assert(t, exp["x"] == x1.ID || exp["x"] == x2.ID, "one of the conflicting elements should be accepted")
+	for _, s := range sets {
+		accepted, _ := s.Accepted()
+		test.Equals(t, exp, accepted)
+	}
+}
@@ -0,0 +1,242 @@
+// Package snow lets the nodes of a network reach consensus on a choice by
+// repeatedly querying random peers from their Brahms sample for their
+// preference, as the Snowball algorithm of the Avalanche paper.
+package snow
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrKAtLeast is returned when k is too low
+	ErrKAtLeast = errors.New("k must be at least 1")
+
+	// ErrAlphaRange is returned when α is no majority of k
+	ErrAlphaRange = errors.New("α must be more then half of k and at most k")
+
+	// ErrBetaAtLeast is returned when β is too low
+	ErrBetaAtLeast = errors.New("β must be at least 1")
+
+	// ErrInvalidCID is returned when an encoded choice couldn't be decoded
+	ErrInvalidCID = errors.New("invalid choice id")
+)
+
+// CID represents the identity of a choice
+type CID [32]byte
+
+// NilC is the empty choice (no decision)
+var NilC = CID{}
+
+func (c CID) String() string { return hex.EncodeToString(c[:4]) }
+
+// MarshalText encodes the full choice as hex
+func (c CID) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(c[:])), nil
+}
+
+// UnmarshalText decodes a choice from its full hex encoding
+func (c *CID) UnmarshalText(text []byte) error {
+	if hex.DecodedLen(len(text)) != len(c) {
+		return ErrInvalidCID
+	}
+
+	_, err := hex.Decode(c[:], text)
+	return err
+}
+
+// Pref describes the prefence over a set of values
+type Pref map[CID]int
+
+// Size returns the nr of keys times the count for each key
+func (p Pref) Size() (s int) {
+	for _, n := range p {
+		s += n
+	}
+	return
+}
+
+// Count returns how high the preference is for a given choice
+func (p Pref) Count(id CID) (c int) {
+	return p[id]
+}
+
+// Params configure the decision algorithm: each round k peers are queried, a
+// choice needs α of them to count and is accepted after β successive rounds.
+type Params struct {
+	K     int
+	Alpha int
+	Beta  int
+}
+
+// NewParams checks and initializes the decision parameters
+func NewParams(k, α, β int) (p Params, err error) {
+	if k < 1 {
+		return p, ErrKAtLeast
+	}
+
+	if α*2 <= k || α > k {
+		return p, ErrAlphaRange
+	}
+
+	if β < 1 {
+		return p, ErrBetaAtLeast
+	}
+
+	return Params{K: k, Alpha: α, Beta: β}, nil
+}
+
+// Querier allows asking neighbours for a sample of at least k preferences on
+// a topic. It returns an error if fewer peers answered.
+type Querier interface {
+	Query(ctx context.Context, topic string, c CID, k int) (Pref, error)
+}
+
+// QuerierFunc implements the querier with just a function
+type QuerierFunc func(ctx context.Context, topic string, c CID, k int) (Pref, error)
+
+// Query neighbours for at least a k size preference
+func (qf QuerierFunc) Query(ctx context.Context, topic string, c CID, k int) (Pref, error) {
+	return qf(ctx, topic, c, k)
+}
+
+// Snow allows a network to reach consensus on a set of values using cellular
+// Automata logic. It is safe for concurrent use, rounds are decided one at a
+// time while queries of peers are answered in between.
+type Snow struct {
+	topic string
+	curr  CID  // currently preferred choice
+	last  CID  // last preferred choice
+	cnt   int  // confidence in current preferred choice
+	cnfd  Pref // confidence in other choices
+	acc   CID  // currenctly accepted choice
+
+	q  Querier
+	k  int
+	kα int
+	β  int
+
+	mu  sync.Mutex
+	dmu sync.Mutex
+}
+
+// NewSnow initializes a new snow decision algorithm for the topic that queries
+// peers with q.
+func NewSnow(topic string, q Querier, p Params) (s *Snow) {
+	s = &Snow{
+		topic: topic,
+		cnfd:  Pref{},
+		q:     q, k: p.K, kα: p.Alpha, β: p.Beta,
+	}
+
+	return
+}
+
+// Topic returns the topic that is decided on
+func (s *Snow) Topic() string { return s.topic }
+
+// Query responds to a peer with this nodes current preference, if we don't
+// have one yet we adopt the peer's.
+func (s *Snow) Query(c CID) CID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.curr == NilC {
+		s.curr = c
+	}
+
+	return s.curr
+}
+
+// Preferred returns the currently preferred choice
+func (s *Snow) Preferred() CID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.curr
+}
+
+// Decided returns the accepted choice
+func (s *Snow) Decided() CID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.acc
+}
+
+// Decide runs a new iteration of the snowball algorithm. It impements snowball
+// as defined in figure 3 of the original avalanche paper. Peers are queried
+// without holding the lock, it returns the error of the querier if too few of
+// them answered.
+func (s *Snow) Decide(ctx context.Context) (c CID, err error) {
+	s.dmu.Lock()
+	defer s.dmu.Unlock()
+
+	s.mu.Lock()
+	acc, curr := s.acc, s.curr
+	s.mu.Unlock()
+	if acc != NilC {
+		return acc, nil //already have an accepted value, return it
+	}
+
+	// NOTE: we divert from the paper here: instead of waiting for a peer to
+	// bring in an initial opinion (line 5), we query with the nil choice. Peers
+	// that decided no longer query, such that we would otherwise never learn
+	// of their preference when we joined the topic late.
+
+	// query for neighbour preference (line 7)
+	p, err := s.q.Query(ctx, s.topic, curr, s.k)
+	if err != nil {
+		return NilC, err
+	}
+
+	if p.Size() < s.k {
+		return NilC, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// loop over the prefences to change our confidence (line 8)
+	majority := false
+	for rid := range p {
+
+		// if the count is too low, do nothing (line 9)
+		if p.Count(rid) < s.kα {
+			continue
+		}
+
+		majority = true
+
+		//increment confidence (line 10)
+		s.cnfd[rid]++
+		if s.cnfd[rid] > s.cnfd[s.curr] {
+
+			// If new confidence becomes larger the currently prefered choice switch
+			// the current prefence to the new value (line 11-12)
+			s.curr = rid
+		}
+
+		// If we saw a new preference, reset the counter. Else check if we're passed
+		// the confidence threshold and ready to accept the value.
+		if rid != s.last {
+			s.last = rid
+			s.cnt = 1 //NOTE: we divert from the paper by setting the initial count to 1
+		} else {
+			s.cnt++
+			if s.cnt > s.β {
+
+				// accept the preference and return it
+				s.acc = s.curr
+				return s.acc, nil
+			}
+		}
+	}
+
+	// without a majority the successive rounds are interrupted (line 18)
+	if !majority {
+		s.cnt = 0
+	}
+
+	//nothing got accepted, return the zero value
+	return NilC, nil
+}
@@ -0,0 +1,164 @@
+package snow_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/advanderveer/brahms/snow"
+	"github.com/advanderveer/go-test"
+)
+
+func TestParams(t *testing.T) {
+	_, err := snow.NewParams(0, 1, 1)
+	test.Equals(t, snow.ErrKAtLeast, err)
+	_, err = snow.NewParams(10, 5, 1)
+	test.Equals(t, snow.ErrAlphaRange, err)
+	_, err = snow.NewParams(10, 11, 1)
+	test.Equals(t, snow.ErrAlphaRange, err)
+	_, err = snow.NewParams(10, 6, 0)
+	test.Equals(t, snow.ErrBetaAtLeast, err)
+
+	p, err := snow.NewParams(10, 6, 10)
+	test.Ok(t, err)
+	test.Equals(t, snow.Params{K: 10, Alpha: 6, Beta: 10}, p)
+}
+
+func TestCIDText(t *testing.T) {
+	data, err := json.Marshal(snow.CID{0x01, 0x02})
+	test.Ok(t, err)
+
+	var c snow.CID
+	test.Ok(t, json.Unmarshal(data, &c))
+	test.Equals(t, snow.CID{0x01, 0x02}, c)
+	test.Equals(t, snow.ErrInvalidCID, json.Unmarshal([]byte(`"0102"`), &c))
+}
+
+func TestEmptySnow(t *testing.T) {
+	var late bool
+	q := snow.QuerierFunc(func(ctx context.Context, topic string, c snow.CID, k int) (snow.Pref, error) {
+		if late {
+			return snow.Pref{snow.CID{0x01}: k}, nil
+		}
+
+		test.Equals(t, snow.NilC, c)
+		return snow.Pref{}, snow.ErrTooFewAnswers //peers have no preference either
+	})
+
+	s := snow.NewSnow("foo", q, snow.Params{K: 5, Alpha: 3, Beta: 10})
+
+	var c snow.CID
+	for i := 0; i < 1000; i++ {
+		c, _ = s.Decide(context.Background())
+	}
+
+	// without outside input it should remain undecided
+	test.Equals(t, snow.NilC, c)
+	test.Equals(t, snow.NilC, s.Preferred())
+
+	// when peers decided before querying us, we adopt their preference
+	late = true
+	for i := 0; i < 1000 && c == snow.NilC; i++ {
+		c, _ = s.Decide(context.Background())
+	}
+
+	test.Equals(t, snow.CID{0x01}, c)
+}
+
+func TestTooFewAnswers(t *testing.T) {
+	q := snow.QuerierFunc(func(ctx context.Context, topic string, c snow.CID, k int) (snow.Pref, error) {
+		return snow.Pref{c: 1}, snow.ErrTooFewAnswers
+	})
+
+	s := snow.NewSnow("foo", q, snow.Params{K: 5, Alpha: 3, Beta: 10})
+	test.Equals(t, snow.CID{0x01}, s.Query(snow.CID{0x01}))
+	test.Equals(t, snow.CID{0x01}, s.Query(snow.CID{0x02}))
+
+	// even if someone brought in an initial choice it should remain undecided
+	// if too few peers answer
+	var c snow.CID
+	var err error
+	for i := 0; i < 1000; i++ {
+		c, err = s.Decide(context.Background())
+	}
+
+	test.Equals(t, snow.ErrTooFewAnswers, err)
+	test.Equals(t, snow.NilC, c)
+}
+
+func TestLargeEnoughQuery(t *testing.T) {
+	p, c1 := snow.Params{K: 5, Alpha: 3, Beta: 10}, snow.CID{0x01}
+
+	var topics []string
+	q := snow.QuerierFunc(func(ctx context.Context, topic string, c snow.CID, k int) (snow.Pref, error) {
+		topics = append(topics, topic)
+		return snow.Pref{c1: k}, nil
+	})
+
+	s := snow.NewSnow("foo", q, p)
+	s.Query(c1)
+
+	var c snow.CID
+	for i := 0; i < p.Beta; i++ {
+		c, _ = s.Decide(context.Background())
+	}
+
+	test.Equals(t, snow.NilC, c) //after β iterations is still undecided
+	test.Equals(t, snow.NilC, s.Decided())
+	c, _ = s.Decide(context.Background())
+	test.Equals(t, c1, c) //but one more tips into favor
+	test.Equals(t, c1, s.Decided())
+	test.Equals(t, "foo", topics[0])
+}
+
+func TestQuerySwitchingOpinion(t *testing.T) {
+	p, c1, c2, c3 := snow.Params{K: 5, Alpha: 3, Beta: 10}, snow.CID{0x01}, snow.CID{0x02}, snow.CID{0x03}
+	qp := snow.Pref{c1: p.K}
+
+	q := snow.QuerierFunc(func(ctx context.Context, topic string, c snow.CID, k int) (snow.Pref, error) {
+		return qp, nil
+	})
+
+	s := snow.NewSnow("foo", q, p)
+	s.Query(c1)
+
+	var c snow.CID
+	for i := 0; i < p.Beta; i++ {
+		c, _ = s.Decide(context.Background())
+	}
+
+	test.Equals(t, snow.NilC, c) //just, undecided
+
+	// now reduce the original preference, add some of our own. but not enough
+	// to take the new preference into account, it interrupts the rounds.
+	qp[c1] = 2
+	qp[c2] = 2
+	qp[c3] = 1
+	for i := 0; i < 100; i++ {
+		c, _ = s.Decide(context.Background())
+	}
+
+	test.Equals(t, c1, s.Preferred())
+
+	//now new preference has enough to take over the original preference
+	delete(qp, c3)
+	qp[c2] = p.K
+	for i := 0; i < p.Beta; i++ {
+		c, _ = s.Decide(context.Background())
+	}
+
+	test.Equals(t, snow.NilC, c) //still undecided
+	c, _ = s.Decide(context.Background())
+	test.Equals(t, c2, c) //now, just decided
+
+	// now event if we switch to some other preference it will always keep returning
+	// the already accepted choice
+	delete(qp, c1)
+	delete(qp, c2)
+	qp[c3] = p.K
+	for i := 0; i < 100; i++ {
+		c, _ = s.Decide(context.Background())
+	}
+
+	test.Equals(t, c2, c)
+}
@@ -0,0 +1,293 @@
+package snow
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrTooManyTopics is returned when a new topic is decided on while the
+	// max nr of topics is already being decided on
+	ErrTooManyTopics = errors.New("too many topics")
+
+	// ErrInvalidTopic is returned when a topic is empty or too large
+	ErrInvalidTopic = errors.New("invalid topic")
+)
+
+const (
+	// DefaultMaxTopics is the nr of topics that are decided on at most
+	DefaultMaxTopics = 1024
+
+	// DefaultMaxRemoteTopics is the nr of topics that queries of peers can
+	// start at most, such that they can't take up all topics.
+	DefaultMaxRemoteTopics = 256
+
+	// DefaultTopicTTL is how long decided topics and topics that peers started
+	// are kept after they were last proposed or queried
+	DefaultTopicTTL = 10 * time.Minute
+
+	// MaxTopicSize is the nr of bytes a topic can have at most
+	MaxTopicSize = 256
+)
+
+// Topics runs an independent decision for every topic, queries of peers on a
+// topic we don't know yet start a decision with the peer's choice. Decided
+// topics are kept for a while after they were last used, such that late peers
+// still learn what was decided. Topics that peers started are expired as well
+// when they go unused, topics we proposed on are kept until they are decided.
+type Topics struct {
+	q         Querier
+	p         Params
+	max       int
+	maxRemote int
+	nremote   int
+	ttl       time.Duration
+	now       func() time.Time
+	snows     map[string]*entry
+	mu        sync.RWMutex
+}
+
+// entry is the decision on a topic with the time it was last used
+type entry struct {
+	s      *Snow
+	remote bool
+	used   time.Time
+}
+
+// NewTopics initializes decisions on topics that query peers with q and
+// decide with the parameters p.
+func NewTopics(q Querier, p Params) *Topics {
+	return &Topics{
+		q:         q,
+		p:         p,
+		max:       DefaultMaxTopics,
+		maxRemote: DefaultMaxRemoteTopics,
+		ttl:       DefaultTopicTTL,
+		now:       time.Now,
+		snows:     map[string]*entry{},
+	}
+}
+
+// SetMaxTopics configures the nr of topics that are decided on at most
+func (t *Topics) SetMaxTopics(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.max = n
+}
+
+// SetMaxRemoteTopics configures the nr of topics that queries of peers can
+// start at most
+func (t *Topics) SetMaxRemoteTopics(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.maxRemote = n
+}
+
+// SetTopicTTL configures how long decided topics and topics that peers started
+// are kept after they were last proposed or queried
+func (t *Topics) SetTopicTTL(ttl time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ttl = ttl
+}
+
+// SetClock configures the clock that topics expire on, by default this is the
+// wall clock.
+func (t *Topics) SetClock(now func() time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.now = now
+}
+
+// Get returns the decision on a topic or nil if there is none
+func (t *Topics) Get(topic string) *Snow {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if tp, ok := t.snows[topic]; ok {
+		return tp.s
+	}
+
+	return nil
+}
+
+// WithPrefix returns the decisions on topics that start with the prefix
+func (t *Topics) WithPrefix(prefix string) map[string]*Snow {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	snows := map[string]*Snow{}
+	for topic, tp := range t.snows {
+		if strings.HasPrefix(topic, prefix) {
+			snows[topic] = tp.s
+		}
+	}
+
+	return snows
+}
+
+// Forget the decision on a topic
+func (t *Topics) Forget(topic string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.forget(topic)
+}
+
+// forget a topic, the lock must be held
+func (t *Topics) forget(topic string) {
+	if tp, ok := t.snows[topic]; ok && tp.remote {
+		t.nremote--
+	}
+
+	delete(t.snows, topic)
+}
+
+// Expire forgets decided topics and topics that peers started which were not
+// proposed or queried for longer then the ttl.
+func (t *Topics) Expire() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire()
+}
+
+// expire forgets the expired topics, the lock must be held
+func (t *Topics) expire() {
+	now := t.now()
+	for name, tp := range t.snows {
+		if now.Sub(tp.used) <= t.ttl {
+			continue
+		}
+
+		if tp.remote || tp.s.Decided() != NilC {
+			t.forget(name)
+		}
+	}
+}
+
+// Propose a choice on a topic, it becomes our preference unless we already
+// have one.
+func (t *Topics) Propose(topic string, c CID) (s *Snow, err error) {
+	if c == NilC {
+		return nil, ErrInvalidCID
+	}
+
+	s, err = t.snow(topic, false)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Query(c)
+	return s, nil
+}
+
+// HandleQuery answers a peer's query on a topic with our preference. A peer
+// without a preference queries with the nil choice, it doesn't start a topic
+// and is answered with the nil choice if we have no preference either.
+func (t *Topics) HandleQuery(ctx context.Context, topic string, c CID) (CID, error) {
+	if c == NilC {
+		if len(topic) < 1 || len(topic) > MaxTopicSize {
+			return NilC, ErrInvalidTopic
+		}
+
+		s := t.Get(topic)
+		if s == nil {
+			return NilC, nil
+		}
+
+		return s.Preferred(), nil
+	}
+
+	s, err := t.snow(topic, true)
+	if err != nil {
+		return NilC, err
+	}
+
+	return s.Query(c), nil
+}
+
+// snow returns the decision on a topic, a new one is started if there is none.
+// Topics that are started by a peer (remote) count towards a separate bound,
+// they stop counting towards it once we propose on them.
+func (t *Topics) snow(topic string, remote bool) (s *Snow, err error) {
+	if len(topic) < 1 || len(topic) > MaxTopicSize {
+		return nil, ErrInvalidTopic
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tp, ok := t.snows[topic]
+	if ok {
+		tp.used = t.now()
+		if tp.remote && !remote {
+			tp.remote = false
+			t.nremote--
+		}
+
+		return tp.s, nil
+	}
+
+	if len(t.snows) >= t.max || (remote && t.nremote >= t.maxRemote) {
+		t.expire()
+	}
+
+	if len(t.snows) >= t.max || (remote && t.nremote >= t.maxRemote) {
+		return nil, ErrTooManyTopics
+	}
+
+	if remote {
+		t.nremote++
+	}
+
+	s = NewSnow(topic, t.q, t.p)
+	t.snows[topic] = &entry{s: s, remote: remote, used: t.now()}
+	return s, nil
+}
+
+// Round expires topics and runs an iteration of every decision that is not
+// yet decided, they are run concurrently.
+func (t *Topics) Round(ctx context.Context) {
+	t.mu.Lock()
+	t.expire()
+	snows := make([]*Snow, 0, len(t.snows))
+	for _, tp := range t.snows {
+		if tp.s.Decided() == NilC {
+			snows = append(snows, tp.s)
+		}
+	}
+	t.mu.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(len(snows))
+	for _, s := range snows {
+		go func(s *Snow) {
+			defer wg.Done()
+			s.Decide(ctx)
+		}(s)
+	}
+
+	wg.Wait()
+}
+
+// Await runs iterations of the decision on a topic every interval until a
+// choice is accepted or the context is done. Iterations in which too few
+// peers answered are retried.
+func (t *Topics) Await(ctx context.Context, topic string, interval time.Duration) (c CID, err error) {
+	s, err := t.snow(topic, false)
+	if err != nil {
+		return NilC, err
+	}
+
+	for {
+		c, _ = s.Decide(ctx)
+		if c != NilC {
+			return c, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return NilC, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
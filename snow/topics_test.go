@@ -0,0 +1,200 @@
+package snow_test
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/advanderveer/brahms"
+	"github.com/advanderveer/brahms/snow"
+	"github.com/advanderveer/brahms/transport"
+	"github.com/advanderveer/go-test"
+)
+
+func TestTopics(t *testing.T) {
+	p := snow.Params{K: 1, Alpha: 1, Beta: 1}
+	q := snow.QuerierFunc(func(ctx context.Context, topic string, c snow.CID, k int) (snow.Pref, error) {
+		return snow.Pref{c: k}, nil
+	})
+
+	ts := snow.NewTopics(q, p)
+	ts.SetMaxTopics(2)
+
+	// peers that query an unknown topic bring in our initial preference
+	c, err := ts.HandleQuery(context.Background(), "foo", snow.CID{0x01})
+	test.Ok(t, err)
+	test.Equals(t, snow.CID{0x01}, c)
+	c, err = ts.HandleQuery(context.Background(), "foo", snow.CID{0x02})
+	test.Ok(t, err)
+	test.Equals(t, snow.CID{0x01}, c)
+
+	// proposals don't overwrite a preference
+	s, err := ts.Propose("foo", snow.CID{0x02})
+	test.Ok(t, err)
+	test.Equals(t, snow.CID{0x01}, s.Preferred())
+	test.Equals(t, "foo", s.Topic())
+
+	_, err = ts.Propose("bar", snow.CID{0x02})
+	test.Ok(t, err)
+	_, err = ts.Propose("rab", snow.CID{0x02})
+	test.Equals(t, snow.ErrTooManyTopics, err)
+	_, err = ts.HandleQuery(context.Background(), "rab", snow.CID{0x02})
+	test.Equals(t, snow.ErrTooManyTopics, err)
+	_, err = ts.HandleQuery(context.Background(), "", snow.CID{0x02})
+	test.Equals(t, snow.ErrInvalidTopic, err)
+	_, err = ts.HandleQuery(context.Background(), strings.Repeat("a", snow.MaxTopicSize+1), snow.CID{0x02})
+	test.Equals(t, snow.ErrInvalidTopic, err)
+	// peers without a preference learn ours, without starting a topic
+	c, err = ts.HandleQuery(context.Background(), "foo", snow.NilC)
+	test.Ok(t, err)
+	test.Equals(t, snow.CID{0x01}, c)
+	c, err = ts.HandleQuery(context.Background(), "oof", snow.NilC)
+	test.Ok(t, err)
+	test.Equals(t, snow.NilC, c)
+	test.Equals(t, (*snow.Snow)(nil), ts.Get("oof"))
+
+	// rounds decide every topic independently
+	ts.Round(context.Background())
+	ts.Round(context.Background())
+	test.Equals(t, snow.CID{0x01}, ts.Get("foo").Decided())
+	test.Equals(t, snow.CID{0x02}, ts.Get("bar").Decided())
+
+	c, err = ts.Await(context.Background(), "bar", time.Millisecond)
+	test.Ok(t, err)
+	test.Equals(t, snow.CID{0x02}, c)
+
+	// forgotten topics make room for new ones
+	ts.Forget("bar")
+	test.Equals(t, (*snow.Snow)(nil), ts.Get("bar"))
+	_, err = ts.Propose("rab", snow.CID{0x02})
+	test.Ok(t, err)
+
+	// awaiting a topic without a preference runs until the context is done
+	ts.Forget("rab")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	_, err = ts.Await(ctx, "rab", time.Millisecond)
+	test.Equals(t, context.DeadlineExceeded, err)
+}
+
+func TestTopicsExpiry(t *testing.T) {
+	q := snow.QuerierFunc(func(ctx context.Context, topic string, c snow.CID, k int) (snow.Pref, error) {
+		if topic == "rab" {
+			return snow.Pref{}, nil //no peers answer, it is never decided
+		}
+
+		return snow.Pref{c: k}, nil
+	})
+
+	now := time.Unix(0, 0)
+	ts := snow.NewTopics(q, snow.Params{K: 1, Alpha: 1, Beta: 1})
+	ts.SetClock(func() time.Time { return now })
+	ts.SetMaxTopics(3)
+	ts.SetMaxRemoteTopics(1)
+	ts.SetTopicTTL(time.Minute)
+
+	// peers can only start a bounded nr of topics, leaving room for ours
+	_, err := ts.HandleQuery(context.Background(), "foo", snow.CID{0x01})
+	test.Ok(t, err)
+	_, err = ts.HandleQuery(context.Background(), "bar", snow.CID{0x01})
+	test.Equals(t, snow.ErrTooManyTopics, err)
+	_, err = ts.Propose("bar", snow.CID{0x01})
+	test.Ok(t, err)
+	_, err = ts.Propose("rab", snow.CID{0x01})
+	test.Ok(t, err)
+
+	// topics we propose on no longer count as started by peers
+	_, err = ts.Propose("foo", snow.CID{0x01})
+	test.Ok(t, err)
+	_, err = ts.HandleQuery(context.Background(), "oof", snow.CID{0x01})
+	test.Equals(t, snow.ErrTooManyTopics, err)
+
+	// decided topics expire when they go unused, undecided ones we proposed on
+	// are kept
+	ts.Round(context.Background())
+	ts.Round(context.Background())
+	now = now.Add(time.Minute * 2)
+	_, err = ts.HandleQuery(context.Background(), "oof", snow.CID{0x01})
+	test.Ok(t, err)
+	test.Equals(t, (*snow.Snow)(nil), ts.Get("foo"))
+	test.Equals(t, (*snow.Snow)(nil), ts.Get("bar"))
+	test.Assert(t, ts.Get("rab") != nil, "undecided topic we proposed on should be kept")
+
+	// undecided topics that peers started expire as well
+	now = now.Add(time.Minute * 2)
+	ts.Expire()
+	test.Equals(t, (*snow.Snow)(nil), ts.Get("oof"))
+}
+
+func TestMemNetwork(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	r := rand.New(rand.NewSource(1))
+	n, crashed := 100, 10
+	bp, _ := brahms.NewParams(0.45, 0.45, 0.1, 10, 10, 2, 0)
+	p, _ := snow.NewParams(5, 4, 10)
+	tr := transport.NewMemNetTransport()
+
+	cores := make([]*brahms.Core, 0, n)
+	for i := 0; i < n; i++ {
+		self := brahms.N("127.0.0.1", uint16(i+1))
+		other := brahms.N("127.0.0.1", uint16((i+1)%n+1))
+
+		c := brahms.NewCore(rand.New(rand.NewSource(r.Int63())), self, brahms.NewView(other), bp, brahms.AlwaysRefresh, tr, time.Second)
+		tr.AddCore(c)
+		cores = append(cores, c)
+	}
+
+	for i := 0; i < 30; i++ {
+		for _, c := range cores {
+			c.UpdateView(time.Second)
+			c.ValidateSample(time.Second)
+		}
+	}
+
+	// a few nodes don't answer queries, the others replace them
+	choices := []snow.CID{{0x01}, {0x02}, {0x03}}
+	topics := make([]*snow.Topics, 0, n-crashed)
+	for i, c := range cores[crashed:] {
+		q := snow.NewSampleQuerier(rand.New(rand.NewSource(r.Int63())), c, tr)
+		q.SetTimeout(time.Millisecond * 50)
+
+		ts := snow.NewTopics(q, p)
+		tr.AddPeer(c.Self(), transport.WithSnow(transport.CorePeer(c), ts))
+		topics = append(topics, ts)
+
+		// everyone has an opinion on foo, a few on bar and the rest adopts it
+		_, err := ts.Propose("foo", choices[r.Intn(len(choices))])
+		test.Ok(t, err)
+		if i%10 == 0 {
+			_, err = ts.Propose("bar", choices[r.Intn(len(choices))])
+			test.Ok(t, err)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		var wg sync.WaitGroup
+		wg.Add(len(topics))
+		for _, ts := range topics {
+			go func(ts *snow.Topics) {
+				defer wg.Done()
+				ts.Round(context.Background())
+			}(ts)
+		}
+
+		wg.Wait()
+	}
+
+	for _, topic := range []string{"foo", "bar"} {
+		c := topics[0].Get(topic).Decided()
+		test.Assert(t, c != snow.NilC, "should have decided on %s", topic)
+		for _, ts := range topics {
+			test.Assert(t, c == ts.Get(topic).Decided(), "all should have decided on the same choice for %s", topic)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package brahms
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SnapshotVersion is the version of the snapshot format that is written
+const SnapshotVersion = 1
+
+var (
+	// ErrNoSnapshot is returned when a store has no snapshot to load
+	ErrNoSnapshot = errors.New("no snapshot stored")
+
+	// ErrSnapshotVersion is returned when a stored snapshot has an unsupported version
+	ErrSnapshotVersion = errors.New("unsupported snapshot version")
+
+	// ErrSnapshotInconsistent is returned when a snapshot's sampler slots don't line up
+	ErrSnapshotInconsistent = errors.New("snapshot has inconsistent sampler slots")
+)
+
+// Snapshot captures the state of a core such that it can be restored after a
+// restart.
+type Snapshot struct {
+	Version int               `json:"version"`
+	Seeds   [][32]byte        `json:"seeds"`
+	Mins    []SampleRank      `json:"mins"`
+	Sample  []Node            `json:"sample"`
+	Invalid map[NID]time.Time `json:"invalid"`
+	View    []Node            `json:"view"`
+}
+
+// Store persists snapshots of a core's state
+type Store interface {
+	Save(snap *Snapshot) error
+	Load() (snap *Snapshot, err error)
+}
+
+// FileStore stores snapshots in a single file that is replaced atomically
+type FileStore struct {
+	path string
+}
+
+// NewFileStore initializes a store that keeps its snapshot in the directory
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{path: filepath.Join(dir, "brahms.snapshot")}
+}
+
+// Save the snapshot by writing it to a temporary file that is then renamed
+// over the previous snapshot.
+func (s *FileStore) Save(snap *Snapshot) (err error) {
+	f, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(f.Name()) //no-op after the rename succeeded
+	err = json.NewEncoder(f).Encode(snap)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Sync()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), s.path)
+}
+
+// Load the last snapshot that was saved
+func (s *FileStore) Load() (snap *Snapshot, err error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, ErrNoSnapshot
+	} else if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	snap = new(Snapshot)
+	err = json.NewDecoder(f).Decode(snap)
+	if err != nil {
+		return nil, err
+	}
+
+	if snap.Version != SnapshotVersion {
+		return nil, ErrSnapshotVersion
+	}
+
+	return
+}
@@ -0,0 +1,105 @@
+package brahms_test
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/advanderveer/brahms"
+	"github.com/advanderveer/brahms/transport"
+	"github.com/advanderveer/go-test"
+)
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brahms_store_")
+	test.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	s := brahms.NewFileStore(dir)
+	_, err = s.Load()
+	test.Equals(t, brahms.ErrNoSnapshot, err)
+
+	n1 := brahms.N("127.0.0.1", 1)
+	snap := &brahms.Snapshot{
+		Version: brahms.SnapshotVersion,
+		Seeds:   [][32]byte{{0x01}},
+		Mins:    []brahms.SampleRank{{0x02}},
+		Sample:  []brahms.Node{*n1},
+		Invalid: map[brahms.NID]time.Time{n1.Hash(): time.Unix(10, 0).UTC()},
+		View:    []brahms.Node{*n1},
+	}
+
+	test.Ok(t, s.Save(snap))
+	test.Ok(t, s.Save(snap)) //overwrites
+
+	snap2, err := s.Load()
+	test.Ok(t, err)
+	test.Equals(t, snap, snap2)
+
+	// no temporary files should be left behind
+	fis, _ := ioutil.ReadDir(dir)
+	test.Equals(t, 1, len(fis))
+
+	t.Run("unsupported version", func(t *testing.T) {
+		test.Ok(t, s.Save(&brahms.Snapshot{Version: 999}))
+		_, err = s.Load()
+		test.Equals(t, brahms.ErrSnapshotVersion, err)
+	})
+
+	t.Run("corrupt file", func(t *testing.T) {
+		test.Ok(t, ioutil.WriteFile(filepath.Join(dir, "brahms.snapshot"), []byte("{"), 0666))
+		_, err = s.Load()
+		test.Assert(t, err != nil, "should fail to decode")
+	})
+
+	t.Run("missing dir", func(t *testing.T) {
+		err := brahms.NewFileStore(filepath.Join(dir, "foo")).Save(snap)
+		test.Assert(t, err != nil, "should fail to save")
+	})
+}
+
+func TestCoreSnapshotRestore(t *testing.T) {
+	n1 := brahms.N("127.0.0.1", 1)
+	n2 := brahms.N("127.0.0.1", 2)
+	n3 := brahms.N("127.0.0.1", 3)
+	n4 := brahms.N("127.0.0.1", 4)
+
+	dir, err := ioutil.TempDir("", "brahms_store_")
+	test.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	prm, _ := brahms.NewParams(0.45, 0.45, 0.1, 10, 10, 2, 0)
+	r := rand.New(rand.NewSource(1))
+	c1 := brahms.NewCore(r, n1, brahms.NewView(n2, n3), prm, brahms.AlwaysRefresh, transport.NewMockTransport(), time.Second)
+
+	s := brahms.NewFileStore(dir)
+	test.Ok(t, s.Save(c1.Snapshot()))
+	snap, err := s.Load()
+	test.Ok(t, err)
+
+	// a restarted core only knows about its bootstrap node, after restoring it
+	// should know its previous sample and view as well
+	c2 := brahms.NewCore(r, n1, brahms.NewView(n4), prm, brahms.AlwaysRefresh, transport.NewMockTransport(), time.Second)
+	test.Ok(t, c2.Restore(snap))
+	test.Equals(t, c1.Sample(), c2.Sample())
+	test.Equals(t, brahms.NewView(n2, n3, n4), c2.ReadView())
+
+	t.Run("unsupported version", func(t *testing.T) {
+		test.Equals(t, brahms.ErrSnapshotVersion, c2.Restore(&brahms.Snapshot{}))
+	})
+
+	t.Run("inconsistent slots", func(t *testing.T) {
+		snap.Seeds = snap.Seeds[1:]
+		test.Equals(t, brahms.ErrSnapshotInconsistent, c2.Restore(snap))
+	})
+
+	t.Run("resize to params", func(t *testing.T) {
+		prm, _ := brahms.NewParams(0.45, 0.45, 0.1, 10, 2, 2, 0)
+		c3 := brahms.NewCore(r, n1, brahms.NewView(), prm, brahms.AlwaysRefresh, transport.NewMockTransport(), time.Second)
+		test.Ok(t, c3.Restore(c1.Snapshot()))
+		test.Assert(t, len(c3.Sample()) <= 2, "should have resized the restored sampler")
+	})
+}
@@ -0,0 +1,27 @@
+package brahms
+
+import (
+	"context"
+	"time"
+)
+
+// suspicion records that a sample stopped responding to probes
+type suspicion struct {
+	at  time.Time
+	inc uint64
+}
+
+type suspicionKey struct{}
+
+// WithSuspicion returns a context that tells the prober the node it probes is
+// suspected at the provided incarnation. Transports pass this on to the node
+// such that it can refute the suspicion by bumping its incarnation.
+func WithSuspicion(ctx context.Context, inc uint64) context.Context {
+	return context.WithValue(ctx, suspicionKey{}, inc)
+}
+
+// Suspicion returns the incarnation a probed node is suspected at, if any
+func Suspicion(ctx context.Context) (inc uint64, ok bool) {
+	inc, ok = ctx.Value(suspicionKey{}).(uint64)
+	return
+}
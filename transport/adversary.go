@@ -0,0 +1,180 @@
+package transport
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/advanderveer/brahms"
+)
+
+// Attack is a set of byzantine behaviours of an adversary
+type Attack int
+
+const (
+	// PushFlood pushes the ids of colluders to the targets every round
+	PushFlood Attack = 1 << iota
+
+	// PullPoison answers pulls with the ids of colluders only
+	PullPoison
+
+	// ProbeLie answers indirect probes falsely: colluders are always reported
+	// alive and honest nodes are always reported dead.
+	ProbeLie
+)
+
+// Eclipse attackers flood and poison a few targeted nodes, such that their
+// view and sample end up with only colluders.
+const Eclipse = PushFlood | PullPoison | ProbeLie
+
+// Collusion is a group of byzantine nodes that work together, they only
+// promote each other's ids. The randomness of all members is seeded such that
+// attacks can be reproduced.
+type Collusion struct {
+	members brahms.View
+	rnd     *rand.Rand
+	mu      sync.Mutex
+}
+
+// NewCollusion creates a collusion without any members
+func NewCollusion(seed int64) *Collusion {
+	return &Collusion{members: brahms.View{}, rnd: rand.New(rand.NewSource(seed))}
+}
+
+// Members returns a copy of the colluding nodes
+func (cl *Collusion) Members() brahms.View {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.members.Copy()
+}
+
+// IsMember returns whether the node colludes
+func (cl *Collusion) IsMember(id brahms.NID) (ok bool) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	_, ok = cl.members[id]
+	return
+}
+
+// Fraction returns the fraction of nodes in the view that collude, it can be
+// used to measure how far an attack progressed in a honest node's view or
+// sample.
+func (cl *Collusion) Fraction(v brahms.View) float64 {
+	if len(v) < 1 {
+		return 0
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	var n int
+	for id := range v {
+		if _, ok := cl.members[id]; ok {
+			n++
+		}
+	}
+
+	return float64(n) / float64(len(v))
+}
+
+// pick returns n random members
+func (cl *Collusion) pick(n int) brahms.View {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.members.Pick(cl.rnd, n)
+}
+
+// Adversary is a byzantine node of a collusion, it answers requests as a peer
+// of the mem network and attacks its targets each round.
+type Adversary struct {
+	self    brahms.Node
+	cl      *Collusion
+	attacks Attack
+	tr      brahms.Transport
+	d       int
+
+	// Targets are the honest nodes that are attacked
+	Targets brahms.View
+
+	// Pushes is the nr of pushes sent each round, as every push requires a
+	// proof of work this is the adversary's budget.
+	Pushes int
+
+	// ViewSize is the nr of ids that are returned when pulled
+	ViewSize int
+}
+
+// NewAdversary joins the collusion with a node that uses the transport to
+// attack. The difficulty d is the proof of work that pushes require.
+func NewAdversary(self brahms.Node, cl *Collusion, attacks Attack, tr brahms.Transport, d int) (a *Adversary) {
+	cl.mu.Lock()
+	cl.members[self.Hash()] = self
+	cl.mu.Unlock()
+
+	return &Adversary{
+		self:     self,
+		cl:       cl,
+		attacks:  attacks,
+		tr:       tr,
+		d:        d,
+		Targets:  brahms.View{},
+		Pushes:   10,
+		ViewSize: 10,
+	}
+}
+
+// Self returns the adversary's own node
+func (a *Adversary) Self() brahms.Node { return a.self }
+
+// Round runs one round of the attack at the provided time
+func (a *Adversary) Round(ctx context.Context, now time.Time) {
+	if a.attacks&PushFlood == 0 || len(a.Targets) < 1 {
+		return
+	}
+
+	// colluders share their work, the push budget is spend on the ids of
+	// random members to random targets
+	epoch := brahms.Epoch(now)
+	targets := a.Targets.Sorted()
+	for i := 0; i < a.Pushes; i++ {
+		a.cl.mu.Lock()
+		to, nonce := targets[a.cl.rnd.Intn(len(targets))], a.cl.rnd.Uint64()
+		a.cl.mu.Unlock()
+		for id, n := range a.cl.pick(1) {
+			a.tr.Push(ctx, n, brahms.MintStamp(id, to.Hash(), epoch, nonce, a.d), to)
+		}
+	}
+}
+
+// HandleProbe answers that the adversary is alive
+func (a *Adversary) HandleProbe(ctx context.Context) error { return nil }
+
+// HandleProbeReq probes the node on behalf of another, or lies about it
+func (a *Adversary) HandleProbeReq(ctx context.Context, from brahms.Node, n brahms.Node) error {
+	if a.attacks&ProbeLie == 0 {
+		return a.tr.Probe(ctx, n)
+	}
+
+	if !a.cl.IsMember(n.Hash()) {
+		return brahms.ErrRefused
+	}
+
+	return nil
+}
+
+// HandlePush accepts and ignores pushes
+func (a *Adversary) HandlePush(ctx context.Context, from brahms.Node, st brahms.Stamp) error {
+	return nil
+}
+
+// HandlePull returns colluders if the pull is poisoned, or else the targets
+// as an honest node that knows them would
+func (a *Adversary) HandlePull(ctx context.Context) (brahms.View, error) {
+	if a.attacks&PullPoison != 0 {
+		return a.cl.pick(a.ViewSize), nil
+	}
+
+	a.cl.mu.Lock()
+	defer a.cl.mu.Unlock()
+	return a.Targets.Pick(a.cl.rnd, a.ViewSize), nil
+}
@@ -0,0 +1,63 @@
+package transport
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/advanderveer/brahms"
+	"github.com/advanderveer/go-test"
+)
+
+func TestAdversaries(t *testing.T) {
+	ctx := context.Background()
+	r := rand.New(rand.NewSource(1))
+	p, _ := brahms.NewParams(0.45, 0.45, 0.1, 8, 8, 2, 1)
+	mem := NewMemNetTransport()
+
+	n1, n2 := brahms.N("127.0.0.1", 1), brahms.N("127.0.0.1", 2)
+	c1 := brahms.NewCore(r, n1, brahms.NewView(n2), p, brahms.AlwaysRefresh, mem, time.Second)
+	mem.AddCore(c1)
+
+	cl := NewCollusion(1)
+	b1, b2 := brahms.N("127.0.0.2", 1), brahms.N("127.0.0.2", 2)
+	flooder := NewAdversary(*b1, cl, PushFlood|PullPoison, mem, p.D())
+	liar := NewAdversary(*b2, cl, ProbeLie, mem, p.D())
+	mem.AddPeer(*b1, flooder)
+	mem.AddPeer(*b2, liar)
+
+	test.Equals(t, 2, len(cl.Members()))
+	test.Equals(t, true, cl.IsMember(b2.Hash()))
+	test.Equals(t, false, cl.IsMember(n1.Hash()))
+	test.Equals(t, 0.5, cl.Fraction(brahms.NewView(n1, b1)))
+
+	// poisoned pulls only return colluders, others return their targets
+	liar.Targets = brahms.NewView(n1)
+	v, err := mem.Pull(ctx, *b1)
+	test.Ok(t, err)
+	test.Equals(t, 1.0, cl.Fraction(v))
+	v, err = mem.Pull(ctx, *b2)
+	test.Ok(t, err)
+	test.Equals(t, brahms.NewView(n1), v)
+
+	// liars report honest nodes dead and colluders alive, others probe honestly
+	test.Equals(t, brahms.ErrRefused, mem.ProbeReq(ctx, *b2, *n1))
+	test.Ok(t, mem.ProbeReq(ctx, *b2, brahms.Node{IP: b1.IP, Port: b1.Port}))
+	test.Ok(t, mem.ProbeReq(ctx, *b1, *n1))
+	test.Equals(t, brahms.ErrRefused, mem.ProbeReq(ctx, *b1, *n2))
+
+	// flooders spend their budget on pushes with valid stamps, only targets
+	// are pushed to
+	flooder.Pushes = 5
+	flooder.Round(ctx, time.Now())
+	test.Equals(t, uint64(0), c1.Stats().PushesReceived)
+	flooder.Targets = brahms.NewView(n1)
+	flooder.Round(ctx, time.Now())
+	test.Equals(t, uint64(5), c1.Stats().PushesReceived)
+	test.Equals(t, uint64(0), c1.Stats().PushesRejected)
+
+	c1.UpdateView(time.Millisecond * 10)
+	test.Assert(t, cl.Fraction(c1.ReadView()) > 0, "view should have been poisoned")
+	test.Assert(t, cl.Fraction(c1.Sample()) > 0, "sample should have byzantine ids")
+}
@@ -0,0 +1,325 @@
+package transport
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/advanderveer/brahms"
+)
+
+// Delay is a distribution of delays that a message can suffer on a link
+type Delay func(rnd *rand.Rand) time.Duration
+
+// Constant delays every message by d
+func Constant(d time.Duration) Delay {
+	return func(rnd *rand.Rand) time.Duration { return d }
+}
+
+// Uniform delays messages uniformly between min and max
+func Uniform(min, max time.Duration) Delay {
+	return func(rnd *rand.Rand) time.Duration { return min + time.Duration(rnd.Int63n(int64(max-min)+1)) }
+}
+
+// Exponential delays messages exponentially with the provided mean, like the
+// arrival times of a poisson process.
+func Exponential(mean time.Duration) Delay {
+	return func(rnd *rand.Rand) time.Duration { return time.Duration(rnd.ExpFloat64() * float64(mean)) }
+}
+
+// Rule describes the faults of messages on a link
+type Rule struct {
+	// Delay of each message on the link, random delays cause messages to be
+	// reordered.
+	Delay Delay
+
+	// Drop is the probability that a message on the link is lost
+	Drop float64
+
+	// Duplicate is the probability that a push or emit is delivered twice
+	Duplicate float64
+
+	// Partition drops all messages on the link, it is one-way: messages in
+	// the other direction are delivered unless that link is partitioned too.
+	Partition bool
+
+	// Until is the time the rule heals, a zero time never heals
+	Until time.Time
+}
+
+// link is the direction a message travels, the zero NID matches any node
+type link struct{ from, to brahms.NID }
+
+// Faulty decorates a transport with faults that are injected according to
+// per-link rules. The randomness is seeded such that runs can be reproduced,
+// every link draws from its own source such that the faults of a link don't
+// depend on the order in which messages on other links are sent. Rules are
+// shared by all nodes that use the transport through For.
+type Faulty struct {
+	tr    brahms.Transport
+	seed  int64
+	rnds  map[link]*rand.Rand
+	rules map[link][]Rule
+	now   func() time.Time
+	mu    sync.Mutex
+}
+
+// NewFaulty wraps the transport without any faults
+func NewFaulty(tr brahms.Transport, seed int64) *Faulty {
+	return &Faulty{
+		tr:    tr,
+		seed:  seed,
+		rnds:  make(map[link]*rand.Rand),
+		rules: make(map[link][]Rule),
+		now:   time.Now,
+	}
+}
+
+// rnd returns the random source of a link, it is seeded from our seed and the
+// ids of the link's nodes. The caller must hold the lock.
+func (f *Faulty) rnd(l link) *rand.Rand {
+	rnd, ok := f.rnds[l]
+	if ok {
+		return rnd
+	}
+
+	h := fnv.New64a()
+	binary.Write(h, binary.BigEndian, f.seed)
+	h.Write(l.from[:])
+	h.Write(l.to[:])
+	rnd = rand.New(rand.NewSource(int64(h.Sum64())))
+	f.rnds[l] = rnd
+	return rnd
+}
+
+// SetClock configures the clock that decides whether rules have healed
+func (f *Faulty) SetClock(now func() time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = now
+}
+
+// AddRule adds a rule for messages sent from one node to another, a zero NID
+// matches any node.
+func (f *Faulty) AddRule(from, to brahms.NID, r Rule) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	l := link{from, to}
+	f.rules[l] = append(f.rules[l], r)
+}
+
+// Partition adds rules that drop all messages between the two groups of nodes,
+// in both directions, until the provided time.
+func (f *Faulty) Partition(a, b []brahms.NID, until time.Time) {
+	for _, ida := range a {
+		for _, idb := range b {
+			f.AddRule(ida, idb, Rule{Partition: true, Until: until})
+			f.AddRule(idb, ida, Rule{Partition: true, Until: until})
+		}
+	}
+}
+
+// Heal removes all rules
+func (f *Faulty) Heal() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = make(map[link][]Rule)
+}
+
+// For returns the transport as used by the provided node, such that rules for
+// links from (and to) it apply.
+func (f *Faulty) For(self brahms.Node) brahms.Transport {
+	return &faultyNode{f, self.Hash()}
+}
+
+// Emit implements the transport for messages from an unknown node
+func (f *Faulty) Emit(ctx context.Context, msg []byte, to brahms.Node) error {
+	return (&faultyNode{Faulty: f}).Emit(ctx, msg, to)
+}
+
+// Push implements the transport for messages from an unknown node
+func (f *Faulty) Push(ctx context.Context, self brahms.Node, st brahms.Stamp, to brahms.Node) error {
+	return (&faultyNode{f, self.Hash()}).Push(ctx, self, st, to)
+}
+
+// Pull implements the transport for messages from an unknown node
+func (f *Faulty) Pull(ctx context.Context, from brahms.Node) (brahms.View, error) {
+	return (&faultyNode{Faulty: f}).Pull(ctx, from)
+}
+
+// Probe implements the transport for messages from an unknown node
+func (f *Faulty) Probe(ctx context.Context, n brahms.Node) error {
+	return (&faultyNode{Faulty: f}).Probe(ctx, n)
+}
+
+// ProbeReq implements the transport for messages from an unknown node
+func (f *Faulty) ProbeReq(ctx context.Context, via brahms.Node, n brahms.Node) error {
+	return (&faultyNode{Faulty: f}).ProbeReq(ctx, via, n)
+}
+
+// fault is what happens to a single message
+type fault struct {
+	delay time.Duration
+	drop  bool
+	dup   bool
+}
+
+// roll decides the fault of a message sent from one node to another
+func (f *Faulty) roll(from, to brahms.NID) (ft fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now, rnd := f.now(), f.rnd(link{from, to})
+	var zero brahms.NID
+	seen := map[link]struct{}{}
+	for _, l := range []link{{from, to}, {from, zero}, {zero, to}, {zero, zero}} {
+		if _, ok := seen[l]; ok {
+			continue //the wildcards would match the same rules again
+		}
+
+		seen[l] = struct{}{}
+		for _, r := range f.rules[l] {
+			if !r.Until.IsZero() && !now.Before(r.Until) {
+				continue //healed
+			}
+
+			if r.Delay != nil {
+				ft.delay += r.Delay(rnd)
+			}
+
+			ft.drop = ft.drop || r.Partition || rnd.Float64() < r.Drop
+			ft.dup = ft.dup || rnd.Float64() < r.Duplicate
+		}
+	}
+
+	return
+}
+
+// deliver waits for the message to arrive, lost messages only show when the
+// context is done, just like they would over a real network. Whether a message
+// arrives before the context's deadline is decided by the delays the exchange
+// suffered so far (elapsed) instead of by the scheduler, such that outcomes
+// are reproducible. A context that is cancelled explicitly ends the wait right
+// away.
+func (ft fault) deliver(ctx context.Context, start time.Time, elapsed *time.Duration) error {
+	*elapsed += ft.delay
+	deadline, ok := ctx.Deadline()
+	if ft.drop || (ok && start.Add(*elapsed).After(deadline)) {
+		<-ctx.Done()
+		return ctxErr(ctx)
+	}
+
+	if ft.delay <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(ft.delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			<-t.C //the message arrives in time, only the scheduler is late
+			return nil
+		}
+
+		return ctxErr(ctx)
+	}
+}
+
+// ctxErr maps the error of a done context onto the transport errors, the same
+// way the http transport reports them: a deadline is a timeout and an explicit
+// cancellation refuses the request.
+func ctxErr(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return brahms.ErrTimeout
+	}
+
+	return brahms.ErrRefused
+}
+
+// faultyNode is the faulty transport as used by a single node
+type faultyNode struct {
+	*Faulty
+	self brahms.NID
+}
+
+// request delivers a request from this node to another and the response back,
+// requests that only have an effect (dup) may be delivered more then once.
+// Duplicates are handled with a context of their own, since the request they
+// duplicate may be done by the time they arrive.
+func (fn *faultyNode) request(ctx context.Context, to brahms.NID, dup bool, do func(ctx context.Context) error) (err error) {
+	start, elapsed := time.Now(), time.Duration(0)
+	ft := fn.roll(fn.self, to)
+	if dup && ft.dup && !ft.drop {
+		again := fn.roll(fn.self, to)
+		again.drop = false
+		go func() {
+			var elapsed time.Duration
+			if again.deliver(context.Background(), start, &elapsed) != nil {
+				return
+			}
+
+			var dctx context.Context
+			var cancel context.CancelFunc
+			if deadline, ok := ctx.Deadline(); ok {
+				dctx, cancel = context.WithTimeout(context.Background(), deadline.Sub(start))
+			} else {
+				dctx, cancel = context.WithCancel(context.Background())
+			}
+
+			defer cancel()
+			do(dctx)
+		}()
+	}
+
+	err = ft.deliver(ctx, start, &elapsed)
+	if err != nil {
+		return err
+	}
+
+	err = do(ctx)
+	if err != nil {
+		return err
+	}
+
+	return fn.roll(to, fn.self).deliver(ctx, start, &elapsed)
+}
+
+func (fn *faultyNode) Emit(ctx context.Context, msg []byte, to brahms.Node) error {
+	return fn.request(ctx, to.Hash(), true, func(ctx context.Context) error { return fn.tr.Emit(ctx, msg, to) })
+}
+
+func (fn *faultyNode) Push(ctx context.Context, self brahms.Node, st brahms.Stamp, to brahms.Node) error {
+	return fn.request(ctx, to.Hash(), true, func(ctx context.Context) error { return fn.tr.Push(ctx, self, st, to) })
+}
+
+func (fn *faultyNode) Pull(ctx context.Context, from brahms.Node) (v brahms.View, err error) {
+	var rejected error
+	err = fn.request(ctx, from.Hash(), false, func(ctx context.Context) (err error) {
+		v, err = fn.tr.Pull(ctx, from)
+		if errors.As(err, new(brahms.PullRejectedErr)) {
+			rejected, err = err, nil //the rest of the view is still delivered
+		}
+
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v, rejected
+}
+
+func (fn *faultyNode) Probe(ctx context.Context, n brahms.Node) error {
+	return fn.request(ctx, n.Hash(), false, func(ctx context.Context) error { return fn.tr.Probe(ctx, n) })
+}
+
+func (fn *faultyNode) ProbeReq(ctx context.Context, via brahms.Node, n brahms.Node) error {
+	return fn.request(ctx, via.Hash(), false, func(ctx context.Context) error { return fn.tr.ProbeReq(ctx, via, n) })
+}
@@ -0,0 +1,252 @@
+package transport
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/advanderveer/brahms"
+	"github.com/advanderveer/go-test"
+)
+
+// faultyNet creates a mem network of n cores that talk through a faulty
+// transport, each core starts with the next core in its view
+func faultyNet(n int, seed int64) (f *Faulty, cores []*brahms.Core) {
+	r := rand.New(rand.NewSource(seed))
+	p, _ := brahms.NewParams(0.45, 0.45, 0.1, 8, 8, 2, 0)
+
+	mem := NewMemNetTransport()
+	f = NewFaulty(mem, seed)
+	for i := 1; i <= n; i++ {
+		self := brahms.N("127.0.0.1", uint16(i))
+		next := brahms.N("127.0.0.1", uint16(i%n+1))
+		c := brahms.NewCore(r, self, brahms.NewView(next), p, brahms.AlwaysRefresh, f.For(*self), time.Second)
+		mem.AddCore(c)
+		cores = append(cores, c)
+	}
+
+	return
+}
+
+func TestFaultyLinks(t *testing.T) {
+	f, cores := faultyNet(3, 1)
+	n1, n2, n3 := cores[0].Self(), cores[1].Self(), cores[2].Self()
+	tr1, tr2 := f.For(n1), f.For(n2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+
+	// without rules it behaves as the wrapped transport
+	test.Ok(t, tr1.Probe(ctx, n2))
+	_, err := tr1.Pull(ctx, brahms.Node{})
+	test.Equals(t, brahms.ErrRefused, err)
+
+	// a one-way partition blocks pushes from n1 to n2 but not the other way
+	// around. Pulls need both ways.
+	f.AddRule(n1.Hash(), n2.Hash(), Rule{Partition: true})
+	test.Equals(t, brahms.ErrTimeout, tr1.Push(ctx, n1, brahms.Stamp{}, n2))
+	test.Equals(t, uint64(0), cores[1].Stats().PushesReceived)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	test.Equals(t, brahms.ErrTimeout, tr2.Push(ctx, n2, brahms.Stamp{}, n1)) //only the response is lost
+	test.Equals(t, uint64(1), cores[0].Stats().PushesReceived)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	_, err = tr2.Pull(ctx, n1)
+	test.Equals(t, brahms.ErrTimeout, err)
+	test.Ok(t, tr1.Probe(context.Background(), n3))
+
+	// rules heal at the scheduled time
+	now := time.Now()
+	f.SetClock(func() time.Time { return now })
+	f.Heal()
+	f.AddRule(n1.Hash(), brahms.NID{}, Rule{Partition: true, Until: now.Add(time.Second)})
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	test.Equals(t, brahms.ErrTimeout, tr1.Probe(ctx, n3))
+	now = now.Add(time.Second)
+	test.Ok(t, tr1.Probe(context.Background(), n3))
+
+	// delays apply to the request and the response
+	f.Heal()
+	f.AddRule(brahms.NID{}, brahms.NID{}, Rule{Delay: Constant(time.Millisecond * 10)})
+	t0 := time.Now()
+	test.Ok(t, tr1.Probe(context.Background(), n2))
+	test.Assert(t, time.Since(t0) >= time.Millisecond*20, "should have been delayed both ways")
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*5)
+	defer cancel()
+	test.Equals(t, brahms.ErrTimeout, tr1.Probe(ctx, n2))
+
+	// duplicated pushes arrive twice
+	f.Heal()
+	f.AddRule(n1.Hash(), n3.Hash(), Rule{Duplicate: 1})
+	test.Ok(t, tr1.Push(context.Background(), n1, brahms.Stamp{}, n3))
+	time.Sleep(time.Millisecond * 10)
+	test.Equals(t, uint64(2), cores[2].Stats().PushesReceived)
+}
+
+// ctxPeer records whether pushes arrive with a context that is done already
+type ctxPeer struct{ done chan bool }
+
+func (p ctxPeer) HandleProbe(ctx context.Context) error                         { return nil }
+func (p ctxPeer) HandleProbeReq(ctx context.Context, from, n brahms.Node) error { return nil }
+func (p ctxPeer) HandlePull(ctx context.Context) (brahms.View, error)           { return nil, nil }
+func (p ctxPeer) HandlePush(ctx context.Context, f brahms.Node, s brahms.Stamp) error {
+	p.done <- ctx.Err() != nil
+	return nil
+}
+
+func TestFaultyCancel(t *testing.T) {
+	f, cores := faultyNet(2, 1)
+	n1, n2 := cores[0].Self(), cores[1].Self()
+	tr1 := f.For(n1)
+
+	// an explicit cancellation ends the request right away, even if the
+	// message would have arrived before the deadline
+	f.AddRule(brahms.NID{}, brahms.NID{}, Rule{Delay: Constant(time.Millisecond * 50)})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	time.AfterFunc(time.Millisecond*5, cancel)
+	t0 := time.Now()
+	test.Equals(t, brahms.ErrRefused, tr1.Probe(ctx, n2))
+	test.Assert(t, time.Since(t0) < time.Millisecond*50, "should have returned on cancellation")
+
+	// a duplicate is handled with a context of its own
+	f.Heal()
+	n3 := *brahms.N("127.0.0.1", 3)
+	p := ctxPeer{make(chan bool, 2)}
+	f.tr.(*MemNetTransport).AddPeer(n3, p)
+	f.AddRule(n1.Hash(), n3.Hash(), Rule{Duplicate: 1, Delay: Constant(time.Millisecond)})
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	cancel()
+	test.Equals(t, brahms.ErrRefused, tr1.Push(ctx, n1, brahms.Stamp{}, n3))
+	test.Equals(t, false, <-p.done)
+}
+
+func TestFaultyReproducible(t *testing.T) {
+	outcomes := func(seed int64) (oks []bool) {
+		f, cores := faultyNet(2, seed)
+		f.AddRule(brahms.NID{}, brahms.NID{}, Rule{Drop: 0.5, Delay: Uniform(0, time.Millisecond)})
+		tr := f.For(cores[0].Self())
+		for i := 0; i < 50; i++ {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*5)
+			oks = append(oks, tr.Probe(ctx, cores[1].Self()) == nil)
+			cancel()
+		}
+
+		return
+	}
+
+	o1 := outcomes(1)
+	test.Equals(t, o1, outcomes(1))
+	test.Assert(t, !equalBools(o1, outcomes(2)), "other seeds should drop other messages")
+
+	var n int
+	for _, ok := range o1 {
+		if ok {
+			n++
+		}
+	}
+
+	// both ways can drop, so roughly a quarter should arrive
+	test.Assert(t, n > 2 && n < 25, "unexpected nr of delivered probes: %d", n)
+}
+
+func TestFaultyLinkIndependent(t *testing.T) {
+	outcomes := func(others bool) (oks []bool) {
+		f, cores := faultyNet(3, 1)
+		f.AddRule(brahms.NID{}, brahms.NID{}, Rule{Drop: 0.5})
+		tr := f.For(cores[0].Self())
+		for i := 0; i < 50; i++ {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*5)
+			if others {
+				go tr.Probe(ctx, cores[2].Self()) //traffic on another link
+			}
+
+			oks = append(oks, tr.Probe(ctx, cores[1].Self()) == nil)
+			cancel()
+		}
+
+		return
+	}
+
+	// the faults of a link don't depend on what is sent over other links
+	test.Equals(t, outcomes(false), outcomes(true))
+}
+
+func equalBools(a, b []bool) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return len(a) == len(b)
+}
+
+func TestFaultyPartitionReconverges(t *testing.T) {
+	f, cores := faultyNet(20, 1)
+	for _, c := range cores {
+		c.SetSuspicionTimeout(time.Second) //outlasts the partition
+	}
+
+	var a, b []brahms.NID
+	side := map[brahms.NID]int{}
+	for i, c := range cores {
+		self := c.Self()
+		id := self.Hash()
+		if i < len(cores)/2 {
+			a, side[id] = append(a, id), 0
+		} else {
+			b, side[id] = append(b, id), 1
+		}
+	}
+
+	// crossing counts the cores whose sample holds nodes of the other side
+	crossing := func() (n int) {
+		for _, c := range cores {
+			self := c.Self()
+			for id := range c.Sample() {
+				if side[id] != side[self.Hash()] {
+					n++
+					break
+				}
+			}
+		}
+
+		return
+	}
+
+	round := func() {
+		for _, c := range cores {
+			c.UpdateView(time.Millisecond * 2)
+			c.ValidateSample(time.Millisecond * 2)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		round()
+	}
+
+	test.Equals(t, len(cores), crossing())
+
+	// while partitioned, the other side is suspected and left out of the sample
+	now := time.Now()
+	f.SetClock(func() time.Time { return now })
+	f.Partition(a, b, now.Add(time.Minute))
+	for i := 0; i < 10; i++ {
+		round()
+	}
+
+	test.Assert(t, crossing() < len(cores)/2, "partition should've split the samples, crossing: %d", crossing())
+
+	// after the scheduled heal the overlay reconverges
+	now = now.Add(time.Minute)
+	for i := 0; i < 10; i++ {
+		round()
+	}
+
+	test.Equals(t, len(cores), crossing())
+}
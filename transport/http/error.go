@@ -1,6 +1,16 @@
 package httpt
 
-// TransportErr describes an error during transport functions
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/advanderveer/brahms"
+)
+
+// TransportErr describes an error during transport functions. It matches the
+// transport errors of the brahms package with errors.Is, depending on the
+// operation that failed.
 type TransportErr struct {
 	E  error
 	Op string
@@ -9,3 +19,30 @@ type TransportErr struct {
 func (e TransportErr) Error() string {
 	return e.E.Error()
 }
+
+// Unwrap returns the underlying error
+func (e TransportErr) Unwrap() error {
+	return e.E
+}
+
+// Is reports whether the error is of the provided kind of transport error
+func (e TransportErr) Is(target error) bool {
+	switch target {
+	case brahms.ErrTimeout:
+		return e.Op == "request_execution" && timeout(e.E)
+	case brahms.ErrRefused:
+		return (e.Op == "request_execution" && !timeout(e.E)) || e.Op == "peer_verification"
+	case brahms.ErrBadStatus:
+		return e.Op == "response_status"
+	case brahms.ErrDecode:
+		return e.Op == "response_decoding"
+	default:
+		return false
+	}
+}
+
+// timeout returns whether the error was caused by a deadline
+func timeout(err error) bool {
+	var ne net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout())
+}
@@ -1,19 +1,26 @@
 package httpt
 
 import (
+	"context"
 	"encoding/json"
 	"io"
+	"net"
 	"net/http"
 	"time"
 
 	"github.com/advanderveer/brahms"
+	"github.com/advanderveer/brahms/broadcast"
+	"github.com/advanderveer/brahms/snow"
 )
 
 // Brahms provides the handler with the state of the algorithm
 type Brahms interface {
 	IsActive() bool
-	ReceiveNode(other brahms.Node)
+	ReceiveNode(other brahms.Node, st brahms.Stamp) bool
 	ReadView() brahms.View
+	Sample() brahms.View
+	ProbeNode(ctx context.Context, n brahms.Node) bool
+	Refute(inc uint64)
 }
 
 // Encoder is used for encoding messages to the handlers response
@@ -34,11 +41,44 @@ type Handler struct {
 	brahms Brahms
 	enc    func(r io.Writer) Encoder
 	dec    func(r io.Reader) Decoder
+
+	maxBody int64
+	pushes  *limiter
+	tree    broadcast.TreeHandler
+	snow    snow.Handler
 }
 
 // NewHandlerWithEncoding initates a new handler with custom encoding
 func NewHandlerWithEncoding(b Brahms, bufn int, to time.Duration, enc func(r io.Writer) Encoder, dec func(r io.Reader) Decoder) *Handler {
-	return &Handler{make(chan []byte, bufn), to, b, enc, dec}
+	return &Handler{C: make(chan []byte, bufn), to: to, brahms: b, enc: enc, dec: dec, maxBody: DefaultMaxBodySize}
+}
+
+// SetPushRate limits the pushes each source address can do to rate per
+// second, with bursts of up to burst pushes. Pushes beyond it are answered
+// with too many requests. A rate of zero or less removes the limit.
+func (h *Handler) SetPushRate(rate float64, burst int) {
+	h.pushes = nil
+	if rate > 0 {
+		h.pushes = newLimiter(rate, burst)
+	}
+}
+
+// SetTree configures the handler to hand the messages of an epidemic
+// broadcast tree to t, without it they are answered with not found.
+func (h *Handler) SetTree(t broadcast.TreeHandler) {
+	h.tree = t
+}
+
+// SetSnow configures the handler to answer consensus queries with s, without
+// it they are answered with not found.
+func (h *Handler) SetSnow(s snow.Handler) {
+	h.snow = s
+}
+
+// SetMaxBodySize configures the nr of bytes of a request body that are read
+// at most, larger requests are answered with a bad request.
+func (h *Handler) SetMaxBodySize(n int64) {
+	h.maxBody = n
 }
 
 // NewHandler initates a new handler with default json encoding
@@ -49,9 +89,17 @@ func NewHandler(b Brahms, bufn int, to time.Duration) *Handler {
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxBody)
+	}
+
 	switch r.URL.Path {
 	case "/push":
 		defer r.Body.Close()
+		if h.pushes != nil && !h.pushes.allow(source(r)) {
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
 
 		pr := new(MsgPushReq)
 		err := h.dec(r.Body).Decode(pr)
@@ -60,13 +108,24 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		h.brahms.ReceiveNode(brahms.Node{IP: pr.IP, Port: pr.Port})
+		// over tls, peers can only push the node info that holds the key they
+		// presented in the handshake
+		n := pr.Node()
+		if !h.fromPeer(r, n) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		if !h.brahms.ReceiveNode(n, brahms.Stamp{Epoch: pr.Epoch, Nonce: pr.Nonce}) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
 
 	case "/pull":
 		view := h.brahms.ReadView()
 		resp := make(MsgPullResp, 0, len(view))
 		for _, n := range view {
-			resp = append(resp, MsgNode{n.IP, n.Port})
+			resp = append(resp, NewMsgNode(n))
 		}
 
 		err := h.enc(w).Encode(resp)
@@ -78,7 +137,58 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 
 	case "/probe":
-		err := h.enc(w).Encode(&MsgProbeResp{Active: h.brahms.IsActive()})
+		defer r.Body.Close()
+
+		// the body is optional, it is only send if we're suspected
+		pr := new(MsgProbeReq)
+		err := h.dec(r.Body).Decode(pr)
+		if err != nil && err != io.EOF {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		active := h.brahms.IsActive()
+		if active && pr.Suspect {
+			h.brahms.Refute(pr.Inc)
+		}
+
+		err = h.enc(w).Encode(&MsgProbeResp{Active: active})
+		if err != nil {
+			http.Error(w,
+				http.StatusText(http.StatusInternalServerError),
+				http.StatusInternalServerError)
+			return
+		}
+
+	case "/probe-req":
+		defer r.Body.Close()
+		pr := new(MsgProbeReq)
+		err := h.dec(r.Body).Decode(pr)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		// we only probe on behalf of peers we know, such that strangers can't
+		// use us to send probes to arbitrary addresses
+		if !h.fromMember(r) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		// an inactive node shouldn't vouch for others
+		ok, target := false, pr.Node()
+		if h.brahms.IsActive() {
+			ctx, cancel := context.WithTimeout(r.Context(), h.to)
+			defer cancel()
+			if pr.Suspect {
+				ctx = brahms.WithSuspicion(ctx, pr.Inc)
+			}
+
+			ok = h.brahms.ProbeNode(ctx, target)
+		}
+
+		err = h.enc(w).Encode(&MsgProbeResp{Active: ok})
 		if err != nil {
 			http.Error(w,
 				http.StatusText(http.StatusInternalServerError),
@@ -107,7 +217,124 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+	case "/gossip", "/ihave", "/graft", "/prune":
+		defer r.Body.Close()
+		if h.tree == nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		h.serveTree(w, r)
+
+	case "/snow/query":
+		defer r.Body.Close()
+		if h.snow == nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+
+		q := new(MsgSnowQuery)
+		err := h.dec(r.Body).Decode(q)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		c, err := h.snow.HandleQuery(r.Context(), q.Topic, q.Choice)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		err = h.enc(w).Encode(&MsgSnowResp{Choice: c})
+		if err != nil {
+			http.Error(w,
+				http.StatusText(http.StatusInternalServerError),
+				http.StatusInternalServerError)
+			return
+		}
+
 	default:
 		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 	}
 }
+
+// serveTree hands the message of a broadcast tree to the tree handler
+func (h *Handler) serveTree(w http.ResponseWriter, r *http.Request) {
+	var n brahms.Node
+	var err error
+	switch r.URL.Path {
+	case "/gossip":
+		gr := new(MsgGossipReq)
+		if err = h.dec(r.Body).Decode(gr); err != nil {
+			break
+		}
+
+		n = gr.Node()
+		if !h.fromPeer(r, n) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		err = h.tree.HandleGossip(r.Context(), n, gr.Msg)
+	default:
+		tr := new(MsgTreeReq)
+		if err = h.dec(r.Body).Decode(tr); err != nil {
+			break
+		}
+
+		n = tr.Node()
+		if !h.fromPeer(r, n) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/ihave":
+			err = h.tree.HandleIHave(r.Context(), n, tr.IDs)
+		case "/graft":
+			err = h.tree.HandleGraft(r.Context(), n, tr.IDs)
+		case "/prune":
+			err = h.tree.HandlePrune(r.Context(), n)
+		}
+	}
+
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+}
+
+// fromPeer returns whether the request could come from the node, over tls
+// peers can only send as the node that holds the key they presented in the
+// handshake.
+func (h *Handler) fromPeer(r *http.Request, n brahms.Node) bool {
+	return r.TLS == nil || (n.Key != nil && VerifyPeer(r.TLS, n) == nil)
+}
+
+// fromMember returns whether the request comes from a node in our view or
+// sample. Over tls the peer must hold the key of the node, otherwise the
+// request must come from the node's address.
+func (h *Handler) fromMember(r *http.Request) bool {
+	src := net.ParseIP(source(r))
+	for _, n := range (brahms.View{}).Concat(h.brahms.ReadView(), h.brahms.Sample()) {
+		switch {
+		case r.TLS != nil && n.Key != nil && VerifyPeer(r.TLS, n) == nil:
+			return true
+		case r.TLS == nil && n.IP.Equal(src):
+			return true
+		}
+	}
+
+	return false
+}
+
+// source returns the address a request came from, without the port
+func source(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
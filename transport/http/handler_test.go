@@ -1,6 +1,7 @@
 package httpt_test
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -20,12 +21,39 @@ import (
 
 type mockBrahms struct {
 	inactive bool
+	view     brahms.View
+	sample   brahms.View
 	pushes   []brahms.Node
+	stamps   []brahms.Stamp
+	probes   []brahms.Node
+	refutes  []uint64
 }
 
-func (b *mockBrahms) IsActive() bool                { return !b.inactive }
-func (b *mockBrahms) ReceiveNode(other brahms.Node) { b.pushes = append(b.pushes, other) }
-func (b *mockBrahms) ReadView() brahms.View         { return brahms.NewView(brahms.N("127.0.0.1", 8080)) }
+func (b *mockBrahms) Refute(inc uint64) { b.refutes = append(b.refutes, inc) }
+
+func (b *mockBrahms) ProbeNode(ctx context.Context, n brahms.Node) bool {
+	b.probes = append(b.probes, n)
+	return n.Port != 0
+}
+
+func (b *mockBrahms) IsActive() bool      { return !b.inactive }
+func (b *mockBrahms) Sample() brahms.View { return b.sample }
+func (b *mockBrahms) ReadView() brahms.View {
+	if b.view != nil {
+		return b.view
+	}
+
+	return brahms.NewView(brahms.N("127.0.0.1", 8080))
+}
+func (b *mockBrahms) ReceiveNode(other brahms.Node, st brahms.Stamp) bool {
+	if st.Nonce == 0 {
+		return false //treat the zero nonce as missing work
+	}
+
+	b.pushes = append(b.pushes, other)
+	b.stamps = append(b.stamps, st)
+	return true
+}
 
 func TestPushPullProbeEmit(t *testing.T) {
 	b := &mockBrahms{}
@@ -57,6 +85,53 @@ func TestPushPullProbeEmit(t *testing.T) {
 		b.inactive = true
 		test.Equals(t, false, f().Active)
 		b.inactive = false
+
+		t.Run("suspected", func(t *testing.T) {
+			r, err := http.Post(s.URL+"/probe", "", strings.NewReader(`{`))
+			test.Ok(t, err)
+			test.Equals(t, http.StatusBadRequest, r.StatusCode)
+
+			r, err = http.Post(s.URL+"/probe", "", strings.NewReader(`{"suspect": true, "inc": 5}`))
+			test.Ok(t, err)
+			test.Equals(t, http.StatusOK, r.StatusCode)
+			test.Equals(t, []uint64{5}, b.refutes)
+		})
+	})
+
+	t.Run("probe-req", func(t *testing.T) {
+		r, err := http.Post(s.URL+"/probe-req", "", nil)
+		test.Ok(t, err)
+		test.Equals(t, http.StatusBadRequest, r.StatusCode)
+
+		// nodes are only probed on behalf of peers in the view or sample
+		b.view, b.sample = brahms.NewView(brahms.N("10.0.0.1", 1)), brahms.NewView(brahms.N("10.0.0.2", 11000))
+		r, err = http.Post(s.URL+"/probe-req", "", strings.NewReader(`{"ip": "10.0.0.2", "port": 11000}`))
+		test.Ok(t, err)
+		test.Equals(t, http.StatusForbidden, r.StatusCode)
+		test.Equals(t, 0, len(b.probes))
+		b.view, b.sample = nil, brahms.NewView(brahms.N("127.0.0.1", 11000))
+
+		f := func(body string) *httpt.MsgProbeResp {
+			r, err := http.Post(s.URL+"/probe-req", "", strings.NewReader(body))
+			test.Ok(t, err)
+			defer r.Body.Close()
+			test.Equals(t, http.StatusOK, r.StatusCode)
+			probe := new(httpt.MsgProbeResp)
+			test.Ok(t, json.NewDecoder(r.Body).Decode(probe))
+			return probe
+		}
+
+		// the node needn't be in our own sample, peers rarely share samples
+		test.Equals(t, true, f(`{"ip": "10.0.0.1", "port": 11000}`).Active)
+		test.Equals(t, false, f(`{"ip": "127.0.0.1", "port": 0}`).Active)
+		test.Equals(t, 2, len(b.probes))
+		test.Equals(t, uint16(11000), b.probes[0].Port)
+
+		// an inactive node should not vouch for others
+		b.inactive = true
+		test.Equals(t, false, f(`{"ip": "127.0.0.1", "port": 11000}`).Active)
+		test.Equals(t, 2, len(b.probes))
+		b.inactive = false
 	})
 
 	t.Run("push", func(t *testing.T) {
@@ -64,14 +139,23 @@ func TestPushPullProbeEmit(t *testing.T) {
 		test.Ok(t, err)
 		test.Equals(t, http.StatusBadRequest, r.StatusCode)
 
-		t.Run("valid push", func(t *testing.T) {
+		t.Run("push without work", func(t *testing.T) {
 			r, err := http.Post(s.URL+"/push", "", strings.NewReader(`{"ip": "127.0.0.1", "port": 11000}`))
 			test.Ok(t, err)
+			test.Equals(t, http.StatusForbidden, r.StatusCode)
+			test.Equals(t, 0, len(b.pushes))
+		})
+
+		t.Run("valid push", func(t *testing.T) {
+			r, err := http.Post(s.URL+"/push", "", strings.NewReader(`{"ip": "127.0.0.1", "port": 11000, "inc": 7, "epoch": 5, "nonce": 42}`))
+			test.Ok(t, err)
 			test.Equals(t, http.StatusOK, r.StatusCode)
 
 			test.Equals(t, 1, len(b.pushes))
 			test.Equals(t, net.ParseIP("127.0.0.1"), b.pushes[0].IP)
 			test.Equals(t, uint16(11000), b.pushes[0].Port)
+			test.Equals(t, uint64(7), b.pushes[0].Inc)
+			test.Equals(t, brahms.Stamp{Epoch: 5, Nonce: 42}, b.stamps[0])
 		})
 	})
 
@@ -120,7 +204,7 @@ type encoderFunc func(v interface{}) error
 func (f encoderFunc) Encode(v interface{}) error { return f(v) }
 
 func TestEncodingErrors(t *testing.T) {
-	b := &mockBrahms{}
+	b := &mockBrahms{sample: brahms.NewView(&brahms.Node{Port: 1})}
 	s := httptest.NewServer(httpt.NewHandlerWithEncoding(b, 0, time.Second,
 		func(w io.Writer) httpt.Encoder {
 			return encoderFunc(func(v interface{}) error {
@@ -144,9 +228,64 @@ func TestEncodingErrors(t *testing.T) {
 		test.Equals(t, http.StatusInternalServerError, r.StatusCode)
 	})
 
+	t.Run("probe-req", func(t *testing.T) {
+		r, err := http.Post(s.URL+"/probe-req", "", strings.NewReader(`{"port": 1}`))
+		test.Ok(t, err)
+		test.Equals(t, http.StatusInternalServerError, r.StatusCode)
+	})
+
 	t.Run("emit", func(t *testing.T) {
 		r, err := http.Post(s.URL+"/emit", "", nil)
 		test.Ok(t, err)
 		test.Equals(t, http.StatusBadRequest, r.StatusCode)
 	})
 }
+
+func TestHandlerBodyLimit(t *testing.T) {
+	b := &mockBrahms{}
+	h := httpt.NewHandler(b, 1, time.Second)
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	body := `{"data": "` + base64.StdEncoding.EncodeToString(make([]byte, 64)) + `"}`
+	r, err := http.Post(s.URL+"/emit", "", strings.NewReader(body))
+	test.Ok(t, err)
+	test.Equals(t, http.StatusOK, r.StatusCode)
+	<-h.C
+
+	h.SetMaxBodySize(int64(len(body) - 1))
+	r, err = http.Post(s.URL+"/emit", "", strings.NewReader(body))
+	test.Ok(t, err)
+	test.Equals(t, http.StatusBadRequest, r.StatusCode)
+}
+
+func TestHandlerPushRate(t *testing.T) {
+	b := &mockBrahms{}
+	h := httpt.NewHandler(b, 0, time.Second)
+	h.SetPushRate(0.001, 5)
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	// many fake ids pushed from a single address share its limit
+	push := func(i int) int {
+		data, _ := json.Marshal(httpt.MsgPushReq{MsgNode: httpt.MsgNode{IP: net.ParseIP("10.0.0.1"), Port: uint16(i + 1)}, Nonce: 1})
+		r, err := http.Post(s.URL+"/push", "", strings.NewReader(string(data)))
+		test.Ok(t, err)
+		return r.StatusCode
+	}
+
+	for i := 0; i < 5; i++ {
+		test.Equals(t, http.StatusOK, push(i))
+	}
+
+	test.Equals(t, http.StatusTooManyRequests, push(5))
+	test.Equals(t, 5, len(b.pushes))
+
+	// other requests are not limited, and the limit can be lifted
+	r, err := http.Post(s.URL+"/probe", "", nil)
+	test.Ok(t, err)
+	test.Equals(t, http.StatusOK, r.StatusCode)
+
+	h.SetPushRate(0, 0)
+	test.Equals(t, http.StatusOK, push(6))
+}
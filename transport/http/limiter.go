@@ -0,0 +1,70 @@
+package httpt
+
+import (
+	"sync"
+	"time"
+)
+
+// maxSources is the nr of sources the limiter keeps track of before it forgets
+// the ones that are no longer limited
+var maxSources = 10000
+
+// bucket holds the tokens of a single source
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter limits the rate of requests per source with a token bucket, each
+// source can do burst requests at once and earns rate tokens per second.
+type limiter struct {
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	now     func() time.Time
+	mu      sync.Mutex
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+	return &limiter{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// allow returns whether the source can do another request and takes a token
+func (l *limiter) allow(src string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[src]
+	if !ok {
+		if len(l.buckets) >= maxSources {
+			l.sweep(now)
+		}
+
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[src] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// sweep forgets sources that earned back all their tokens, they would start
+// with a full bucket anyway.
+func (l *limiter) sweep(now time.Time) {
+	for src, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, src)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package httpt
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/advanderveer/go-test"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	l := newLimiter(2, 2)
+	l.now = func() time.Time { return now }
+
+	test.Equals(t, true, l.allow("a"))
+	test.Equals(t, true, l.allow("a"))
+	test.Equals(t, false, l.allow("a"))
+	test.Equals(t, true, l.allow("b"))
+
+	// tokens are earned back over time, up to the burst
+	now = now.Add(time.Millisecond * 500)
+	test.Equals(t, true, l.allow("a"))
+	test.Equals(t, false, l.allow("a"))
+	now = now.Add(time.Hour)
+	test.Equals(t, true, l.allow("a"))
+	test.Equals(t, true, l.allow("a"))
+	test.Equals(t, false, l.allow("a"))
+
+	// sources that earned back their tokens are forgotten when there are many
+	defer func(n int) { maxSources = n }(maxSources)
+	maxSources = 10
+	for i := 0; i < 10; i++ {
+		l.allow(strconv.Itoa(i))
+	}
+
+	now = now.Add(time.Hour)
+	l.allow("c")
+	test.Equals(t, 1, len(l.buckets))
+}
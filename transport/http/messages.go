@@ -1,17 +1,40 @@
 package httpt
 
 import (
+	"crypto/ed25519"
 	"net"
+
+	"github.com/advanderveer/brahms"
+	"github.com/advanderveer/brahms/broadcast"
+	"github.com/advanderveer/brahms/snow"
 )
 
 // MsgNode transports node information
 type MsgNode struct {
-	IP   net.IP `json:"ip"`
-	Port uint16 `json:"port"`
+	IP   net.IP            `json:"ip"`
+	Port uint16            `json:"port"`
+	Key  ed25519.PublicKey `json:"key,omitempty"`
+	Inc  uint64            `json:"inc,omitempty"`
+	Meta map[string]string `json:"meta,omitempty"`
+	Ver  uint64            `json:"ver,omitempty"`
+}
+
+// NewMsgNode describes the node for transport
+func NewMsgNode(n brahms.Node) MsgNode {
+	return MsgNode{IP: n.IP, Port: n.Port, Key: n.Key, Inc: n.Inc, Meta: n.Meta, Ver: n.Ver}
+}
+
+// Node returns the transported node
+func (m MsgNode) Node() brahms.Node {
+	return brahms.Node{IP: m.IP, Port: m.Port, Key: m.Key, Inc: m.Inc, Meta: m.Meta, Ver: m.Ver}
 }
 
-// MsgPushReq pushes information of a single node
-type MsgPushReq struct{ MsgNode }
+// MsgPushReq pushes information of a single node with a proof of work
+type MsgPushReq struct {
+	MsgNode
+	Epoch int64  `json:"epoch"`
+	Nonce uint64 `json:"nonce"`
+}
 
 // MsgPullResp returns a list of nodes info
 type MsgPullResp []MsgNode
@@ -21,7 +44,39 @@ type MsgProbeResp struct {
 	Active bool `json:"active"`
 }
 
+// MsgProbeReq requests a peer to probe a node, either itself or on our behalf.
+// If the node is suspected of being dead it can refute it.
+type MsgProbeReq struct {
+	MsgNode
+	Suspect bool `json:"suspect,omitempty"`
+}
+
 // MsgEmitReq requests a peer to emit data
 type MsgEmitReq struct {
 	Data []byte `json:"data"`
 }
+
+// MsgGossipReq eagerly pushes a message of a broadcast tree
+type MsgGossipReq struct {
+	MsgNode
+	Msg broadcast.Msg `json:"msg"`
+}
+
+// MsgTreeReq announces (ihave) or requests (graft) messages of a broadcast
+// tree by their ids, or prunes us from the tree (without ids).
+type MsgTreeReq struct {
+	MsgNode
+	IDs []broadcast.MID `json:"ids,omitempty"`
+}
+
+// MsgSnowQuery queries a peer for its preferred choice on a topic, it adopts
+// our choice if it has none.
+type MsgSnowQuery struct {
+	Topic  string   `json:"topic"`
+	Choice snow.CID `json:"choice"`
+}
+
+// MsgSnowResp returns the preferred choice of a peer
+type MsgSnowResp struct {
+	Choice snow.CID `json:"choice"`
+}
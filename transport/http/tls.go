@@ -0,0 +1,110 @@
+package httpt
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/advanderveer/brahms"
+)
+
+var (
+	// ErrNoPeerCertificate is returned when a peer didn't present a certificate
+	ErrNoPeerCertificate = errors.New("peer presented no certificate")
+
+	// ErrPeerKeyMismatch is returned when a peer presented a key that doesn't
+	// match the key it is known with.
+	ErrPeerKeyMismatch = errors.New("peer key doesn't match its node id")
+
+	// ErrNoNodeKey is returned when a peer is verified against a node that
+	// has no key to verify it with.
+	ErrNoNodeKey = errors.New("node has no key to verify")
+)
+
+// Certificate creates a self-signed tls certificate for the provided private
+// key. Peers don't verify the certificate chain but pin the public key in it
+// to the node info that is gossiped.
+func Certificate(key ed25519.PrivateKey) (cert tls.Certificate, err error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return cert, err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour * 24 * 365 * 10),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return cert, err
+	}
+
+	cert.Certificate = [][]byte{der}
+	cert.PrivateKey = key
+	return
+}
+
+// ServerTLS returns the tls configuration for serving peers. Client
+// certificates are requested but only required for pushes, which are
+// verified by the handler.
+func ServerTLS(cert tls.Certificate) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequestClientCert,
+		MinVersion:   tls.VersionTLS13,
+	}
+}
+
+// ClientTLS returns the tls configuration for requesting peers. The chain of
+// the server's certificate is not verified, instead its key is compared with
+// the key of the node that is requested.
+func ClientTLS(cert tls.Certificate) *tls.Config {
+	return &tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS13,
+	}
+}
+
+// PeerKey returns the public key a peer presented in the tls handshake
+func PeerKey(cs *tls.ConnectionState) (key ed25519.PublicKey, err error) {
+	if cs == nil || len(cs.PeerCertificates) < 1 {
+		return nil, ErrNoPeerCertificate
+	}
+
+	key, ok := cs.PeerCertificates[0].PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return nil, ErrPeerKeyMismatch
+	}
+
+	return
+}
+
+// VerifyPeer checks that the peer on the other end of the connection holds the
+// key of the provided node. Nodes without a key (e.g. a bootstrap address) can
+// not be verified and are never accepted.
+func VerifyPeer(cs *tls.ConnectionState, n brahms.Node) (err error) {
+	key, err := PeerKey(cs)
+	if err != nil {
+		return err
+	}
+
+	if n.Key == nil {
+		return ErrNoNodeKey
+	}
+
+	if !bytes.Equal(key, n.Key) {
+		return ErrPeerKeyMismatch
+	}
+
+	return nil
+}
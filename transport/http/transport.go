@@ -3,6 +3,7 @@ package httpt
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"io"
@@ -11,23 +12,57 @@ import (
 	"strconv"
 
 	"github.com/advanderveer/brahms"
+	"github.com/advanderveer/brahms/broadcast"
+	"github.com/advanderveer/brahms/snow"
 )
 
+// DefaultMaxBodySize limits the size of request and response bodies, it fits
+// the pull responses of very large views.
+const DefaultMaxBodySize = 1 << 20
+
 // Transport is a transport that uses an http client
 type Transport struct {
-	client *http.Client
-	logs   *log.Logger
+	client  *http.Client
+	logs    *log.Logger
+	secure  bool
+	maxBody int64
 }
 
 // New initializes the transport
 func New(logw io.Writer) (tr *Transport) {
-	tr = &Transport{client: &http.Client{}, logs: log.New(logw, "httpt/transport: ", 0)}
+	tr = &Transport{client: &http.Client{}, logs: log.New(logw, "httpt/transport: ", 0), maxBody: DefaultMaxBodySize}
+	return
+}
+
+// SetMaxBodySize configures the nr of bytes of a response that are read at
+// most, larger responses fail to decode.
+func (tr *Transport) SetMaxBodySize(n int64) {
+	tr.maxBody = n
+}
+
+// NewTLS initializes a transport that talks to peers over mutually
+// authenticated tls. Peers must present the key they are known with.
+func NewTLS(logw io.Writer, cert tls.Certificate) (tr *Transport) {
+	tr = New(logw)
+	tr.client.Transport = &http.Transport{TLSClientConfig: ClientTLS(cert)}
+	tr.secure = true
 	return
 }
 
 //Request performs a http request on the provided node an decodes the response into msg
 func (tr *Transport) Request(ctx context.Context, method string, n brahms.Node, path string, body io.Reader, msg interface{}) (err error) {
-	loc := "http://" + n.IP.String() + ":" + strconv.Itoa(int(n.Port)) + path
+	return tr.request(ctx, method, n, path, body, msg, true)
+}
+
+// request performs the request, over tls the peer is only verified if verify
+// is true.
+func (tr *Transport) request(ctx context.Context, method string, n brahms.Node, path string, body io.Reader, msg interface{}, verify bool) (err error) {
+	scheme := "http://"
+	if tr.secure {
+		scheme = "https://"
+	}
+
+	loc := scheme + n.IP.String() + ":" + strconv.Itoa(int(n.Port)) + path
 	req, err := http.NewRequest(method, loc, body)
 	if err != nil {
 		return TransportErr{err, "request_creation"}
@@ -39,13 +74,20 @@ func (tr *Transport) Request(ctx context.Context, method string, n brahms.Node,
 		return TransportErr{err, "request_execution"}
 	}
 
+	defer resp.Body.Close()
+	if tr.secure && verify {
+		err = VerifyPeer(resp.TLS, n)
+		if err != nil {
+			return TransportErr{err, "peer_verification"}
+		}
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		return TransportErr{errors.New("expected status OK"), "response_status"}
 	}
 
 	if msg != nil {
-		defer resp.Body.Close()
-		dec := json.NewDecoder(resp.Body)
+		dec := json.NewDecoder(io.LimitReader(resp.Body, tr.maxBody))
 		err = dec.Decode(msg)
 		if err != nil {
 			return TransportErr{err, "response_decoding"}
@@ -67,39 +109,139 @@ func (tr *Transport) RequestOrLog(ctx context.Context, method string, n brahms.N
 }
 
 // Push implements node information pushing
-func (tr *Transport) Push(ctx context.Context, self brahms.Node, to brahms.Node) {
-	msg := MsgNode{IP: self.IP, Port: self.Port}
-	data, _ := json.Marshal(MsgPushReq{msg})
-	tr.RequestOrLog(ctx, http.MethodPost, to, "/push", bytes.NewReader(data), nil)
+func (tr *Transport) Push(ctx context.Context, self brahms.Node, st brahms.Stamp, to brahms.Node) error {
+	data, _ := json.Marshal(MsgPushReq{NewMsgNode(self), st.Epoch, st.Nonce})
+	return tr.Request(ctx, http.MethodPost, to, "/push", bytes.NewReader(data), nil)
 }
 
-// Pull impelents node information pulling
-func (tr *Transport) Pull(ctx context.Context, c chan<- brahms.View, from brahms.Node) {
+// Pull impelents node information pulling. Over tls, nodes without a key (e.g.
+// bootstrap addresses) can't be verified but can still be pulled from, such
+// that a network can be joined with only an address.
+func (tr *Transport) Pull(ctx context.Context, from brahms.Node) (v brahms.View, err error) {
 	var msg MsgPullResp
-	tr.RequestOrLog(ctx, http.MethodGet, from, "/pull", nil, &msg)
+	err = tr.request(ctx, http.MethodGet, from, "/pull", nil, &msg, from.Key != nil)
+	if err != nil {
+		return nil, err
+	}
 
-	v := make(brahms.View)
+	var rejected int
+	v = make(brahms.View)
 	for _, m := range msg {
-		n := brahms.Node{IP: m.IP, Port: m.Port}
-		v[n.Hash()] = n
+		if tr.secure && m.Key == nil {
+			continue //over tls only nodes that can identify themselves are gossiped
+		}
+
+		n := m.Node()
+		id := n.Hash()
+		if _, ok := v[id]; ok {
+			rejected++
+			continue //honest peers never send duplicates
+		}
+
+		v[id] = n
+	}
+
+	if rejected > 0 {
+		return v, brahms.PullRejectedErr{Rejected: rejected}
+	}
+
+	return
+}
+
+// Probe implements node status probing. Over tls, nodes without a key (e.g.
+// bootstrap addresses) can be pulled from but never pass a probe. Such that
+// they are evicted from the sample in favour of their identified counterpart.
+func (tr *Transport) Probe(ctx context.Context, n brahms.Node) (err error) {
+	if tr.secure && n.Key == nil {
+		return TransportErr{ErrNoNodeKey, "peer_verification"}
+	}
+
+	// only if the node is suspected do we send the probe request
+	var body io.Reader
+	if _, ok := brahms.Suspicion(ctx); ok {
+		data, _ := json.Marshal(probeReq(ctx, n))
+		body = bytes.NewReader(data)
 	}
 
-	c <- v
+	msg := new(MsgProbeResp)
+	err = tr.Request(ctx, http.MethodPost, n, "/probe", body, msg)
+	if err != nil {
+		return err
+	}
+
+	if !msg.Active {
+		return brahms.ErrRefused
+	}
+
+	return nil
 }
 
-// Probe implements node status probing
-func (tr *Transport) Probe(ctx context.Context, c chan<- brahms.NID, id brahms.NID, n brahms.Node) {
+// ProbeReq implements indirect node status probing, by asking another peer to
+// probe the node.
+func (tr *Transport) ProbeReq(ctx context.Context, via brahms.Node, n brahms.Node) (err error) {
+	data, _ := json.Marshal(probeReq(ctx, n))
 	msg := new(MsgProbeResp)
-	tr.RequestOrLog(ctx, http.MethodGet, n, "/probe", nil, msg)
-	if msg.Active {
-		c <- id
+	err = tr.Request(ctx, http.MethodPost, via, "/probe-req", bytes.NewReader(data), msg)
+	if err != nil {
+		return err
 	}
+
+	if !msg.Active {
+		return brahms.ErrRefused
+	}
+
+	return nil
+}
+
+// probeReq describes a probe of node n, with the incarnation it is suspected
+// at if the context carries a suspicion.
+func probeReq(ctx context.Context, n brahms.Node) (pr MsgProbeReq) {
+	pr.MsgNode = NewMsgNode(n)
+	if inc, ok := brahms.Suspicion(ctx); ok {
+		pr.Suspect, pr.Inc = true, inc
+	}
+
+	return
 }
 
 // Emit implements custom message emitting
-func (tr *Transport) Emit(ctx context.Context, c chan<- brahms.NID, id brahms.NID, msg []byte, to brahms.Node) {
+func (tr *Transport) Emit(ctx context.Context, msg []byte, to brahms.Node) error {
 	data, _ := json.Marshal(MsgEmitReq{Data: msg})
-	if tr.RequestOrLog(ctx, http.MethodPost, to, "/emit", bytes.NewReader(data), nil) {
-		c <- id
+	return tr.Request(ctx, http.MethodPost, to, "/emit", bytes.NewReader(data), nil)
+}
+
+// Gossip implements an eager push of a broadcast tree
+func (tr *Transport) Gossip(ctx context.Context, self brahms.Node, m broadcast.Msg, to brahms.Node) error {
+	data, _ := json.Marshal(MsgGossipReq{NewMsgNode(self), m})
+	return tr.Request(ctx, http.MethodPost, to, "/gossip", bytes.NewReader(data), nil)
+}
+
+// IHave implements a lazy push of a broadcast tree
+func (tr *Transport) IHave(ctx context.Context, self brahms.Node, ids []broadcast.MID, to brahms.Node) error {
+	data, _ := json.Marshal(MsgTreeReq{NewMsgNode(self), ids})
+	return tr.Request(ctx, http.MethodPost, to, "/ihave", bytes.NewReader(data), nil)
+}
+
+// Graft implements the repair of a broadcast tree
+func (tr *Transport) Graft(ctx context.Context, self brahms.Node, ids []broadcast.MID, to brahms.Node) error {
+	data, _ := json.Marshal(MsgTreeReq{NewMsgNode(self), ids})
+	return tr.Request(ctx, http.MethodPost, to, "/graft", bytes.NewReader(data), nil)
+}
+
+// Prune implements the pruning of a broadcast tree
+func (tr *Transport) Prune(ctx context.Context, self brahms.Node, to brahms.Node) error {
+	data, _ := json.Marshal(MsgTreeReq{MsgNode: NewMsgNode(self)})
+	return tr.Request(ctx, http.MethodPost, to, "/prune", bytes.NewReader(data), nil)
+}
+
+// Query implements a consensus query
+func (tr *Transport) Query(ctx context.Context, topic string, c snow.CID, to brahms.Node) (snow.CID, error) {
+	data, _ := json.Marshal(MsgSnowQuery{Topic: topic, Choice: c})
+	msg := new(MsgSnowResp)
+	err := tr.Request(ctx, http.MethodPost, to, "/snow/query", bytes.NewReader(data), msg)
+	if err != nil {
+		return snow.NilC, err
 	}
+
+	return msg.Choice, nil
 }
@@ -3,7 +3,12 @@ package httpt_test
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
 	"net"
+	"net/http"
 	"net/http/httptest"
 	"os"
 	"strconv"
@@ -12,6 +17,8 @@ import (
 	"time"
 
 	"github.com/advanderveer/brahms"
+	"github.com/advanderveer/brahms/broadcast"
+	"github.com/advanderveer/brahms/snow"
 	httpt "github.com/advanderveer/brahms/transport/http"
 	"github.com/advanderveer/go-test"
 )
@@ -40,16 +47,27 @@ func TestTransportRequest(t *testing.T) {
 
 		err := tr.Request(ctx, "GET", *brahms.N(host, uint16(port)), "/probe", nil, nil)
 		test.Equals(t, "request_execution", err.(httpt.TransportErr).Op)
+		test.Equals(t, true, errors.Is(err, brahms.ErrTimeout))
+		test.Equals(t, false, errors.Is(err, brahms.ErrRefused))
+	})
+
+	t.Run("request refused", func(t *testing.T) {
+		err := tr.Request(context.Background(), "GET", *brahms.N(host, 0), "/probe", nil, nil)
+		test.Equals(t, "request_execution", err.(httpt.TransportErr).Op)
+		test.Equals(t, true, errors.Is(err, brahms.ErrRefused))
+		test.Equals(t, false, errors.Is(err, brahms.ErrTimeout))
 	})
 
 	t.Run("response status", func(t *testing.T) {
 		err := tr.Request(context.Background(), "GET", *brahms.N(host, uint16(port)), "/def", nil, map[string]interface{}{})
 		test.Equals(t, "response_status", err.(httpt.TransportErr).Op)
+		test.Equals(t, true, errors.Is(err, brahms.ErrBadStatus))
 	})
 
 	t.Run("response decoding", func(t *testing.T) {
-		err := tr.Request(context.Background(), "POST", *brahms.N(host, uint16(port)), "/push", strings.NewReader(`{}`), map[string]interface{}{})
+		err := tr.Request(context.Background(), "POST", *brahms.N(host, uint16(port)), "/push", strings.NewReader(`{"nonce": 1}`), map[string]interface{}{})
 		test.Equals(t, "response_decoding", err.(httpt.TransportErr).Op)
+		test.Equals(t, true, errors.Is(err, brahms.ErrDecode))
 	})
 
 	t.Run("request execution", func(t *testing.T) {
@@ -72,19 +90,41 @@ func TestTransport(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 		defer cancel()
 
-		c := make(chan brahms.NID, 1)
-		tr.Probe(ctx, c, brahms.NID{0x01}, *brahms.N(host, uint16(port)))
-		tr.Push(ctx, *brahms.N("127.0.0.1", 9090), *brahms.N(host, uint16(port)))
-
-		select {
-		case <-time.After(time.Second):
-			t.Fatal("took too long")
-		case i := <-c:
-			test.Equals(t, brahms.NID{0x01}, i)
-			test.Equals(t, 1, len(b.pushes))
-			test.Equals(t, net.ParseIP("127.0.0.1"), b.pushes[0].IP)
-			test.Equals(t, uint16(9090), b.pushes[0].Port)
-		}
+		test.Ok(t, tr.Probe(ctx, *brahms.N(host, uint16(port))))
+		test.Ok(t, tr.Push(ctx, *brahms.N("127.0.0.1", 9090), brahms.Stamp{Epoch: 1, Nonce: 2}, *brahms.N(host, uint16(port))))
+		test.Equals(t, 1, len(b.pushes))
+		test.Equals(t, net.ParseIP("127.0.0.1"), b.pushes[0].IP)
+		test.Equals(t, uint16(9090), b.pushes[0].Port)
+		test.Equals(t, brahms.Stamp{Epoch: 1, Nonce: 2}, b.stamps[0])
+	})
+
+	t.Run("push meta", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		n := *brahms.N("127.0.0.1", 9091)
+		n.Meta, n.Ver = brahms.Meta{"role": "db"}, 3
+		test.Ok(t, tr.Push(ctx, n, brahms.Stamp{Epoch: 1, Nonce: 2}, *brahms.N(host, uint16(port))))
+		test.Equals(t, brahms.Meta{"role": "db"}, b.pushes[len(b.pushes)-1].Meta)
+		test.Equals(t, uint64(3), b.pushes[len(b.pushes)-1].Ver)
+	})
+
+	t.Run("probe suspected", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		test.Ok(t, tr.Probe(brahms.WithSuspicion(ctx, 3), *brahms.N(host, uint16(port))))
+		test.Equals(t, []uint64{3}, b.refutes)
+	})
+
+	t.Run("probe-req", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		test.Ok(t, tr.ProbeReq(ctx, *brahms.N(host, uint16(port)), *brahms.N("127.0.0.1", 9090)))
+		err := tr.ProbeReq(ctx, *brahms.N(host, uint16(port)), *brahms.N("127.0.0.1", 0))
+		test.Equals(t, brahms.ErrRefused, err)
+		test.Equals(t, uint16(9090), b.probes[0].Port)
 	})
 
 	t.Run("emit", func(t *testing.T) {
@@ -96,34 +136,234 @@ func TestTransport(t *testing.T) {
 			}
 		}()
 
-		c := make(chan brahms.NID, 1)
-		tr.Emit(ctx, c, brahms.NID{0x01}, []byte("foo"), *brahms.N(host, uint16(port)))
-
-		select {
-		case <-time.After(time.Second):
-			t.Fatal("took too long")
-		case i := <-c:
-			test.Equals(t, brahms.NID{0x01}, i)
-		}
+		test.Ok(t, tr.Emit(ctx, []byte("foo"), *brahms.N(host, uint16(port))))
 	})
 
 	t.Run("pull", func(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 		defer cancel()
 
-		c := make(chan brahms.View, 1)
-		tr.Pull(ctx, c, *brahms.N(host, uint16(port)))
-
-		select {
-		case <-time.After(time.Second):
-			t.Fatal("took too long")
-		case v := <-c:
-			test.Equals(t, 1, len(v))
-			for _, n := range v {
-				test.Equals(t, "127.0.0.1", n.IP.String())
-				test.Equals(t, uint16(8080), n.Port)
-			}
+		v, err := tr.Pull(ctx, *brahms.N(host, uint16(port)))
+		test.Ok(t, err)
+		test.Equals(t, 1, len(v))
+		for _, n := range v {
+			test.Equals(t, "127.0.0.1", n.IP.String())
+			test.Equals(t, uint16(8080), n.Port)
 		}
 	})
 
 }
+
+func TestTransportTLS(t *testing.T) {
+	_, skey, _ := ed25519.GenerateKey(rand.Reader)
+	scert, err := httpt.Certificate(skey)
+	test.Ok(t, err)
+
+	_, ckey, _ := ed25519.GenerateKey(rand.Reader)
+	ccert, err := httpt.Certificate(ckey)
+	test.Ok(t, err)
+
+	b := &mockBrahms{}
+	s := httptest.NewUnstartedServer(httpt.NewHandler(b, 0, time.Second))
+	s.TLS = httpt.ServerTLS(scert)
+	s.StartTLS()
+	defer s.Close()
+
+	host, ports, _ := net.SplitHostPort(s.Listener.Addr().String())
+	port, _ := strconv.Atoi(ports)
+	server := *brahms.NK(host, uint16(port), skey.Public().(ed25519.PublicKey))
+	self := *brahms.NK("127.0.0.1", 9090, ckey.Public().(ed25519.PublicKey))
+	tr := httpt.NewTLS(os.Stderr, ccert)
+
+	t.Run("pinned key", func(t *testing.T) {
+		err := tr.Request(context.Background(), "GET", server, "/probe", nil, nil)
+		test.Ok(t, err)
+
+		// a key-less node (e.g. a bootstrap address) can't be verified
+		err = tr.Request(context.Background(), "GET", *brahms.N(host, uint16(port)), "/probe", nil, nil)
+		test.Equals(t, httpt.ErrNoNodeKey, errors.Unwrap(err))
+
+		// but not if the server presents another key
+		other := server
+		other.Key = ckey.Public().(ed25519.PublicKey)
+		err = tr.Request(context.Background(), "GET", other, "/probe", nil, nil)
+		test.Equals(t, "peer_verification", err.(httpt.TransportErr).Op)
+	})
+
+	t.Run("probe", func(t *testing.T) {
+		err := tr.Probe(context.Background(), *brahms.N(host, uint16(port)))
+		test.Equals(t, true, errors.Is(err, brahms.ErrRefused)) //key-less nodes never pass a probe
+		test.Ok(t, tr.Probe(context.Background(), server))
+	})
+
+	t.Run("probe-req", func(t *testing.T) {
+		target := *brahms.N("127.0.0.1", 9091)
+
+		// the requester must hold the key of a node in the view or sample
+		err := tr.ProbeReq(context.Background(), server, target)
+		test.Equals(t, "response_status", err.(httpt.TransportErr).Op)
+
+		b.view = brahms.NewView(&self)
+		test.Ok(t, tr.ProbeReq(context.Background(), server, target))
+		b.view = nil
+	})
+
+	t.Run("push", func(t *testing.T) {
+		test.Ok(t, tr.Push(context.Background(), self, brahms.Stamp{Nonce: 1}, server))
+		test.Equals(t, 1, len(b.pushes))
+		test.Equals(t, self.Hash(), b.pushes[0].Hash())
+
+		// cannot push node info with a key we don't hold, nor without key
+		for _, n := range []brahms.Node{server, *brahms.N("127.0.0.1", 9090)} {
+			data, _ := json.Marshal(httpt.MsgPushReq{MsgNode: httpt.MsgNode{IP: n.IP, Port: n.Port, Key: n.Key}, Nonce: 1})
+			err := tr.Request(context.Background(), "POST", server, "/push", bytes.NewReader(data), nil)
+			test.Equals(t, "response_status", err.(httpt.TransportErr).Op)
+		}
+
+		test.Equals(t, 1, len(b.pushes))
+	})
+
+	t.Run("pull", func(t *testing.T) {
+		v, err := tr.Pull(context.Background(), server)
+		test.Ok(t, err)
+		test.Equals(t, 0, len(v)) //mock view holds no keys, should be dropped
+
+		// key-less nodes (e.g. bootstrap addresses) can only be pulled from
+		_, err = tr.Pull(context.Background(), *brahms.N(host, uint16(port)))
+		test.Ok(t, err)
+
+		// a failed pull returns an error
+		other := server
+		other.Key = ckey.Public().(ed25519.PublicKey)
+		_, err = tr.Pull(context.Background(), other)
+		test.Equals(t, true, errors.Is(err, brahms.ErrRefused))
+	})
+}
+
+func TestTransportPullLimits(t *testing.T) {
+	var resp string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(resp))
+	}))
+
+	defer s.Close()
+	host, ports, _ := net.SplitHostPort(s.Listener.Addr().String())
+	port, _ := strconv.Atoi(ports)
+	tr := httpt.New(os.Stderr)
+
+	// duplicate nodes are never send by honest peers, they are rejected while
+	// the rest of the view is kept
+	resp = `[{"ip": "127.0.0.1", "port": 1}, {"ip": "127.0.0.1", "port": 1}, {"ip": "127.0.0.1", "port": 2}]`
+	v, err := tr.Pull(context.Background(), *brahms.N(host, uint16(port)))
+	test.Equals(t, brahms.PullRejectedErr{Rejected: 1}, err)
+	test.Equals(t, 2, len(v))
+
+	// responses beyond the body limit are not read
+	resp = `[{"ip": "127.0.0.1", "port": 1}, {"ip": "127.0.0.1", "port": 2}]`
+	v, err = tr.Pull(context.Background(), *brahms.N(host, uint16(port)))
+	test.Ok(t, err)
+	test.Equals(t, 2, len(v))
+
+	tr.SetMaxBodySize(int64(len(resp) - 1))
+	_, err = tr.Pull(context.Background(), *brahms.N(host, uint16(port)))
+	test.Equals(t, true, errors.Is(err, brahms.ErrDecode))
+}
+
+// mockTree records the broadcast tree messages it handles
+type mockTree struct {
+	calls []string
+	from  []brahms.Node
+	msgs  []broadcast.Msg
+	ids   [][]broadcast.MID
+}
+
+func (mt *mockTree) HandleGossip(ctx context.Context, from brahms.Node, m broadcast.Msg) error {
+	mt.calls, mt.from, mt.msgs = append(mt.calls, "gossip"), append(mt.from, from), append(mt.msgs, m)
+	return nil
+}
+
+func (mt *mockTree) HandleIHave(ctx context.Context, from brahms.Node, ids []broadcast.MID) error {
+	mt.calls, mt.from, mt.ids = append(mt.calls, "ihave"), append(mt.from, from), append(mt.ids, ids)
+	return nil
+}
+
+func (mt *mockTree) HandleGraft(ctx context.Context, from brahms.Node, ids []broadcast.MID) error {
+	mt.calls, mt.from, mt.ids = append(mt.calls, "graft"), append(mt.from, from), append(mt.ids, ids)
+	return nil
+}
+
+func (mt *mockTree) HandlePrune(ctx context.Context, from brahms.Node) error {
+	mt.calls, mt.from = append(mt.calls, "prune"), append(mt.from, from)
+	return nil
+}
+
+func TestTransportTree(t *testing.T) {
+	h := httpt.NewHandler(&mockBrahms{}, 0, time.Second)
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	host, ports, _ := net.SplitHostPort(s.Listener.Addr().String())
+	port, _ := strconv.Atoi(ports)
+	to, self := *brahms.N(host, uint16(port)), *brahms.N("127.0.0.1", 9090)
+	tr := httpt.New(os.Stderr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// without a tree the endpoints are not found
+	test.Assert(t, tr.Prune(ctx, self, to) != nil, "should fail without tree")
+
+	mt := &mockTree{}
+	h.SetTree(mt)
+
+	m := broadcast.Msg{ID: broadcast.MID{0x01}, Hops: 3, Deadline: time.Unix(100, 0).UTC(), Data: []byte("foo")}
+	ids := []broadcast.MID{{0x01}, {0x02}}
+	test.Ok(t, tr.Gossip(ctx, self, m, to))
+	test.Ok(t, tr.IHave(ctx, self, ids, to))
+	test.Ok(t, tr.Graft(ctx, self, ids[:1], to))
+	test.Ok(t, tr.Prune(ctx, self, to))
+
+	test.Equals(t, []string{"gossip", "ihave", "graft", "prune"}, mt.calls)
+	test.Equals(t, []broadcast.Msg{m}, mt.msgs)
+	test.Equals(t, [][]broadcast.MID{ids, ids[:1]}, mt.ids)
+	for _, n := range mt.from {
+		test.Equals(t, uint16(9090), n.Port)
+	}
+}
+
+// mockSnow answers queries with a fixed choice for known topics
+type mockSnow struct{ choice snow.CID }
+
+func (ms *mockSnow) HandleQuery(ctx context.Context, topic string, c snow.CID) (snow.CID, error) {
+	if topic != "foo" {
+		return snow.NilC, snow.ErrInvalidTopic
+	}
+
+	return ms.choice, nil
+}
+
+func TestTransportSnow(t *testing.T) {
+	h := httpt.NewHandler(&mockBrahms{}, 0, time.Second)
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	host, ports, _ := net.SplitHostPort(s.Listener.Addr().String())
+	port, _ := strconv.Atoi(ports)
+	to := *brahms.N(host, uint16(port))
+	tr := httpt.New(os.Stderr)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// without snow the endpoint is not found
+	_, err := tr.Query(ctx, "foo", snow.CID{0x01}, to)
+	test.Assert(t, err != nil, "should fail without snow")
+
+	h.SetSnow(&mockSnow{snow.CID{0x02}})
+	c, err := tr.Query(ctx, "foo", snow.CID{0x01}, to)
+	test.Ok(t, err)
+	test.Equals(t, snow.CID{0x02}, c)
+
+	_, err = tr.Query(ctx, "bar", snow.CID{0x01}, to)
+	test.Assert(t, err != nil, "should fail for an invalid topic")
+}
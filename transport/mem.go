@@ -5,68 +5,273 @@ import (
 	"sync"
 
 	"github.com/advanderveer/brahms"
+	"github.com/advanderveer/brahms/broadcast"
+	"github.com/advanderveer/brahms/snow"
 )
 
+// Peer answers the requests that are sent to a node of the mem network. Cores
+// answer them honestly, adversaries may not.
+type Peer interface {
+	HandleProbe(ctx context.Context) error
+	HandleProbeReq(ctx context.Context, from brahms.Node, n brahms.Node) error
+	HandlePush(ctx context.Context, from brahms.Node, st brahms.Stamp) error
+	HandlePull(ctx context.Context) (brahms.View, error)
+}
+
+// EmitHandler is implemented by peers that accept emitted messages, the mem
+// network refuses emits to peers that don't implement it.
+type EmitHandler interface {
+	HandleEmit(ctx context.Context, msg []byte) error
+}
+
+// WithEmit returns a peer that answers requests with p and hands emitted
+// messages to h.
+func WithEmit(p Peer, h func(ctx context.Context, msg []byte) error) Peer {
+	return emitPeer{p, h}
+}
+
+type emitPeer struct {
+	Peer
+	h func(ctx context.Context, msg []byte) error
+}
+
+func (p emitPeer) HandleEmit(ctx context.Context, msg []byte) error { return p.h(ctx, msg) }
+
+// WithTree returns a peer that answers requests with p and hands the messages
+// of an epidemic broadcast tree to h, the mem network refuses them for peers
+// that don't implement broadcast.TreeHandler.
+func WithTree(p Peer, h broadcast.TreeHandler) Peer {
+	return treePeer{p, h}
+}
+
+type treePeer struct {
+	Peer
+	broadcast.TreeHandler
+}
+
+// WithSnow returns a peer that answers requests with p and hands consensus
+// queries to h, the mem network refuses them for peers that don't implement
+// snow.Handler.
+func WithSnow(p Peer, h snow.Handler) Peer {
+	return snowPeer{p, h}
+}
+
+type snowPeer struct {
+	Peer
+	snow.Handler
+}
+
+// CorePeer returns a peer that answers requests with the core
+func CorePeer(c *brahms.Core) Peer { return corePeer{c} }
+
+type corePeer struct{ c *brahms.Core }
+
+func (p corePeer) HandleProbe(ctx context.Context) error {
+	if !p.c.IsActive() {
+		return brahms.ErrRefused
+	}
+
+	if inc, ok := brahms.Suspicion(ctx); ok {
+		p.c.Refute(inc)
+	}
+
+	return nil
+}
+
+// HandleProbeReq probes the node on behalf of a peer in our view or sample,
+// like the http handler does.
+func (p corePeer) HandleProbeReq(ctx context.Context, from brahms.Node, n brahms.Node) error {
+	if _, ok := (brahms.View{}).Concat(p.c.ReadView(), p.c.Sample())[from.Hash()]; !ok {
+		return brahms.ErrRefused
+	}
+
+	if !p.c.IsActive() || !p.c.ProbeNode(ctx, n) {
+		return brahms.ErrRefused
+	}
+
+	return nil
+}
+
+func (p corePeer) HandlePush(ctx context.Context, from brahms.Node, st brahms.Stamp) error {
+	if !p.c.IsActive() {
+		return brahms.ErrRefused
+	}
+
+	if !p.c.ReceiveNode(from, st) {
+		return brahms.ErrBadStatus
+	}
+
+	return nil
+}
+
+func (p corePeer) HandlePull(ctx context.Context) (brahms.View, error) {
+	if !p.c.IsActive() {
+		return nil, brahms.ErrRefused
+	}
+
+	return p.c.ReadView(), nil
+}
+
 // MemNetTransport is an in-memory transport that allows cores to directly
-// call each others handlers
+// call each others handlers. Nodes without a peer refuse all requests.
 type MemNetTransport struct {
-	cores map[brahms.NID]*brahms.Core
+	peers map[brahms.NID]Peer
 	mu    sync.RWMutex
 }
 
 // NewMemNetTransport inits the new mem transport
 func NewMemNetTransport() *MemNetTransport {
-	return &MemNetTransport{cores: make(map[brahms.NID]*brahms.Core)}
+	return &MemNetTransport{peers: make(map[brahms.NID]Peer)}
 }
 
 // AddCore adds a core to the network
 func (t *MemNetTransport) AddCore(c *brahms.Core) {
+	self := c.Self()
+	t.AddPeer(self, CorePeer(c))
+}
+
+// AddPeer adds a node to the network that answers requests with the peer
+func (t *MemNetTransport) AddPeer(n brahms.Node, p Peer) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-
-	self := c.Self()
-	t.cores[self.Hash()] = c
+	t.peers[n.Hash()] = p
 }
 
-// Probe implements probe
-func (t *MemNetTransport) Probe(ctx context.Context, cc chan<- brahms.NID, id brahms.NID, n brahms.Node) {
+// peer returns the peer of the node or ErrRefused if it is unknown
+func (t *MemNetTransport) peer(n brahms.Node) (p Peer, err error) {
 	t.mu.RLock()
-	c, ok := t.cores[n.Hash()]
+	defer t.mu.RUnlock()
+	p, ok := t.peers[n.Hash()]
 	if !ok {
-		panic("no core known for: " + n.String())
+		return nil, brahms.ErrRefused
 	}
 
-	t.mu.RUnlock()
-	if c.IsActive() {
-		cc <- id
+	return p, nil
+}
+
+// Probe implements probe
+func (t *MemNetTransport) Probe(ctx context.Context, n brahms.Node) error {
+	p, err := t.peer(n)
+	if err != nil {
+		return err
+	}
+
+	return p.HandleProbe(ctx)
+}
+
+// ProbeReq implements an indirect probe, the requester is taken from the
+// context.
+func (t *MemNetTransport) ProbeReq(ctx context.Context, via brahms.Node, n brahms.Node) error {
+	p, err := t.peer(via)
+	if err != nil {
+		return err
 	}
+
+	from, _ := brahms.Requester(ctx)
+	return p.HandleProbeReq(ctx, from, n)
 }
 
 // Push implements a push
-func (t *MemNetTransport) Push(ctx context.Context, self brahms.Node, to brahms.Node) {
-	t.mu.RLock()
-	c, ok := t.cores[to.Hash()]
-	if !ok {
-		panic("no core known for: " + to.String())
+func (t *MemNetTransport) Push(ctx context.Context, self brahms.Node, st brahms.Stamp, to brahms.Node) error {
+	p, err := t.peer(to)
+	if err != nil {
+		return err
 	}
 
-	t.mu.RUnlock()
-	c.ReceiveNode(self)
+	return p.HandlePush(ctx, self, st)
 }
 
 // Pull implements a pull
-func (t *MemNetTransport) Pull(ctx context.Context, cc chan<- brahms.View, from brahms.Node) {
-	t.mu.RLock()
-	c, ok := t.cores[from.Hash()]
-	if !ok {
-		panic("no core known for: " + from.String())
+func (t *MemNetTransport) Pull(ctx context.Context, from brahms.Node) (brahms.View, error) {
+	p, err := t.peer(from)
+	if err != nil {
+		return nil, err
 	}
 
-	t.mu.RUnlock()
-	cc <- c.ReadView()
+	return p.HandlePull(ctx)
 }
 
 // Emit implements the message emit
-func (t *MemNetTransport) Emit(ctx context.Context, c chan<- brahms.NID, id brahms.NID, msg []byte, to brahms.Node) {
-	panic("not implemented")
+func (t *MemNetTransport) Emit(ctx context.Context, msg []byte, to brahms.Node) error {
+	p, err := t.peer(to)
+	if err != nil {
+		return err
+	}
+
+	eh, ok := p.(EmitHandler)
+	if !ok {
+		return brahms.ErrRefused
+	}
+
+	return eh.HandleEmit(ctx, msg)
+}
+
+// tree returns the tree handler of the node or ErrRefused if it has none
+func (t *MemNetTransport) tree(n brahms.Node) (h broadcast.TreeHandler, err error) {
+	p, err := t.peer(n)
+	if err != nil {
+		return nil, err
+	}
+
+	h, ok := p.(broadcast.TreeHandler)
+	if !ok {
+		return nil, brahms.ErrRefused
+	}
+
+	return h, nil
+}
+
+// Gossip implements an eager push of a broadcast tree
+func (t *MemNetTransport) Gossip(ctx context.Context, self brahms.Node, m broadcast.Msg, to brahms.Node) error {
+	h, err := t.tree(to)
+	if err != nil {
+		return err
+	}
+
+	return h.HandleGossip(ctx, self, m)
+}
+
+// IHave implements a lazy push of a broadcast tree
+func (t *MemNetTransport) IHave(ctx context.Context, self brahms.Node, ids []broadcast.MID, to brahms.Node) error {
+	h, err := t.tree(to)
+	if err != nil {
+		return err
+	}
+
+	return h.HandleIHave(ctx, self, ids)
+}
+
+// Graft implements the repair of a broadcast tree
+func (t *MemNetTransport) Graft(ctx context.Context, self brahms.Node, ids []broadcast.MID, to brahms.Node) error {
+	h, err := t.tree(to)
+	if err != nil {
+		return err
+	}
+
+	return h.HandleGraft(ctx, self, ids)
+}
+
+// Prune implements the pruning of a broadcast tree
+func (t *MemNetTransport) Prune(ctx context.Context, self brahms.Node, to brahms.Node) error {
+	h, err := t.tree(to)
+	if err != nil {
+		return err
+	}
+
+	return h.HandlePrune(ctx, self)
+}
+
+// Query implements a consensus query
+func (t *MemNetTransport) Query(ctx context.Context, topic string, c snow.CID, to brahms.Node) (snow.CID, error) {
+	p, err := t.peer(to)
+	if err != nil {
+		return snow.NilC, err
+	}
+
+	h, ok := p.(snow.Handler)
+	if !ok {
+		return snow.NilC, brahms.ErrRefused
+	}
+
+	return h.HandleQuery(ctx, topic, c)
 }
@@ -34,31 +34,37 @@ func (t *MockTransport) DidPush(id brahms.NID) (ok bool) {
 	return
 }
 
-// Probe implements probe
-func (t *MockTransport) Probe(ctx context.Context, c chan<- brahms.NID, id brahms.NID, n brahms.Node) {
-	c <- id
+// Probe implements probe, every peer is alive
+func (t *MockTransport) Probe(ctx context.Context, n brahms.Node) error {
+	return nil
+}
+
+// ProbeReq implements an indirect probe, every peer is alive
+func (t *MockTransport) ProbeReq(ctx context.Context, via brahms.Node, n brahms.Node) error {
+	return nil
 }
 
 // Push implements a push
-func (t *MockTransport) Push(ctx context.Context, self brahms.Node, to brahms.Node) {
+func (t *MockTransport) Push(ctx context.Context, self brahms.Node, st brahms.Stamp, to brahms.Node) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	t.pushed[self.Hash()] = self
+	return nil
 }
 
-// Pull implements a pull
-func (t *MockTransport) Pull(ctx context.Context, c chan<- brahms.View, from brahms.Node) {
+// Pull implements a pull, peers without a mocked response refuse it
+func (t *MockTransport) Pull(ctx context.Context, from brahms.Node) (brahms.View, error) {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 	v, ok := t.pulls[from.Hash()]
 	if !ok {
-		return
+		return nil, brahms.ErrRefused
 	}
 
-	c <- v
+	return v, nil
 }
 
 // Emit implements custom message emit
-func (t *MockTransport) Emit(ctx context.Context, c chan<- brahms.NID, id brahms.NID, msg []byte, to brahms.Node) {
+func (t *MockTransport) Emit(ctx context.Context, msg []byte, to brahms.Node) error {
 	panic("not implemented")
 }
@@ -2,7 +2,9 @@ package transport
 
 import (
 	"context"
+	"math/rand"
 	"testing"
+	"time"
 
 	"github.com/advanderveer/brahms"
 	"github.com/advanderveer/go-test"
@@ -12,39 +14,81 @@ func TestNetCoreTranport(t *testing.T) {
 	n1 := brahms.N("127.0.0.1", 1)
 	n2 := brahms.N("127.0.0.1", 2)
 
+	// unknown nodes refuse every request
 	tr := NewMemNetTransport()
+	test.Equals(t, brahms.ErrRefused, tr.Push(nil, *n1, brahms.Stamp{}, *n2))
+	_, err := tr.Pull(nil, *n2)
+	test.Equals(t, brahms.ErrRefused, err)
+	test.Equals(t, brahms.ErrRefused, tr.Probe(nil, *n2))
+	test.Equals(t, brahms.ErrRefused, tr.ProbeReq(nil, *n1, *n2))
+}
+
+func TestMemNetProbeReq(t *testing.T) {
+	n1 := brahms.N("127.0.0.1", 1)
+	n2 := brahms.N("127.0.0.1", 2)
+	r := rand.New(rand.NewSource(1))
+	p, _ := brahms.NewParams(0.45, 0.45, 0.1, 10, 10, 2, 0)
+
+	n3 := brahms.N("127.0.0.1", 3)
+	tr := NewMemNetTransport()
+	c1 := brahms.NewCore(r, n1, brahms.NewView(n3), p, brahms.AlwaysRefresh, tr, time.Second)
+	tr.AddCore(c1)
+	c2 := brahms.NewCore(r, n2, brahms.NewView(), p, brahms.AlwaysRefresh, tr, time.Second)
+	tr.AddCore(c2)
+
+	// like over http, nodes are only probed on behalf of peers in the view or
+	// sample of the helper, but they needn't be in its sample themselves
+	ctx := brahms.WithRequester(context.Background(), *n3)
+	test.Equals(t, brahms.ErrRefused, tr.ProbeReq(context.Background(), *n1, *n2))
+	test.Equals(t, brahms.ErrRefused, tr.ProbeReq(brahms.WithRequester(context.Background(), *n2), *n1, *n2))
+	test.Ok(t, tr.ProbeReq(ctx, *n1, *n2))
+
+	// if the target is down the probe fails
+	c2.Deactivate()
+	test.Equals(t, brahms.ErrRefused, tr.ProbeReq(ctx, *n1, *n2))
+	_, err := tr.Pull(context.Background(), *n2)
+	test.Equals(t, brahms.ErrRefused, err)
+
+	// if the helper is down it can't vouch for the target
+	c1.Deactivate()
+	test.Equals(t, brahms.ErrRefused, tr.ProbeReq(ctx, *n1, *n1))
+}
 
-	t.Run("push", func(t *testing.T) {
-		defer func() {
-			if r := recover(); r == nil {
-				t.Errorf("The code did not panic")
-			}
-		}()
-		tr.Push(nil, *n1, *n2)
-	})
-
-	t.Run("pull", func(t *testing.T) {
-		defer func() {
-			if r := recover(); r == nil {
-				t.Errorf("The code did not panic")
-			}
-		}()
-		tr.Pull(nil, nil, *n2)
-	})
-
-	t.Run("probe", func(t *testing.T) {
-		defer func() {
-			if r := recover(); r == nil {
-				t.Errorf("The code did not panic")
-			}
-		}()
-		tr.Probe(nil, nil, brahms.NID{0x02}, *n2)
-	})
+func TestMemNetEmit(t *testing.T) {
+	n1 := brahms.N("127.0.0.1", 1)
+	n2 := brahms.N("127.0.0.1", 2)
+	r := rand.New(rand.NewSource(1))
+	p, _ := brahms.NewParams(0.45, 0.45, 0.1, 10, 10, 2, 0)
+
+	tr := NewMemNetTransport()
+	c1 := brahms.NewCore(r, n1, brahms.NewView(), p, brahms.AlwaysRefresh, tr, time.Second)
+	tr.AddCore(c1)
+	c2 := brahms.NewCore(r, n2, brahms.NewView(), p, brahms.AlwaysRefresh, tr, time.Second)
+
+	var got []byte
+	tr.AddPeer(*n2, WithEmit(CorePeer(c2), func(ctx context.Context, msg []byte) error {
+		got = msg
+		return nil
+	}))
+
+	// only peers with an emit handler accept emits
+	test.Equals(t, brahms.ErrRefused, tr.Emit(context.Background(), []byte("foo"), *n1))
+	test.Ok(t, tr.Emit(context.Background(), []byte("foo"), *n2))
+	test.Equals(t, []byte("foo"), got)
+	test.Ok(t, tr.Probe(context.Background(), *n2))
 }
 
 func TestMockTransportProbe(t *testing.T) {
 	tr := NewMockTransport()
-	c := make(chan brahms.NID, 1)
-	tr.Probe(context.Background(), c, brahms.NID{0x01}, brahms.Node{})
-	test.Equals(t, brahms.NID{0x01}, <-c)
+	test.Ok(t, tr.Probe(context.Background(), brahms.Node{}))
+	test.Ok(t, tr.ProbeReq(context.Background(), brahms.Node{}, brahms.Node{}))
+
+	// only mocked pulls are answered
+	n1 := brahms.N("127.0.0.1", 1)
+	_, err := tr.Pull(context.Background(), *n1)
+	test.Equals(t, brahms.ErrRefused, err)
+	tr.SetPull(n1.Hash(), brahms.NewView(n1))
+	v, err := tr.Pull(context.Background(), *n1)
+	test.Ok(t, err)
+	test.Equals(t, brahms.NewView(n1), v)
 }
@@ -77,10 +77,63 @@ func (v View) Pick(r *rand.Rand, n int) (p View) {
 	return
 }
 
-// Concat views to this view and return it
+// PickDiverse picks at most n random members like Pick, but skips members of
+// subnets that already have max members in the picked view or the others.
+// Members that are already in the others are skipped, a max of zero or less
+// picks like Pick does.
+func (v View) PickDiverse(r *rand.Rand, n, max int, others ...View) (p View) {
+	if max <= 0 {
+		return v.Pick(r, n)
+	}
+
+	subnets := map[string]int{}
+	seen := map[NID]struct{}{}
+	for _, o := range others {
+		for id, n := range o {
+			if _, ok := seen[id]; !ok {
+				seen[id] = struct{}{}
+				subnets[n.Subnet()]++
+			}
+		}
+	}
+
+	ns := v.Sorted()
+	r.Shuffle(len(ns), func(i int, j int) {
+		ns[i], ns[j] = ns[j], ns[i]
+	})
+
+	p = View{}
+	for _, nn := range ns {
+		if len(p) >= n {
+			break
+		}
+
+		id := nn.Hash()
+		if _, ok := seen[id]; ok {
+			continue
+		}
+
+		sn := nn.Subnet()
+		if subnets[sn] >= max {
+			continue
+		}
+
+		subnets[sn]++
+		p[id] = nn
+	}
+
+	return
+}
+
+// Concat views to this view and return it. If a node appears more then once
+// the newest info is kept.
 func (v View) Concat(vs ...View) View {
 	for _, vv := range vs {
 		for id, n := range vv {
+			if o, ok := v[id]; ok && o.Newer(n) {
+				continue //we already know newer info
+			}
+
 			v[id] = n
 		}
 	}
@@ -116,3 +169,24 @@ func (v View) Diff(other View) (d View) {
 
 	return
 }
+
+// Filter returns all elements of this view for which f returns true
+func (v View) Filter(f func(n Node) bool) (fv View) {
+	fv = View{}
+	for id, n := range v {
+		if f(n) {
+			fv[id] = n
+		}
+	}
+
+	return
+}
+
+// Where returns all elements of this view that have the metadata key set to
+// the provided value, e.g: Where("zone", "eu-west")
+func (v View) Where(key, value string) View {
+	return v.Filter(func(n Node) bool {
+		mv, ok := n.Meta[key]
+		return ok && mv == value
+	})
+}
@@ -60,6 +60,13 @@ func TestViewConcat(t *testing.T) {
 	v1.Concat(v2, v3)
 
 	test.Equals(t, NewView(n1, n2, n3, n4, n5, n6), v1)
+
+	n7 := N("127.0.0.1", 1)
+	n7.Meta, n7.Ver = Meta{"role": "db"}, 1
+	v1.Concat(NewView(n7))
+	test.Equals(t, *n7, v1[n1.Hash()])
+	v1.Concat(NewView(n1)) //older info doesn't replace newer info
+	test.Equals(t, *n7, v1[n1.Hash()])
 }
 
 func TestViewCopy(t *testing.T) {
@@ -92,3 +99,40 @@ func TestViewDiff(t *testing.T) {
 	test.Equals(t, NewView(n2, n1), NewView(n2, n1).Diff(NewView(n3)))
 	test.Equals(t, NewView(n2), NewView(n2, n1).Diff(NewView(n3, n1)))
 }
+
+func TestViewPickDiverse(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	v := NewView()
+	for i := 0; i < 100; i++ {
+		v.Concat(NewView(N("10.0.0.1", uint16(i+1))))
+	}
+
+	n1, n2 := N("10.0.1.1", 1), N("10.0.2.1", 1)
+	v.Concat(NewView(n1, n2))
+
+	// without a max it picks like pick
+	test.Equals(t, 10, len(v.PickDiverse(r, 10, 0)))
+
+	p := v.PickDiverse(r, 10, 2)
+	test.Equals(t, 4, len(p))
+	test.Equals(t, NewView(n1, n2), p.Inter(NewView(n1, n2)))
+
+	// nodes in the others count towards the max and are not picked again
+	p2 := v.PickDiverse(r, 10, 3, p)
+	test.Equals(t, 1, len(p2))
+	test.Equals(t, 0, len(p2.Inter(p)))
+}
+
+func TestViewWhere(t *testing.T) {
+	n1 := N("127.0.0.1", 1)
+	n1.Meta = Meta{"zone": "eu", "role": "db"}
+	n2 := N("127.0.0.1", 2)
+	n2.Meta = Meta{"zone": "us", "role": "db"}
+	n3 := N("127.0.0.1", 3)
+
+	v := NewView(n1, n2, n3)
+	test.Equals(t, NewView(n1), v.Where("zone", "eu"))
+	test.Equals(t, NewView(n1, n2), v.Where("role", "db"))
+	test.Equals(t, View{}, v.Where("role", ""))
+	test.Equals(t, NewView(n2, n3), v.Filter(func(n Node) bool { return n.Meta["zone"] != "eu" }))
+}